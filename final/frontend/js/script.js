@@ -197,9 +197,10 @@ const socket = new WebSocket('ws://localhost:8080/ws');
 const name = localStorage.getItem("username");
 
 
-socket.onopen = function () {
+function register() {
     if (name) {
         socket.send(JSON.stringify({
+            v: 1,
             type: "register",
             payload: {
                 username: name,
@@ -208,7 +209,9 @@ socket.onopen = function () {
     } else {
         console.error("Username not found in local storage.");
     }
-};
+}
+
+socket.onopen = register;
 
 
 socket.addEventListener('message', (event) => {
@@ -217,6 +220,13 @@ socket.addEventListener('message', (event) => {
     console.log('Received message:', message);
     const gameover = document.getElementById("game-over-container");
    
+    if (message.type === "error") {
+        console.error("Server rejected message:", message.payload.code, message.payload.message);
+    }
+    if (message.type === "server_draining") {
+        // The gateway moves us to another game server; register there once it has.
+        setTimeout(register, 1000);
+    }
     if (message.type === "player_list") {
         console.log("Updated player list:", message.payload.players);
         updatePlayerList(message.payload.players);  
@@ -0,0 +1,332 @@
+// Package integration runs the gateway and both game servers in one process
+// and drives them over HTTP and WebSocket the way the frontend does.
+package integration
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mainControllers "scrambled_words/controllers"
+	"scrambled_words/gateway"
+	mainModels "scrambled_words/models"
+	mainStore "scrambled_words/store"
+
+	secondControllers "second_server/controllers"
+	secondModels "second_server/models"
+	secondRoutes "second_server/routes"
+	secondShared "second_server/shared"
+	secondStore "second_server/store"
+
+	thirdControllers "third_server/controllers"
+	thirdModels "third_server/models"
+	thirdRoutes "third_server/routes"
+	thirdShared "third_server/shared"
+	thirdStore "third_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Harness is a running gateway with two game servers behind it. Users,
+// matches and the game event log live in the gateway's memory stores, which
+// all three share like the MongoDB database, and the game servers share an
+// event bus, like Redis pub/sub. Each game server keeps its own game state.
+type Harness struct {
+	GatewayURL string
+	// Backends are the game servers in the gateway's order of preference.
+	Backends []*Backend
+
+	users *mainStore.MemoryUserStore
+
+	// requests tracks the handlers still running, WebSockets included. The
+	// servers keep their state in package variables, so the next harness
+	// may only reload it once the last one has returned.
+	requests sync.WaitGroup
+}
+
+// track counts the requests running in handler.
+func (h *Harness) track(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.requests.Add(1)
+		defer h.requests.Done()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// stop shuts everything down and waits for the handlers to return.
+func (h *Harness) stop(t *testing.T) {
+	for _, b := range h.Backends {
+		b.Kill()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.requests.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Error("handlers still running after the harness stopped")
+	}
+}
+
+// user finds a user in the shared store, or returns the zero user.
+func (h *Harness) user(id string) mainModels.User {
+	user, err := h.users.FindByID(context.Background(), id)
+	if err != nil {
+		return mainModels.User{}
+	}
+	return *user
+}
+
+// Word returns the unscrambled word currently assigned to a user, which is
+// what a player who solved the puzzle would guess.
+func (h *Harness) Word(id string) string {
+	return h.user(id).Word
+}
+
+// Score returns a user's score in the current game.
+func (h *Harness) Score(id string) int {
+	return h.user(id).Score
+}
+
+// Wins returns how many games a user has won.
+func (h *Harness) Wins(id string) int {
+	return h.user(id).Wins
+}
+
+// Backend is a game server listening on an ephemeral port.
+type Backend struct {
+	Name string
+	URL  string
+
+	server   *http.Server
+	listener *trackingListener
+	mongo    *dependency
+}
+
+// FailDependency makes the game server's MongoDB check fail with err, as if
+// the database had gone away, until it is called again with nil.
+func (b *Backend) FailDependency(err error) {
+	b.mongo.fail(err)
+}
+
+// dependency stands in for the database a game server's readiness check
+// pings.
+type dependency struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (d *dependency) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+}
+
+func (d *dependency) Ping(context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Kill stops the game server the way a crash would: the listener goes away
+// and every open connection, WebSockets included, is cut without a close
+// handshake.
+func (b *Backend) Kill() {
+	b.listener.Close()
+	b.listener.closeConns()
+}
+
+// harnessStores are the stores of one harness. Users, matches and the game
+// event log are the gateway's; the game servers reach them through
+// sharedUsers and sharedEvents, and their shared matchmaking queue and rooms
+// through harnessQueue and harnessRooms.
+type harnessStores struct {
+	users       *mainStore.MemoryUserStore
+	gameEvents  *mainStore.MemoryEventLog
+	events      *secondStore.MemoryEventBus
+	secondGame  *secondStore.MemoryGameStateStore
+	thirdGame   *thirdStore.MemoryGameStateStore
+	queue       *secondStore.MemoryQueueStore
+	rooms       *secondStore.MemoryRoomStore
+	secondMongo *dependency
+	thirdMongo  *dependency
+}
+
+// current holds the stores of the running harness.
+var current atomic.Pointer[harnessStores]
+
+// The game servers keep their stores in package variables, which goroutines
+// their handlers leave running, such as the player list broadcast, may still
+// read after a test ends. So they are configured once per process, with
+// stores that pass every call on to those in current, rather than once per
+// harness. Their broadcasters read from package-level channels and are
+// started once per process too.
+var startServers sync.Once
+
+func configureServers() {
+	secondUsers := &sharedUsers[secondModels.User, secondModels.Match]{errs: storeErrors{
+		mainStore.ErrNotFound:    secondStore.ErrNotFound,
+		mainStore.ErrInvalidID:   secondStore.ErrInvalidID,
+		mainStore.ErrEmailTaken:  secondStore.ErrEmailTaken,
+		mainStore.ErrWordChanged: secondStore.ErrWordChanged,
+	}}
+	secondControllers.Configure(secondStore.NewWriteBehind(secondStore.DefaultMaxPendingWrites).Wrap(secondStore.Stores{
+		Users: secondUsers,
+		GameState: harnessGameState[secondModels.GameState, secondModels.Player]{
+			get: func(s *harnessStores) gameStates[secondModels.GameState, secondModels.Player] { return s.secondGame },
+		},
+		Leaderboard: secondUsers,
+		EventLog:    &sharedEvents[secondModels.GameEvent]{},
+		Events:      harnessBus{},
+		Matchmaking: harnessQueue[secondModels.QueueEntry]{},
+		Rooms:       harnessRooms[secondModels.Room]{},
+		Health: []secondStore.HealthCheck{{Name: "mongo", Check: func(ctx context.Context) error {
+			return current.Load().secondMongo.Ping(ctx)
+		}}},
+	}))
+	secondControllers.ConfigureChat([]string{"darn"}, nil)
+
+	thirdUsers := &sharedUsers[thirdModels.User, thirdModels.Match]{errs: storeErrors{
+		mainStore.ErrNotFound:    thirdStore.ErrNotFound,
+		mainStore.ErrInvalidID:   thirdStore.ErrInvalidID,
+		mainStore.ErrEmailTaken:  thirdStore.ErrEmailTaken,
+		mainStore.ErrWordChanged: thirdStore.ErrWordChanged,
+	}}
+	thirdControllers.Configure(thirdStore.NewWriteBehind(thirdStore.DefaultMaxPendingWrites).Wrap(thirdStore.Stores{
+		Users: thirdUsers,
+		GameState: harnessGameState[thirdModels.GameState, thirdModels.Player]{
+			get: func(s *harnessStores) gameStates[thirdModels.GameState, thirdModels.Player] { return s.thirdGame },
+		},
+		Leaderboard: thirdUsers,
+		EventLog:    &sharedEvents[thirdModels.GameEvent]{},
+		Events:      harnessBus{},
+		Matchmaking: harnessQueue[thirdModels.QueueEntry]{},
+		Rooms:       harnessRooms[thirdModels.Room]{},
+		Health: []thirdStore.HealthCheck{{Name: "mongo", Check: func(ctx context.Context) error {
+			return current.Load().thirdMongo.Ping(ctx)
+		}}},
+	}))
+	thirdControllers.ConfigureChat([]string{"darn"}, nil)
+
+	go secondShared.BroadcastMessages()
+	go thirdShared.BroadcastMessages()
+}
+
+// Start runs the gateway and both game servers until the test ends.
+func Start(t *testing.T) *Harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	stores := &harnessStores{
+		users:       mainStore.NewMemoryUserStore(),
+		gameEvents:  mainStore.NewMemoryEventLog(),
+		events:      secondStore.NewMemoryEventBus(),
+		secondGame:  secondStore.NewMemoryGameStateStore(),
+		thirdGame:   thirdStore.NewMemoryGameStateStore(),
+		queue:       secondStore.NewMemoryQueueStore(),
+		rooms:       secondStore.NewMemoryRoomStore(),
+		secondMongo: &dependency{},
+		thirdMongo:  &dependency{},
+	}
+	current.Store(stores)
+	startServers.Do(configureServers)
+
+	h := &Harness{users: stores.users}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		h.stop(t)
+	})
+
+	secondControllers.LoadGameState()
+	secondControllers.LoadRooms()
+	secondControllers.StartEventListener(ctx)
+	secondControllers.StartMatchmaker(ctx)
+	secondControllers.StartWriteBehind(ctx)
+
+	thirdControllers.LoadGameState()
+	thirdControllers.LoadRooms()
+	thirdControllers.StartEventListener(ctx)
+	thirdControllers.StartMatchmaker(ctx)
+	thirdControllers.StartWriteBehind(ctx)
+
+	h.Backends = []*Backend{
+		startBackend(t, "second_server", h.track(secondRoutes.NewRouter(nil)), stores.secondMongo),
+		startBackend(t, "third_server", h.track(thirdRoutes.NewRouter(nil)), stores.thirdMongo),
+	}
+
+	mainControllers.Configure(mainStore.Stores{
+		Users:       stores.users,
+		GameState:   mainStore.NewMemoryGameStateStore(),
+		Leaderboard: stores.users,
+		Matches:     stores.users,
+		EventLog:    stores.gameEvents,
+		Events:      stores.events,
+	})
+	mainControllers.LoadGameState()
+
+	gateway.GameServers = []string{h.Backends[0].URL, h.Backends[1].URL}
+	gw := httptest.NewServer(h.track(gateway.NewRouter(nil)))
+	t.Cleanup(gw.Close)
+	h.GatewayURL = gw.URL
+
+	return h
+}
+
+func startBackend(t *testing.T, name string, handler http.Handler, mongo *dependency) *Backend {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen for %s: %v", name, err)
+	}
+
+	b := &Backend{
+		Name:     name,
+		URL:      "http://" + ln.Addr().String(),
+		server:   &http.Server{Handler: handler},
+		listener: &trackingListener{Listener: ln, conns: make(map[net.Conn]struct{})},
+		mongo:    mongo,
+	}
+	go b.server.Serve(b.listener)
+	return b
+}
+
+// trackingListener remembers every accepted connection so that Kill can cut
+// hijacked WebSocket connections, which http.Server forgets about.
+type trackingListener struct {
+	net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.conns[conn] = struct{}{}
+	l.mu.Unlock()
+	return conn, nil
+}
+
+func (l *trackingListener) closeConns() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = make(map[net.Conn]struct{})
+}
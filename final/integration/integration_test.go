@@ -0,0 +1,1092 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client is a player talking to the gateway.
+type client struct {
+	t    *testing.T
+	h    *Harness
+	ws   *websocket.Conn
+	ID   string
+	Name string
+}
+
+func postJSON(t *testing.T, url string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var reply map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	return resp.StatusCode, reply
+}
+
+// signUp creates an account through the gateway and logs in with it.
+func signUp(t *testing.T, h *Harness, name string) *client {
+	t.Helper()
+
+	account := map[string]string{"username": name, "email": name + "@example.com", "password": "secret"}
+	status, reply := postJSON(t, h.GatewayURL+"/signup", account)
+	require.Equal(t, http.StatusOK, status, reply)
+
+	status, reply = postJSON(t, h.GatewayURL+"/login", map[string]string{"email": account["email"], "password": "wrong"})
+	require.Equal(t, http.StatusUnauthorized, status, reply)
+
+	status, reply = postJSON(t, h.GatewayURL+"/login", map[string]string{"email": account["email"], "password": "secret"})
+	require.Equal(t, http.StatusOK, status, reply)
+
+	return &client{t: t, h: h, ID: reply["user_id"].(string), Name: name}
+}
+
+// connect opens the player's WebSocket through the gateway and returns the
+// game server it was proxied to.
+func (c *client) connect() string {
+	c.t.Helper()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(c.h.GatewayURL, "http")+"/ws", nil)
+	require.NoError(c.t, err)
+	c.t.Cleanup(func() { ws.Close() })
+	c.ws = ws
+
+	return c.waitForBackend()
+}
+
+// waitForBackend reads until the gateway reports which game server the
+// player is connected to.
+func (c *client) waitForBackend() string {
+	c.t.Helper()
+
+	const prefix = "Connected to game server: "
+	var backend string
+	c.readUntil(func(data []byte) bool {
+		backend = strings.TrimPrefix(string(data), prefix)
+		return strings.HasPrefix(string(data), prefix)
+	})
+	return backend
+}
+
+func (c *client) register() {
+	c.t.Helper()
+	c.registerOn("")
+}
+
+// registerOn registers the player on a team of their choosing, or on the
+// one the server picks when team is empty.
+func (c *client) registerOn(team string) {
+	c.t.Helper()
+
+	payload := map[string]string{"username": c.Name}
+	if team != "" {
+		payload["team"] = team
+	}
+	msg := map[string]interface{}{"v": 1, "type": "register", "payload": payload}
+	require.NoError(c.t, c.ws.WriteJSON(msg))
+
+	c.readMessage("player_list", func(payload json.RawMessage) bool {
+		var list struct {
+			Players []struct {
+				Name string `json:"name"`
+			} `json:"players"`
+		}
+		require.NoError(c.t, json.Unmarshal(payload, &list))
+		for _, p := range list.Players {
+			if p.Name == c.Name {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// readMessage reads until a protocol message of the given type matches.
+func (c *client) readMessage(msgType string, match func(json.RawMessage) bool) json.RawMessage {
+	c.t.Helper()
+
+	var payload json.RawMessage
+	c.readUntil(func(data []byte) bool {
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if json.Unmarshal(data, &msg) != nil || msg.Type != msgType {
+			return false
+		}
+		payload = msg.Payload
+		return match(payload)
+	})
+	return payload
+}
+
+func (c *client) readUntil(match func([]byte) bool) {
+	c.t.Helper()
+
+	c.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := c.ws.ReadMessage()
+		require.NoError(c.t, err)
+		if match(data) {
+			return
+		}
+	}
+}
+
+func (c *client) start() {
+	c.t.Helper()
+
+	status, reply := postJSON(c.t, c.h.GatewayURL+"/start", map[string]string{"player_id": c.ID})
+	require.Equal(c.t, http.StatusOK, status, reply)
+	require.Equal(c.t, c.h.Word(c.ID), reply["word"])
+}
+
+// solve submits the right answer for the player's current word.
+func (c *client) solve() map[string]interface{} {
+	c.t.Helper()
+
+	status, reply := postJSON(c.t, c.h.GatewayURL+"/submit", map[string]string{"player_id": c.ID, "guess": c.h.Word(c.ID)})
+	require.Equal(c.t, http.StatusOK, status, reply)
+	require.Equal(c.t, true, reply["correct"], reply)
+	return reply
+}
+
+func TestPlayerWinsAGameThroughTheGateway(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+
+	assert.Equal(t, h.Backends[0].URL, kal.connect())
+	kal.register()
+	kal.start()
+
+	status, reply := postJSON(t, h.GatewayURL+"/submit", map[string]string{"player_id": kal.ID, "guess": "definitely wrong"})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, false, reply["correct"])
+
+	kal.solve()
+	kal.solve()
+	reply = kal.solve()
+	assert.Equal(t, "kal won the game!", reply["message"])
+
+	kal.readMessage("game_over", func(payload json.RawMessage) bool {
+		var over struct {
+			Winner string `json:"winner"`
+		}
+		require.NoError(t, json.Unmarshal(payload, &over))
+		return over.Winner == "kal"
+	})
+	assert.Equal(t, 1, h.Wins(kal.ID))
+
+	resp, err := http.Get(h.GatewayURL + "/leaderboard")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var board struct {
+		Leaderboard []struct {
+			Username string `json:"username"`
+			Wins     int    `json:"wins"`
+		} `json:"leaderboard"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&board))
+	require.NotEmpty(t, board.Leaderboard)
+	assert.Equal(t, "kal", board.Leaderboard[0].Username)
+	assert.Equal(t, 1, board.Leaderboard[0].Wins)
+}
+
+// matchPage is one page of the match history APIs.
+type matchPage struct {
+	Matches []struct {
+		ID       string `json:"id"`
+		WinnerID string `json:"winner_id"`
+		Winner   string `json:"winner"`
+		Players  []struct {
+			ID           string `json:"id"`
+			Name         string `json:"name"`
+			Score        int    `json:"score"`
+			Team         string `json:"team"`
+			Rating       int    `json:"rating"`
+			RatingChange int    `json:"rating_change"`
+		} `json:"players"`
+		Teams []struct {
+			Name  string `json:"name"`
+			Score int    `json:"score"`
+		} `json:"teams"`
+		WinningTeam string `json:"winning_team"`
+		Words       []struct {
+			Player string `json:"player"`
+			Word   string `json:"word"`
+		} `json:"words"`
+		DurationMS int64 `json:"duration_ms"`
+	} `json:"matches"`
+	HasMore bool `json:"has_more"`
+}
+
+func getMatches(t *testing.T, url string) matchPage {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var page matchPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	return page
+}
+
+func TestFinishedGamesShowUpInMatchHistory(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	sara := signUp(t, h, "sara")
+
+	kal.connect()
+	kal.register()
+	sara.connect()
+	sara.register()
+	kal.start()
+	sara.start()
+
+	for i := 0; i < 2; i++ {
+		kal.solve()
+	}
+	assert.Equal(t, "kal won the game!", kal.solve()["message"])
+
+	recent := getMatches(t, h.GatewayURL+"/matches/recent")
+	require.Len(t, recent.Matches, 1)
+	match := recent.Matches[0]
+	assert.Equal(t, kal.ID, match.WinnerID)
+	assert.Equal(t, "kal", match.Winner)
+	require.Len(t, match.Players, 2)
+	assert.Equal(t, "kal", match.Players[0].Name, "the winner is listed first")
+	assert.Equal(t, 3, match.Players[0].Score)
+	assert.Equal(t, "sara", match.Players[1].Name)
+	require.Len(t, match.Words, 3)
+	for _, w := range match.Words {
+		assert.Equal(t, "kal", w.Player)
+		assert.NotEmpty(t, w.Word)
+	}
+	assert.False(t, recent.HasMore)
+
+	// A second game, won by sara, pushes the first one to the next page.
+	for i := 0; i < 3; i++ {
+		sara.solve()
+	}
+	page := getMatches(t, h.GatewayURL+"/users/"+kal.ID+"/matches?limit=1")
+	require.Len(t, page.Matches, 1)
+	assert.Equal(t, "sara", page.Matches[0].Winner)
+	assert.True(t, page.HasMore)
+
+	page = getMatches(t, h.GatewayURL+"/users/"+kal.ID+"/matches?limit=1&page=2")
+	require.Len(t, page.Matches, 1)
+	assert.Equal(t, "kal", page.Matches[0].Winner)
+	assert.False(t, page.HasMore)
+
+	resp, err := http.Get(h.GatewayURL + "/users/not-an-id/matches")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(h.GatewayURL + "/matches/recent?limit=1000")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestMultiplayerMatchRatesEveryPlayer(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	sara := signUp(t, h, "sara")
+
+	kal.connect()
+	kal.register()
+	sara.connect()
+	sara.register()
+	kal.start()
+	sara.start()
+
+	for i := 0; i < 2; i++ {
+		kal.solve()
+	}
+	assert.Equal(t, "kal won the game!", kal.solve()["message"])
+
+	recent := getMatches(t, h.GatewayURL+"/matches/recent")
+	require.Len(t, recent.Matches, 1)
+	match := recent.Matches[0]
+	require.Len(t, match.Players, 2)
+	assert.Equal(t, 1016, match.Players[0].Rating)
+	assert.Equal(t, 16, match.Players[0].RatingChange)
+	assert.Equal(t, 984, match.Players[1].Rating)
+	assert.Equal(t, -16, match.Players[1].RatingChange)
+
+	resp, err := http.Get(h.GatewayURL + "/users/" + sara.ID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var profile struct {
+		Username      string `json:"username"`
+		Rating        int    `json:"rating"`
+		RatingHistory []struct {
+			MatchID string `json:"match_id"`
+			Rating  int    `json:"rating"`
+			Change  int    `json:"change"`
+		} `json:"rating_history"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&profile))
+	assert.Equal(t, "sara", profile.Username)
+	assert.Equal(t, 984, profile.Rating)
+	require.Len(t, profile.RatingHistory, 1)
+	assert.Equal(t, match.ID, profile.RatingHistory[0].MatchID)
+	assert.Equal(t, -16, profile.RatingHistory[0].Change)
+
+	resp, err = http.Get(h.GatewayURL + "/leaderboard?by=rating")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var board struct {
+		Leaderboard []struct {
+			Username string `json:"username"`
+			Rating   int    `json:"rating"`
+		} `json:"leaderboard"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&board))
+	require.Len(t, board.Leaderboard, 2)
+	assert.Equal(t, "kal", board.Leaderboard[0].Username)
+	assert.Equal(t, 1016, board.Leaderboard[0].Rating)
+
+	resp, err = http.Get(h.GatewayURL + "/leaderboard?by=score")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFinishedMatchCanBeReplayed(t *testing.T) {
+	h := Start(t)
+	// Sara plays a game of her own in a room alongside kal's, which his
+	// replay leaves out.
+	sara := signUp(t, h, "sara")
+	sara.connect()
+	sara.register()
+	require.NoError(t, sara.ws.WriteJSON(map[string]interface{}{
+		"v": 1, "type": "create_room", "payload": map[string]string{"name": "solo", "mode": "classic"},
+	}))
+	sara.readMessage("room", func(json.RawMessage) bool { return true })
+	kal := signUp(t, h, "kal")
+
+	kal.connect()
+	kal.register()
+	kal.start()
+	sara.start()
+	status, _ := postJSON(t, h.GatewayURL+"/submit", map[string]string{"player_id": kal.ID, "guess": "nope"})
+	require.Equal(t, http.StatusOK, status)
+	for i := 0; i < 3; i++ {
+		kal.solve()
+		if i == 0 {
+			sara.solve()
+		}
+	}
+
+	recent := getMatches(t, h.GatewayURL+"/matches/recent")
+	require.Len(t, recent.Matches, 1)
+	replayURL := h.GatewayURL + "/matches/" + recent.Matches[0].ID + "/replay"
+
+	resp, err := http.Get(replayURL + "?speed=1000")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(replayURL, "http")+"?speed=100", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	var types, events []string
+	var guesses []string
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg struct {
+			Type    string `json:"type"`
+			Payload struct {
+				Type   string `json:"type"`
+				Player string `json:"player"`
+				Guess  string `json:"guess"`
+			} `json:"payload"`
+		}
+		if err := ws.ReadJSON(&msg); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+			break
+		}
+		types = append(types, msg.Type)
+		if msg.Type == "replay_event" {
+			events = append(events, msg.Payload.Type)
+			if msg.Payload.Type != "join" {
+				assert.Equal(t, "kal", msg.Payload.Player, "only kal's game is replayed")
+			}
+			if msg.Payload.Guess != "" {
+				guesses = append(guesses, msg.Payload.Guess)
+			}
+		}
+	}
+
+	require.NotEmpty(t, types)
+	assert.Equal(t, "replay_start", types[0])
+	assert.Equal(t, "replay_end", types[len(types)-1])
+	assert.Equal(t, []string{
+		"join", "join",
+		"word_assigned",
+		"guess",
+		"guess", "correct", "word_assigned",
+		"guess", "correct", "word_assigned",
+		"guess", "correct", "word_assigned",
+		"game_over",
+	}, events)
+	assert.Equal(t, "nope", guesses[0])
+}
+
+// playerList is a player_list payload.
+type playerList struct {
+	Players []struct {
+		Name      string `json:"name"`
+		Score     int    `json:"score"`
+		Scrambled string `json:"scrambled"`
+	} `json:"players"`
+	Spectators int `json:"spectators"`
+}
+
+func sortedLetters(word string) string {
+	letters := strings.Split(word, "")
+	sort.Strings(letters)
+	return strings.Join(letters, "")
+}
+
+func TestSpectatorWatchesWithoutPlaying(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.register()
+
+	watcher := &client{t: t, h: h}
+	watcher.connect()
+	require.NoError(t, watcher.ws.WriteJSON(map[string]interface{}{"v": 1, "type": "spectate"}))
+	watcher.readMessage("spectating", func(json.RawMessage) bool { return true })
+
+	kal.readMessage("player_list", func(payload json.RawMessage) bool {
+		var list playerList
+		require.NoError(t, json.Unmarshal(payload, &list))
+		return list.Spectators == 1
+	})
+
+	kal.start()
+	word := h.Word(kal.ID)
+	watcher.readMessage("player_list", func(payload json.RawMessage) bool {
+		var list playerList
+		require.NoError(t, json.Unmarshal(payload, &list))
+		for _, p := range list.Players {
+			if p.Name == "kal" && p.Scrambled != "" {
+				assert.Equal(t, sortedLetters(word), sortedLetters(p.Scrambled))
+				return true
+			}
+		}
+		return false
+	})
+	kal.readMessage("player_list", func(payload json.RawMessage) bool {
+		assert.NotContains(t, string(payload), "scrambled", "only spectators see the words")
+		return true
+	})
+
+	kal.solve()
+	solved := watcher.readMessage("word_solved", func(json.RawMessage) bool { return true })
+	assert.JSONEq(t, `{"player":"kal","score":1}`, string(solved))
+
+	require.NoError(t, watcher.ws.WriteJSON(map[string]interface{}{
+		"v": 1, "id": "g1", "type": "submit_guess", "payload": map[string]string{"guess": word},
+	}))
+	rejected := watcher.readMessage("error", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(rejected), `"code":"spectator"`)
+	assert.Equal(t, 1, h.Score(kal.ID), "the spectator's guess did not count")
+}
+
+func TestRaceRoundsGoToTheFirstSolver(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.register()
+	abebe := signUp(t, h, "abebe")
+	abebe.connect()
+	abebe.register()
+
+	status, reply := postJSON(t, h.GatewayURL+"/start", map[string]string{"player_id": kal.ID, "mode": "race"})
+	require.Equal(t, http.StatusOK, status, reply)
+	assert.Equal(t, "race", reply["mode"])
+	first := h.Word(kal.ID)
+	assert.Equal(t, sortedLetters(first), sortedLetters(reply["word"].(string)), "the word is only given out scrambled")
+
+	// Anyone joining plays the race, on the same word, and scores by the
+	// rounds they win, not by what was left of their last game.
+	require.NoError(t, h.users.SetScore(context.Background(), abebe.ID, 2))
+	status, reply = postJSON(t, h.GatewayURL+"/start", map[string]string{"player_id": abebe.ID, "mode": "classic"})
+	require.Equal(t, http.StatusOK, status, reply)
+	assert.Equal(t, "race", reply["mode"])
+	assert.Equal(t, first, h.Word(abebe.ID))
+
+	kal.solve()
+	type roundResult struct {
+		Round    int    `json:"round"`
+		Word     string `json:"word"`
+		Winner   string `json:"winner"`
+		NextWord string `json:"next_word"`
+		Players  []struct {
+			Player  string `json:"player"`
+			Solved  bool   `json:"solved"`
+			SolveMS int64  `json:"solve_ms"`
+		} `json:"players"`
+		Times []struct {
+			Player  string `json:"player"`
+			SolveMS int64  `json:"solve_ms"`
+		} `json:"times"`
+	}
+	var result roundResult
+	require.NoError(t, json.Unmarshal(abebe.readMessage("round_result", func(json.RawMessage) bool { return true }), &result))
+	assert.Equal(t, 1, result.Round)
+	assert.Equal(t, first, result.Word)
+	assert.Equal(t, "kal", result.Winner)
+	assert.NotEmpty(t, result.NextWord)
+	require.Len(t, result.Times, 1)
+	assert.Equal(t, "kal", result.Times[0].Player)
+	require.Len(t, result.Players, 2, "everyone racing has a result")
+	assert.Equal(t, "kal", result.Players[0].Player)
+	assert.True(t, result.Players[0].Solved)
+	assert.Equal(t, "abebe", result.Players[1].Player)
+	assert.False(t, result.Players[1].Solved)
+	assert.Zero(t, result.Players[1].SolveMS)
+
+	// The first word is gone for everyone.
+	status, reply = postJSON(t, h.GatewayURL+"/submit", map[string]string{"player_id": abebe.ID, "guess": first})
+	require.Equal(t, http.StatusOK, status, reply)
+	assert.Equal(t, false, reply["correct"])
+
+	second := h.Word(kal.ID)
+	assert.Equal(t, sortedLetters(second), sortedLetters(result.NextWord))
+	status, reply = postJSON(t, h.GatewayURL+"/submit", map[string]string{"player_id": abebe.ID, "guess": second})
+	require.Equal(t, http.StatusOK, status, reply)
+	assert.Equal(t, true, reply["correct"])
+
+	result = roundResult{}
+	require.NoError(t, json.Unmarshal(kal.readMessage("round_result", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"round":2`)
+	}), &result))
+	assert.Equal(t, "abebe", result.Winner)
+	require.Len(t, result.Times, 2)
+	assert.Equal(t, "abebe", result.Times[1].Player)
+	assert.Equal(t, 1, h.Score(kal.ID))
+	assert.Equal(t, 1, h.Score(abebe.ID))
+}
+
+func TestTeamMatchIsWonByTheFirstTeamToTheTarget(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.registerOn("red")
+	sara := signUp(t, h, "sara")
+	sara.connect()
+	sara.registerOn("blue")
+	// With the teams even, a player who picks no team joins the first one.
+	abebe := signUp(t, h, "abebe")
+	abebe.connect()
+	abebe.register()
+	list := sara.readMessage("player_list", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"name":"abebe","score":0,"team":"red"`)
+	})
+	assert.Contains(t, string(list), `"teams":[{"name":"red"`)
+
+	status, reply := postJSON(t, h.GatewayURL+"/start", map[string]string{"player_id": kal.ID, "mode": "team"})
+	require.Equal(t, http.StatusOK, status, reply)
+	assert.Equal(t, "team", reply["mode"])
+	sara.start()
+	abebe.start()
+
+	// Reaching the individual winning score does not end a team game.
+	for i := 0; i < 3; i++ {
+		reply = kal.solve()
+	}
+	assert.Equal(t, "Correct! New word assigned.", reply["message"])
+	sara.solve()
+	abebe.solve()
+	reply = abebe.solve()
+	assert.Equal(t, "Team red won the game!", reply["message"])
+	assert.Equal(t, "red", reply["winning_team"])
+
+	over := sara.readMessage("game_over", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(over), `"winner":"abebe"`)
+	assert.Contains(t, string(over), `"team":"red"`)
+
+	recent := getMatches(t, h.GatewayURL+"/matches/recent")
+	require.Len(t, recent.Matches, 1)
+	match := recent.Matches[0]
+	assert.Equal(t, "red", match.WinningTeam)
+	require.Len(t, match.Teams, 2)
+	assert.Equal(t, "red", match.Teams[0].Name)
+	assert.Equal(t, 5, match.Teams[0].Score)
+	assert.Equal(t, "blue", match.Teams[1].Name)
+	assert.Equal(t, 1, match.Teams[1].Score)
+	teams := make(map[string]string)
+	for _, p := range match.Players {
+		teams[p.Name] = p.Team
+	}
+	assert.Equal(t, map[string]string{"kal": "red", "sara": "blue", "abebe": "red"}, teams)
+
+	// The whole winning team is credited with the win.
+	assert.Equal(t, 1, h.Wins(kal.ID))
+	assert.Equal(t, 1, h.Wins(abebe.ID))
+	assert.Equal(t, 0, h.Wins(sara.ID))
+}
+
+func TestMatchmakingPutsQueuedPlayersInARoom(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.register()
+	sara := signUp(t, h, "sara")
+	sara.connect()
+	sara.register()
+	send := func(c *client, msgType string, payload interface{}) {
+		require.NoError(t, c.ws.WriteJSON(map[string]interface{}{"v": 1, "type": msgType, "payload": payload}))
+	}
+
+	send(kal, "queue", map[string]string{"mode": "race"})
+	status := kal.readMessage("queue_status", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(status), `"position":1`)
+	assert.Contains(t, string(status), `"queued":1`)
+
+	// Players queued for another mode are not matched with kal.
+	send(sara, "queue", map[string]string{"mode": "classic"})
+	sara.readMessage("queue_status", func(json.RawMessage) bool { return true })
+	send(sara, "queue", map[string]string{"mode": "race"})
+
+	// The matched players are put in a private room of their own, hosted by
+	// the longest waiting one, before they are told of the match.
+	room := kal.readMessage("room", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"players":["kal","sara"]`)
+	})
+	assert.Contains(t, string(room), `"host":"kal"`)
+	assert.Contains(t, string(room), `"mode":"race"`)
+
+	var found struct {
+		Room    string `json:"room"`
+		Mode    string `json:"mode"`
+		Players []struct {
+			Name string `json:"name"`
+		} `json:"players"`
+	}
+	require.NoError(t, json.Unmarshal(kal.readMessage("match_found", func(json.RawMessage) bool { return true }), &found))
+	assert.NotEmpty(t, found.Room)
+	assert.Equal(t, "race", found.Mode)
+	require.Len(t, found.Players, 2)
+	assert.Equal(t, "kal", found.Players[0].Name, "the longest waiting player comes first")
+	assert.Equal(t, "sara", found.Players[1].Name)
+	assert.Contains(t, string(sara.readMessage("match_found", func(json.RawMessage) bool { return true })), `"room":"`+found.Room+`"`)
+	assert.Contains(t, string(room), `"room":"`+found.Room+`"`)
+
+	// Both are started in the race at once, on the same word.
+	started := kal.readMessage("start_game", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(started), `"mode":"race"`)
+	sara.readMessage("start_game", func(json.RawMessage) bool { return true })
+	assert.Equal(t, h.Word(kal.ID), h.Word(sara.ID))
+
+	// The room's game is theirs alone: their chat does not reach a player
+	// of the lobby game.
+	abebe := signUp(t, h, "abebe")
+	abebe.connect()
+	abebe.register()
+	send(kal, "chat", map[string]string{"text": "good luck"})
+	sara.readMessage("chat", func(json.RawMessage) bool { return true })
+	send(abebe, "chat", map[string]string{"text": "anyone there?"})
+	received := abebe.readMessage("chat", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(received), `"text":"anyone there?"`)
+
+	send(kal, "leave_queue", map[string]string{})
+	kal.readMessage("error", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"code":"not_found"`)
+	})
+}
+
+func TestPrivateRoomsAreJoinedByCodeOrInvite(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.register()
+	sara := signUp(t, h, "sara")
+	sara.connect()
+	sara.register()
+	abebe := signUp(t, h, "abebe")
+	abebe.connect()
+	abebe.register()
+	send := func(c *client, msgType string, payload interface{}) {
+		require.NoError(t, c.ws.WriteJSON(map[string]interface{}{"v": 1, "type": msgType, "payload": payload}))
+	}
+	anything := func(json.RawMessage) bool { return true }
+	errorCode := func(c *client, code string) {
+		c.readMessage("error", func(payload json.RawMessage) bool {
+			return strings.Contains(string(payload), `"code":"`+code+`"`)
+		})
+	}
+	type roomView struct {
+		Room     string   `json:"room"`
+		Host     string   `json:"host"`
+		Private  bool     `json:"private"`
+		Capacity int      `json:"capacity"`
+		Players  []string `json:"players"`
+		Code     string   `json:"code"`
+	}
+
+	send(kal, "create_room", map[string]interface{}{"name": "coworkers", "private": true, "capacity": 2})
+	var created roomView
+	require.NoError(t, json.Unmarshal(kal.readMessage("room", anything), &created))
+	assert.True(t, created.Private)
+	assert.Equal(t, 2, created.Capacity)
+	assert.Equal(t, "kal", created.Host)
+	require.Len(t, created.Code, 6)
+
+	send(abebe, "create_room", map[string]interface{}{"name": "anyone"})
+	abebe.readMessage("room", anything)
+	send(sara, "list_rooms", nil)
+	listed := string(sara.readMessage("room_list", anything))
+	assert.Contains(t, listed, `"name":"anyone"`)
+	assert.NotContains(t, listed, created.Room, "private rooms are not listed")
+
+	send(sara, "join_room", map[string]string{"room": created.Room})
+	errorCode(sara, "not_found")
+	send(sara, "join_room", map[string]string{"code": strings.ToLower(created.Code)})
+	var joined roomView
+	require.NoError(t, json.Unmarshal(sara.readMessage("room", anything), &joined))
+	assert.Equal(t, created.Room, joined.Room)
+	assert.Equal(t, []string{"kal", "sara"}, joined.Players)
+	assert.Empty(t, joined.Code, "only the host sees the code")
+	kal.readMessage("room", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"players":["kal","sara"]`)
+	})
+
+	send(abebe, "join_room", map[string]string{"code": created.Code})
+	errorCode(abebe, "room_full")
+
+	send(sara, "invite", nil)
+	errorCode(sara, "forbidden")
+	send(sara, "leave_room", nil)
+	sara.readMessage("left", anything)
+
+	send(kal, "invite", nil)
+	var invite struct {
+		Token string `json:"token"`
+		Link  string `json:"link"`
+	}
+	require.NoError(t, json.Unmarshal(kal.readMessage("invite_created", anything), &invite))
+	require.NotEmpty(t, invite.Token)
+	assert.True(t, strings.HasSuffix(invite.Link, invite.Token))
+
+	send(kal, "revoke_code", nil)
+	kal.readMessage("room", func(payload json.RawMessage) bool { return !strings.Contains(string(payload), `"code"`) })
+	send(abebe, "join_room", map[string]string{"invite": invite.Token})
+	errorCode(abebe, "not_found")
+	send(abebe, "join_room", map[string]string{"code": created.Code})
+	errorCode(abebe, "not_found")
+
+	send(kal, "invite", nil)
+	require.NoError(t, json.Unmarshal(kal.readMessage("invite_created", anything), &invite))
+	send(abebe, "join_room", map[string]string{"invite": invite.Token})
+	abebe.readMessage("room", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"players":["kal","abebe"]`)
+	})
+}
+
+func TestRoomsPlayGamesOfTheirOwn(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.register()
+	sara := signUp(t, h, "sara")
+	sara.connect()
+	sara.register()
+	abebe := signUp(t, h, "abebe")
+	abebe.connect()
+	abebe.register()
+	send := func(c *client, msgType string, payload interface{}) {
+		require.NoError(t, c.ws.WriteJSON(map[string]interface{}{"v": 1, "type": msgType, "payload": payload}))
+	}
+	anything := func(json.RawMessage) bool { return true }
+
+	send(kal, "create_room", map[string]interface{}{"name": "pair", "mode": "team"})
+	var created struct {
+		Room string `json:"room"`
+	}
+	require.NoError(t, json.Unmarshal(kal.readMessage("room", anything), &created))
+	send(sara, "join_room", map[string]string{"room": created.Room})
+	sara.readMessage("room", anything)
+
+	// The room's players are listed to its members only.
+	kal.readMessage("player_list", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"sara"`) && !strings.Contains(string(payload), `"abebe"`)
+	})
+	abebe.readMessage("player_list", func(payload json.RawMessage) bool {
+		return !strings.Contains(string(payload), `"kal"`) && !strings.Contains(string(payload), `"sara"`)
+	})
+
+	// The room plays the mode it was opened with, whatever is asked for.
+	status, reply := postJSON(t, h.GatewayURL+"/start", map[string]string{"player_id": kal.ID, "mode": "race"})
+	require.Equal(t, http.StatusOK, status, reply)
+	assert.Equal(t, "team", reply["mode"])
+	abebe.start()
+	kal.solve()
+	abebe.solve()
+
+	solved := sara.readMessage("word_solved", anything)
+	assert.Contains(t, string(solved), `"player":"kal"`)
+	solved = abebe.readMessage("word_solved", anything)
+	assert.Contains(t, string(solved), `"player":"abebe"`, "the room's messages do not reach the lobby")
+
+	send(abebe, "chat", map[string]string{"text": "anyone there?"})
+	abebe.readMessage("chat", anything)
+	send(sara, "chat", map[string]string{"text": "just us"})
+	received := kal.readMessage("chat", anything)
+	assert.Contains(t, string(received), `"text":"just us"`, "chat stays in its game")
+
+	// The room's host moderates the room only: a player of the lobby game
+	// is out of reach, and a kicked member leaves the room and stays out.
+	errorCode := func(c *client, code string) {
+		c.readMessage("error", func(payload json.RawMessage) bool {
+			return strings.Contains(string(payload), `"code":"`+code+`"`)
+		})
+	}
+	send(kal, "mute", map[string]string{"player": "abebe"})
+	errorCode(kal, "not_found")
+	send(kal, "kick", map[string]string{"player": "sara"})
+	sara.readMessage("moderation", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"action":"kick"`)
+	})
+	kal.readMessage("room", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"players":["kal"]`)
+	})
+	send(sara, "join_room", map[string]string{"room": created.Room})
+	errorCode(sara, "forbidden")
+	send(sara, "chat", map[string]string{"text": "back in the lobby"})
+	received = abebe.readMessage("chat", anything)
+	assert.Contains(t, string(received), `"text":"back in the lobby"`)
+}
+
+func TestSpectatorsWatchARoom(t *testing.T) {
+	h := Start(t)
+	kal := signUp(t, h, "kal")
+	kal.connect()
+	kal.register()
+	anything := func(json.RawMessage) bool { return true }
+	spectate := func(c *client, payload interface{}) {
+		require.NoError(t, c.ws.WriteJSON(map[string]interface{}{"v": 1, "type": "spectate", "payload": payload}))
+	}
+
+	require.NoError(t, kal.ws.WriteJSON(map[string]interface{}{
+		"v": 1, "type": "create_room", "payload": map[string]string{"name": "watched"},
+	}))
+	var created struct {
+		Room string `json:"room"`
+	}
+	require.NoError(t, json.Unmarshal(kal.readMessage("room", anything), &created))
+
+	lost := &client{t: t, h: h}
+	lost.connect()
+	spectate(lost, map[string]string{"room": "no-such-room"})
+	lost.readMessage("error", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"code":"not_found"`)
+	})
+
+	watcher := &client{t: t, h: h}
+	watcher.connect()
+	spectate(watcher, map[string]string{"room": created.Room})
+	watching := watcher.readMessage("spectating", anything)
+	assert.Contains(t, string(watching), `"room":"`+created.Room+`"`)
+	kal.readMessage("player_list", func(payload json.RawMessage) bool {
+		var list playerList
+		require.NoError(t, json.Unmarshal(payload, &list))
+		return list.Spectators == 1
+	})
+
+	kal.start()
+	watcher.readMessage("player_list", func(payload json.RawMessage) bool {
+		var list playerList
+		require.NoError(t, json.Unmarshal(payload, &list))
+		return len(list.Players) == 1 && list.Players[0].Name == "kal" && list.Players[0].Scrambled != ""
+	})
+	kal.solve()
+	solved := watcher.readMessage("word_solved", anything)
+	assert.JSONEq(t, `{"player":"kal","score":1}`, string(solved), "the room's game reaches its spectators")
+}
+
+func TestChatIsFilteredRateLimitedAndModerated(t *testing.T) {
+	h := Start(t)
+	// Mutes and kicks outlive the harness, so every run plays with names
+	// of its own.
+	run := time.Now().UnixNano() % 1e6
+	mira := signUp(t, h, fmt.Sprintf("mira%d", run))
+	mira.connect()
+	mira.register()
+	tomas := signUp(t, h, fmt.Sprintf("tomas%d", run))
+	tomas.connect()
+	tomas.register()
+
+	send := func(c *client, msgType string, payload interface{}) {
+		require.NoError(t, c.ws.WriteJSON(map[string]interface{}{"v": 1, "type": msgType, "payload": payload}))
+	}
+	chat := func(c *client, text string) {
+		send(c, "chat", map[string]string{"text": text})
+	}
+	errorCode := func(c *client, code string) {
+		c.readMessage("error", func(payload json.RawMessage) bool {
+			return strings.Contains(string(payload), `"code":"`+code+`"`)
+		})
+	}
+
+	chat(mira, "well darn it")
+	received := tomas.readMessage("chat", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(received), `"player":"`+mira.Name+`"`)
+	assert.Contains(t, string(received), `"text":"well **** it"`)
+
+	// A player who joins later is sent the recent messages.
+	nadia := signUp(t, h, fmt.Sprintf("nadia%d", run))
+	nadia.connect()
+	send(nadia, "register", map[string]string{"username": nadia.Name})
+	history := nadia.readMessage("chat_history", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(history), `"text":"well **** it"`)
+	mira.readMessage("player_list", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"`+nadia.Name+`"`)
+	})
+
+	for i := 0; i < 6; i++ {
+		chat(nadia, fmt.Sprintf("message %d", i))
+	}
+	errorCode(nadia, "rate_limited")
+
+	// Only the host, who joined first, may moderate.
+	send(tomas, "kick", map[string]string{"player": nadia.Name})
+	errorCode(tomas, "forbidden")
+
+	send(mira, "mute", map[string]interface{}{"player": tomas.Name, "minutes": 5})
+	muted := tomas.readMessage("moderation", func(json.RawMessage) bool { return true })
+	assert.Contains(t, string(muted), `"action":"mute"`)
+	chat(tomas, "hello?")
+	errorCode(tomas, "muted")
+
+	send(mira, "kick", map[string]string{"player": nadia.Name})
+	nadia.readMessage("moderation", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), `"action":"kick"`)
+	})
+	mira.readMessage("player_list", func(payload json.RawMessage) bool {
+		return !strings.Contains(string(payload), `"`+nadia.Name+`"`)
+	})
+	send(nadia, "register", map[string]string{"username": nadia.Name})
+	errorCode(nadia, "forbidden")
+}
+
+func TestGatewayFailsOverWhenBackendDies(t *testing.T) {
+	h := Start(t)
+	sara := signUp(t, h, "sara")
+
+	require.Equal(t, h.Backends[0].URL, sara.connect())
+	sara.register()
+	sara.start()
+	sara.solve()
+
+	h.Backends[0].Kill()
+
+	// The WebSocket is moved to the surviving server, and the first message
+	// sent after the move reaches it.
+	assert.Equal(t, h.Backends[1].URL, sara.waitForBackend())
+	sara.register()
+
+	// HTTP requests skip the dead server too.
+	reply := sara.solve()
+	assert.Equal(t, "Correct! New word assigned.", reply["message"])
+	sara.solve()
+
+	sara.readMessage("game_over", func(payload json.RawMessage) bool {
+		return strings.Contains(string(payload), "sara")
+	})
+	assert.Equal(t, 1, h.Wins(sara.ID))
+
+	metrics := gatewayMetrics(t, h)
+	assert.Contains(t, metrics, fmt.Sprintf("scrambled_gateway_backend_healthy{backend=%q} 0", h.Backends[0].URL))
+	assert.Contains(t, metrics, fmt.Sprintf("scrambled_gateway_backend_healthy{backend=%q} 1", h.Backends[1].URL))
+	assert.Contains(t, metrics, fmt.Sprintf("scrambled_gateway_proxied_websockets{backend=%q} 0", h.Backends[0].URL))
+	assert.Contains(t, metrics, fmt.Sprintf("scrambled_gateway_proxied_websockets{backend=%q} 1", h.Backends[1].URL))
+	assert.Contains(t, metrics, fmt.Sprintf("scrambled_gateway_backend_requests_total{backend=%q,code=\"200\"}", h.Backends[1].URL))
+}
+
+func gatewayMetrics(t *testing.T, h *Harness) string {
+	t.Helper()
+
+	resp, err := http.Get(h.GatewayURL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestGatewayPrefersBackendsThatAreReady(t *testing.T) {
+	h := Start(t)
+	omar := signUp(t, h, "omar")
+
+	h.Backends[0].FailDependency(errors.New("server selection timeout"))
+
+	// The server keeps playing without its database, buffering its writes,
+	// and says so.
+	resp, err := http.Get(h.Backends[0].URL + "/readyz")
+	require.NoError(t, err)
+	var readiness struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		} `json:"checks"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&readiness))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "degraded", readiness.Status)
+	assert.Equal(t, "server selection timeout", readiness.Checks["mongo"].Error)
+
+	resp, err = http.Get(h.Backends[0].URL + "/livez")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "a dependency outage must not fail liveness")
+
+	// New players and requests go to the server that is ready.
+	assert.Equal(t, h.Backends[1].URL, omar.connect())
+	omar.register()
+	omar.start()
+	assert.Equal(t, "Correct! New word assigned.", omar.solve()["message"])
+
+	metrics := gatewayMetrics(t, h)
+	assert.Contains(t, metrics, fmt.Sprintf("scrambled_gateway_backend_healthy{backend=%q} 0", h.Backends[0].URL))
+
+	// With every server degraded, players still get one.
+	h.Backends[1].FailDependency(errors.New("server selection timeout"))
+	kim := signUp(t, h, "kim")
+	assert.Equal(t, h.Backends[0].URL, kim.connect())
+
+	// Once the database is back the first server is ready again.
+	h.Backends[0].FailDependency(nil)
+	lily := signUp(t, h, "lily")
+	assert.Equal(t, h.Backends[0].URL, lily.connect())
+}
@@ -0,0 +1,105 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"scrambled_words/logging"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logBuffer collects the JSON lines logged by every server in the process.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) lines(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func captureLogs(t *testing.T) *logBuffer {
+	logs := &logBuffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(logging.New(logs, slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	return logs
+}
+
+func TestRequestIDFollowsRequestsToTheGameServer(t *testing.T) {
+	h := Start(t)
+	lena := signUp(t, h, "lena")
+	logs := captureLogs(t)
+
+	lena.connect()
+	lena.register()
+
+	req, err := http.NewRequest(http.MethodPost, h.GatewayURL+"/start", strings.NewReader(`{"player_id":"`+lena.ID+`"}`))
+	require.NoError(t, err)
+	req.Header.Set(logging.RequestIDHeader, "chosen-by-client")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	requestID := resp.Header.Get(logging.RequestIDHeader)
+	require.NotEmpty(t, requestID)
+	assert.NotEqual(t, "chosen-by-client", requestID, "the gateway assigns its own IDs")
+
+	lena.solve()
+
+	var startLines int
+	var proxiedID, connectedID any
+	for _, entry := range logs.lines(t) {
+		if entry[logging.KeyRequestID] == requestID && entry["path"] == "/start" {
+			startLines++
+		}
+		switch entry["msg"] {
+		case "Proxying WebSocket to game server":
+			proxiedID = entry[logging.KeyRequestID]
+		case "WebSocket connected":
+			connectedID = entry[logging.KeyRequestID]
+		}
+	}
+	assert.Equal(t, 2, startLines, "expected /start to be logged by the gateway and the game server")
+	assert.NotNil(t, proxiedID)
+	assert.Equal(t, proxiedID, connectedID, "the proxied WebSocket keeps the gateway's request ID")
+
+	// Solving logs the player's ID but never the word or the guess.
+	var solvedLines int
+	for _, entry := range logs.lines(t) {
+		if entry["msg"] == "Correct guess" {
+			assert.Equal(t, lena.ID, entry[logging.KeyPlayerID])
+			solvedLines++
+		}
+	}
+	assert.Equal(t, 1, solvedLines)
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	for _, word := range []string{"apple", "banana", "cherry", "grape", "orange"} {
+		assert.NotContains(t, strings.ToLower(logs.buf.String()), word)
+	}
+}
@@ -0,0 +1,302 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	mainModels "scrambled_words/models"
+	mainStore "scrambled_words/store"
+	secondModels "second_server/models"
+	secondStore "second_server/store"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The gateway's memory stores stand in for the MongoDB database that the
+// gateway and the game servers share in production. Each module has its own
+// copy of the models and store packages, so the game servers reach them
+// through sharedUsers and sharedEvents, which only convert between the
+// copies and leave the storing to the gateway's stores.
+//
+// The game servers are configured once per process (see Start), so these
+// stores and the game servers' own reach the stores of the harness running
+// now through current rather than holding on to any.
+
+// convert copies from into to by way of BSON, the way the documents would
+// travel through MongoDB.
+func convert(from, to any) error {
+	data, err := bson.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, to)
+}
+
+// storeErrors maps the sentinel errors of the gateway's store package to
+// those of a game server's.
+type storeErrors map[error]error
+
+func (e storeErrors) convert(err error) error {
+	for shared, own := range e {
+		if errors.Is(err, shared) {
+			return own
+		}
+	}
+	return err
+}
+
+// sharedUsers is the UserStore and LeaderboardStore of a game server module,
+// whose user type is U and match type is M, over the gateway's users.
+type sharedUsers[U, M any] struct {
+	errs storeErrors
+}
+
+func (s *sharedUsers[U, M]) users() *mainStore.MemoryUserStore {
+	return current.Load().users
+}
+
+func (s *sharedUsers[U, M]) Create(ctx context.Context, user *U) error {
+	var shared mainModels.User
+	if err := convert(user, &shared); err != nil {
+		return err
+	}
+	if err := s.users().Create(ctx, &shared); err != nil {
+		return s.errs.convert(err)
+	}
+	return convert(&shared, user)
+}
+
+// found converts a user the gateway's store found.
+func (s *sharedUsers[U, M]) found(shared *mainModels.User, err error) (*U, error) {
+	if err != nil {
+		return nil, s.errs.convert(err)
+	}
+	user := new(U)
+	if err := convert(shared, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *sharedUsers[U, M]) FindByID(ctx context.Context, id string) (*U, error) {
+	return s.found(s.users().FindByID(ctx, id))
+}
+
+func (s *sharedUsers[U, M]) FindByEmail(ctx context.Context, email string) (*U, error) {
+	return s.found(s.users().FindByEmail(ctx, email))
+}
+
+func (s *sharedUsers[U, M]) FindByUsername(ctx context.Context, username string) (*U, error) {
+	return s.found(s.users().FindByUsername(ctx, username))
+}
+
+func (s *sharedUsers[U, M]) SetWord(ctx context.Context, id, word string) error {
+	return s.errs.convert(s.users().SetWord(ctx, id, word))
+}
+
+func (s *sharedUsers[U, M]) SetScore(ctx context.Context, id string, score int) error {
+	return s.errs.convert(s.users().SetScore(ctx, id, score))
+}
+
+func (s *sharedUsers[U, M]) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	return s.errs.convert(s.users().SetWordAndScore(ctx, id, word, score))
+}
+
+func (s *sharedUsers[U, M]) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	score, err := s.users().SolveWord(ctx, id, word, newWord)
+	return score, s.errs.convert(err)
+}
+
+func (s *sharedUsers[U, M]) RecordWin(ctx context.Context, match *M) error {
+	var shared mainModels.Match
+	if err := convert(match, &shared); err != nil {
+		return err
+	}
+	if err := s.users().RecordWin(ctx, &shared); err != nil {
+		return s.errs.convert(err)
+	}
+	return convert(&shared, match)
+}
+
+func (s *sharedUsers[U, M]) Leaderboard(ctx context.Context) ([]U, error) {
+	shared, err := s.users().Leaderboard(ctx)
+	if err != nil {
+		return nil, s.errs.convert(err)
+	}
+	users := make([]U, len(shared))
+	for i := range shared {
+		if err := convert(&shared[i], &users[i]); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// sharedEvents is the EventLog of a game server module, whose event type is
+// E, over the gateway's event log.
+type sharedEvents[E any] struct{}
+
+func (l *sharedEvents[E]) Append(ctx context.Context, event *E) error {
+	var shared mainModels.GameEvent
+	if err := convert(event, &shared); err != nil {
+		return err
+	}
+	if err := current.Load().gameEvents.Append(ctx, &shared); err != nil {
+		return err
+	}
+	return convert(&shared, event)
+}
+
+// gameStates is the GameStateStore of a game server module, whose game
+// state type is G and player type is P.
+type gameStates[G, P any] interface {
+	Load(ctx context.Context) (*G, error)
+	Save(ctx context.Context, gameState *G) error
+	SavePlayer(ctx context.Context, player P) error
+	RemovePlayer(ctx context.Context, player P) error
+	ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error)
+	AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error)
+	ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error)
+}
+
+// harnessGameState is a game server's GameStateStore, passing every call on
+// to the store get returns for the running harness.
+type harnessGameState[G, P any] struct {
+	get func(*harnessStores) gameStates[G, P]
+}
+
+func (s harnessGameState[G, P]) store() gameStates[G, P] {
+	return s.get(current.Load())
+}
+
+func (s harnessGameState[G, P]) Load(ctx context.Context) (*G, error) {
+	return s.store().Load(ctx)
+}
+
+func (s harnessGameState[G, P]) Save(ctx context.Context, gameState *G) error {
+	return s.store().Save(ctx, gameState)
+}
+
+func (s harnessGameState[G, P]) SavePlayer(ctx context.Context, player P) error {
+	return s.store().SavePlayer(ctx, player)
+}
+
+func (s harnessGameState[G, P]) RemovePlayer(ctx context.Context, player P) error {
+	return s.store().RemovePlayer(ctx, player)
+}
+
+func (s harnessGameState[G, P]) ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error) {
+	return s.store().ClaimRound(ctx, matchStart, round, playerID)
+}
+
+func (s harnessGameState[G, P]) AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error) {
+	return s.store().AddTeamPoint(ctx, matchStart, team)
+}
+
+func (s harnessGameState[G, P]) ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error) {
+	return s.store().ClaimTeamWin(ctx, matchStart, team)
+}
+
+// harnessQueue is the QueueStore of a game server module, whose queue entry
+// type is E, over the matchmaking queue both game servers of the running
+// harness share, as they share Redis in production.
+type harnessQueue[E any] struct{}
+
+func (harnessQueue[E]) queue() *secondStore.MemoryQueueStore {
+	return current.Load().queue
+}
+
+func (q harnessQueue[E]) Enqueue(ctx context.Context, entry E) (*E, error) {
+	var shared secondModels.QueueEntry
+	if err := convert(&entry, &shared); err != nil {
+		return nil, err
+	}
+	stored, err := q.queue().Enqueue(ctx, shared)
+	if err != nil {
+		return nil, err
+	}
+	if err := convert(stored, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (q harnessQueue[E]) Dequeue(ctx context.Context, playerID string) (bool, error) {
+	return q.queue().Dequeue(ctx, playerID)
+}
+
+func (q harnessQueue[E]) Queue(ctx context.Context) ([]E, error) {
+	shared, err := q.queue().Queue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]E, len(shared))
+	for i := range shared {
+		if err := convert(&shared[i], &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (q harnessQueue[E]) Take(ctx context.Context, playerIDs []string) (bool, error) {
+	return q.queue().Take(ctx, playerIDs)
+}
+
+// harnessRooms is the RoomStore of a game server module, whose room type is
+// R, over the rooms both game servers of the running harness share, as they
+// share Redis in production.
+type harnessRooms[R any] struct{}
+
+func (harnessRooms[R]) rooms() *secondStore.MemoryRoomStore {
+	return current.Load().rooms
+}
+
+func (s harnessRooms[R]) Rooms(ctx context.Context) ([]R, error) {
+	shared, err := s.rooms().Rooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rooms := make([]R, len(shared))
+	for i := range shared {
+		if err := convert(&shared[i], &rooms[i]); err != nil {
+			return nil, err
+		}
+	}
+	return rooms, nil
+}
+
+func (s harnessRooms[R]) UpdateRoom(ctx context.Context, id string, update func(*R) error) (*R, error) {
+	shared, err := s.rooms().UpdateRoom(ctx, id, func(stored *secondModels.Room) error {
+		var own R
+		if err := convert(stored, &own); err != nil {
+			return err
+		}
+		if err := update(&own); err != nil {
+			return err
+		}
+		return convert(&own, stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var updated R
+	if err := convert(shared, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// harnessBus is the EventBus of both game servers over the running
+// harness's. A subscriber stays on the bus it subscribed to, so the event
+// listener of an earlier harness never hears the events of a later one.
+type harnessBus struct{}
+
+func (harnessBus) Publish(ctx context.Context, event []byte) error {
+	return current.Load().events.Publish(ctx, event)
+}
+
+func (harnessBus) Subscribe(ctx context.Context, handle func([]byte)) {
+	current.Load().events.Subscribe(ctx, handle)
+}
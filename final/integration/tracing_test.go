@@ -0,0 +1,77 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(defaultProvider) })
+	return recorder
+}
+
+func TestTraceFollowsSubmitToTheGameServer(t *testing.T) {
+	h := Start(t)
+	mia := signUp(t, h, "mia")
+	recorder := recordSpans(t)
+
+	mia.connect()
+	mia.register()
+	mia.start()
+	status, _ := postJSON(t, h.GatewayURL+"/submit", map[string]string{"player_id": mia.ID, "guess": "wrong"})
+	require.Equal(t, http.StatusOK, status)
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	// The gateway and the game server both serve POST /submit: the gateway's
+	// span is the root and the game server's is a child of the forward hop.
+	require.Len(t, spans["POST /submit"], 2)
+	require.Len(t, spans["gateway.forward"], 2, "one for /start, one for /submit")
+	var root, backend sdktrace.ReadOnlySpan
+	for _, span := range spans["POST /submit"] {
+		if span.Parent().IsValid() && span.Parent().IsRemote() {
+			backend = span
+		} else {
+			root = span
+		}
+	}
+	require.NotNil(t, root)
+	require.NotNil(t, backend)
+	assert.Equal(t, root.SpanContext().TraceID(), backend.SpanContext().TraceID())
+
+	forward := spans["gateway.forward"][1]
+	assert.Equal(t, root.SpanContext().SpanID(), forward.Parent().SpanID())
+	assert.Equal(t, forward.SpanContext().SpanID(), backend.Parent().SpanID())
+
+	var probed bool
+	for _, check := range spans["gateway.health_check"] {
+		if check.Parent().SpanID() == root.SpanContext().SpanID() {
+			probed = true
+		}
+	}
+	assert.True(t, probed, "the health probe is part of the request's trace")
+
+	// The proxied WebSocket continues the trace of the dial.
+	require.Len(t, spans["gateway.websocket_dial"], 1)
+	dial := spans["gateway.websocket_dial"][0]
+	var wsMessages int
+	for _, span := range spans["ws register"] {
+		assert.Equal(t, dial.SpanContext().TraceID(), span.SpanContext().TraceID())
+		wsMessages++
+	}
+	assert.Equal(t, 1, wsMessages)
+}
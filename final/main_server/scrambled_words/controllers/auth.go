@@ -1,16 +1,13 @@
 package controllers
 
 import (
-	"context"
-	"log"
+	"errors"
 	"net/http"
-	"scrambled_words/db"
+	"scrambled_words/logging"
 	"scrambled_words/models"
-	"time"
+	"scrambled_words/store"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,33 +18,24 @@ func Signup(c *gin.Context) {
 		return
 	}
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := storeContext(c.Request.Context())
 	defer cancel()
 
-	count, err := collection.CountDocuments(ctx, bson.M{"email": user.Email})
-	if err != nil {
-		log.Printf("Error checking email: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-	if count > 0 {
-		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
-		return
-	}
-
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Error hashing password: %v", err)
+		logging.FromContext(c.Request.Context()).Error("Failed to hash password", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not hash password"})
 		return
 	}
 	user.Password = string(hashedPassword)
-	user.ID = primitive.NewObjectID()
 
-	_, err = collection.InsertOne(ctx, user)
+	err = users.Create(ctx, &user)
+	if errors.Is(err, store.ErrEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
 	if err != nil {
-		log.Printf("Error saving user: %v", err)
+		logging.FromContext(c.Request.Context()).Error("Failed to save user", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save user"})
 		return
 	}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"scrambled_words/shared"
+)
+
+const eventBroadcast = "broadcast"
+
+// serverID tells the servers apart in published events.
+var serverID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// gameEvent is the event format shared with the game servers over Redis.
+type gameEvent struct {
+	Kind    string          `json:"kind"`
+	Origin  string          `json:"origin"`
+	SentAt  time.Time       `json:"sent_at"`
+	Message *shared.Message `json:"message,omitempty"`
+}
+
+// publishGameEvent sends a message to the players on every game server. If
+// Redis cannot be reached the message only goes to this server's players.
+func publishGameEvent(msg shared.Message) {
+	data, err := json.Marshal(gameEvent{Kind: eventBroadcast, Origin: serverID, SentAt: time.Now(), Message: &msg})
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err = eventBus.Publish(ctx, data)
+	}
+	if err == nil {
+		return
+	}
+
+	slog.Warn("Failed to publish event, delivering locally only", "type", msg.Type, "error", err)
+	select {
+	case shared.Broadcast <- msg:
+	default:
+		slog.Error("Broadcast channel is full, dropping message", "type", msg.Type)
+	}
+}
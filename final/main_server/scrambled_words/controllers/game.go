@@ -2,13 +2,15 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
-	"scrambled_words/db"
+	"scrambled_words/logging"
 	"scrambled_words/models"
 	"scrambled_words/shared"
+	"scrambled_words/store"
 	"strings"
 	"sync"
 	"time"
@@ -16,7 +18,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 var gameState = models.GameState{}
@@ -30,10 +31,16 @@ func LoadGameState() {
 	mu.Lock()
 	defer mu.Unlock()
 
-	storedState, err := db.LoadGameState()
+	ctx, cancel := storeContext(context.Background())
+	defer cancel()
+
+	storedState, err := gameStore.Load(ctx)
 	if err == nil {
 		gameState = *storedState
 	} else {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to load game state", "error", err)
+		}
 		gameState = models.GameState{}
 	}
 }
@@ -77,7 +84,7 @@ func JoinGame(c *gin.Context) {
 		"player":       player,
 		"joined_users": playerNames,
 	})
-	db.SaveGameState(&gameState)
+	saveGameState(c.Request.Context())
 }
 
 func CheckMenu(c *gin.Context) {
@@ -94,20 +101,18 @@ func CheckMenu(c *gin.Context) {
 		return
 	}
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := storeContext(c.Request.Context())
 	defer cancel()
 
-	objID, err := primitive.ObjectIDFromHex(request.PlayerID)
-	if err != nil {
+	if !primitive.IsValidObjectID(request.PlayerID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Player ID"})
 		return
 	}
 
 	if request.Type == "new" {
 
-		_, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"score": 0}})
-		if err != nil {
+		err := users.SetScore(ctx, request.PlayerID, 0)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset score"})
 			return
 		}
@@ -145,8 +150,7 @@ func StartGame(c *gin.Context) {
 
 	newWord := generateWord()
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := storeContext(c.Request.Context())
 	defer cancel()
 
 	var targetPlayer *shared.Player
@@ -154,13 +158,9 @@ func StartGame(c *gin.Context) {
 		if player.ID == playerID {
 			targetPlayer = &player
 
-			_, err := collection.UpdateOne(
-				ctx,
-				bson.M{"_id": playerID},
-				bson.M{"$set": bson.M{"word": newWord}},
-			)
+			err := users.SetWord(ctx, request.PlayerID, newWord)
 			if err != nil {
-				log.Printf("Failed to update player word: %v", err)
+				logging.FromContext(c.Request.Context()).Error("Failed to update player word", logging.KeyPlayerID, request.PlayerID, "error", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update player word"})
 				return
 			}
@@ -185,7 +185,7 @@ func StartGame(c *gin.Context) {
 		if player.ID == playerID {
 			err := conn.WriteJSON(message)
 			if err != nil {
-				log.Println("Error sending message to client:", err)
+				logging.FromContext(c.Request.Context()).Warn("Failed to send message to client", logging.KeyPlayerID, request.PlayerID, "error", err)
 				conn.Close()
 				delete(shared.Clients, conn)
 				delete(shared.Players, conn)
@@ -201,7 +201,6 @@ func StartGame(c *gin.Context) {
 }
 
 func SubmitAnswer(c *gin.Context) {
-	log.Println("Acquiring lock in SubmitAnswer()")
 	shared.Mu.Lock()
 	defer shared.Mu.Unlock()
 
@@ -214,52 +213,49 @@ func SubmitAnswer(c *gin.Context) {
 		return
 	}
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := storeContext(c.Request.Context())
 	defer cancel()
 
-	var player models.Player
-	objID, err := primitive.ObjectIDFromHex(request.PlayerID)
-	if err != nil {
+	user, err := users.FindByID(ctx, request.PlayerID)
+	if errors.Is(err, store.ErrInvalidID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Player ID"})
 		return
 	}
-
-	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&player)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Player not found"})
 		return
 	}
-
-	log.Printf("Player ID: %s - Retrieved Word from DB: %s", request.PlayerID, player.Word)
+	player := models.Player{ID: request.PlayerID, Name: user.Username, Word: user.Word, Score: user.Score}
+	logger := logging.FromContext(c.Request.Context()).With(logging.KeyPlayerID, request.PlayerID)
 
 	if player.Word == "" {
 		player.Word = generateWord()
-		updateResult, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"word": player.Word}})
-		if err != nil {
-			log.Println("Error assigning word to player:", err)
+		if err := users.SetWord(ctx, request.PlayerID, player.Word); err != nil {
+			logger.Error("Failed to assign word to player", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign word"})
 			return
 		}
-		log.Printf("New word assigned from DB: %s (Update result: %v)", player.Word, updateResult)
+		logger.Info("Assigned a word to player without one")
 	}
 
 	normalizedWord := strings.ToLower(player.Word)
 	normalizedGuess := strings.ToLower(request.Guess)
 
-	log.Printf("Normalized Word from DB: %s, Normalized Guess: %s", normalizedWord, normalizedGuess)
-
 	if normalizedGuess == normalizedWord {
-		player.Score++
-
 		newWord := generateWord()
-		updateResult, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"word": newWord, "score": player.Score}})
+		score, err := users.SolveWord(ctx, request.PlayerID, player.Word, newWord)
+		if errors.Is(err, store.ErrWordChanged) {
+			logger.Info("Word was solved or skipped by another request")
+			c.JSON(http.StatusConflict, gin.H{"error": "Word was already solved or skipped"})
+			return
+		}
 		if err != nil {
-			log.Println("Error updating word in DB:", err)
+			logger.Error("Failed to update word", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update word"})
 			return
 		}
-		log.Printf("New word updated in DB: %s (Update result: %v)", newWord, updateResult)
+		player.Score = score
+		logger.Info("Correct guess", "score", player.Score)
 
 		for conn, p := range shared.Players {
 			if p.Name == player.Name {
@@ -273,29 +269,26 @@ func SubmitAnswer(c *gin.Context) {
 		go broadcastPlayerList()
 
 		if player.Score == 3 {
+			// The win is stored before anyone is told about it, so a failure
+			// leaves nothing to take back.
+			match := finishedMatch(player)
+			if err := users.RecordWin(ctx, &match); err != nil {
+				logger.Error("Failed to record win", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record win"})
+				return
+			}
+
 			gameState.Winner = &player
 			gameState.Started = false
-			log.Println("Broadcasting game over for winner:", player.Name)
+			logger.Info("Player won the game", "winner", player.Name, "match_id", match.ID.Hex())
 
-			select {
-			case shared.Broadcast <- shared.Message{
+			publishGameEvent(shared.Message{
 				Type: "game_over",
 				Payload: gin.H{
 					"winner":  player.Name,
 					"message": fmt.Sprintf("%s won the game!", player.Name),
 				},
-			}:
-				log.Println("Game over broadcast sent.")
-			default:
-				log.Println("Broadcast channel is full, dropping message!")
-			}
-
-			_, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$inc": bson.M{"wins": 1}})
-			if err != nil {
-				log.Printf("Failed to update wins: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wins"})
-				return
-			}
+			})
 
 			c.JSON(http.StatusOK, gin.H{
 				"message":  fmt.Sprintf("%s won the game!", player.Name),
@@ -318,7 +311,7 @@ func SubmitAnswer(c *gin.Context) {
 		}
 
 	} else {
-		log.Println("Incorrect guess. Try again.")
+		logger.Debug("Incorrect guess")
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Incorrect, try again!",
 			"correct": false,
@@ -327,6 +320,25 @@ func SubmitAnswer(c *gin.Context) {
 	}
 }
 
+// finishedMatch records the game won by winner with everyone's final score.
+func finishedMatch(winner models.Player) models.Match {
+	mu.Lock()
+	defer mu.Unlock()
+
+	match := models.Match{
+		WinnerID: winner.ID,
+		Winner:   winner.Name,
+		EndedAt:  time.Now().UTC(),
+		Players:  []models.MatchPlayer{{ID: winner.ID, Name: winner.Name, Score: winner.Score}},
+	}
+	for _, p := range gameState.Players {
+		if p.ID != winner.ID {
+			match.Players = append(match.Players, models.MatchPlayer{ID: p.ID, Name: p.Name, Score: p.Score})
+		}
+	}
+	return match
+}
+
 func getScores() []map[string]interface{} {
 	mu.Lock()
 	defer mu.Unlock()
@@ -378,5 +390,5 @@ func LeaveGame(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Player left the game",
 	})
-	db.SaveGameState(&gameState)
+	saveGameState(c.Request.Context())
 }
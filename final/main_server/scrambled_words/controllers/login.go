@@ -1,15 +1,9 @@
 package controllers
 
 import (
-	"context"
 	"net/http"
-	"time"
-
-	"scrambled_words/db"
-	"scrambled_words/models"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -29,12 +23,10 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := storeContext(c.Request.Context())
 	defer cancel()
 
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"email": request.Email}).Decode(&user)
+	user, err := users.FindByEmail(ctx, request.Email)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
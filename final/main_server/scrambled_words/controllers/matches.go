@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"scrambled_words/logging"
+	"scrambled_words/models"
+	"scrambled_words/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pagination reads the page (from 1) and limit query parameters, answering
+// the request itself when they are invalid.
+func pagination(c *gin.Context) (page, limit int, ok bool) {
+	page, limit = 1, defaultPageSize
+	if value := c.Query("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+			return 0, 0, false
+		}
+		page = n
+	}
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+			return 0, 0, false
+		}
+		limit = n
+	}
+	return page, limit, true
+}
+
+// respondWithMatches sends one page of matches. One more match than the
+// page holds is asked of the store to tell whether another page follows.
+func respondWithMatches(c *gin.Context, page, limit int, list func(skip, limit int) ([]models.Match, error)) {
+	matches, err := list((page-1)*limit, limit+1)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to fetch matches", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch matches"})
+		return
+	}
+
+	hasMore := len(matches) > limit
+	if hasMore {
+		matches = matches[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"matches":  matches,
+		"page":     page,
+		"limit":    limit,
+		"has_more": hasMore,
+	})
+}
+
+// GetRecentMatches lists the games that ended most recently.
+func GetRecentMatches(c *gin.Context) {
+	page, limit, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := storeContext(c.Request.Context())
+	defer cancel()
+
+	respondWithMatches(c, page, limit, func(skip, limit int) ([]models.Match, error) {
+		return matchStore.RecentMatches(ctx, skip, limit)
+	})
+}
+
+// GetUserMatches lists the games a user played in, most recent first.
+func GetUserMatches(c *gin.Context) {
+	page, limit, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := storeContext(c.Request.Context())
+	defer cancel()
+
+	id := c.Param("id")
+	_, err := users.FindByID(ctx, id)
+	switch {
+	case errors.Is(err, store.ErrInvalidID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("Failed to look up user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+
+	respondWithMatches(c, page, limit, func(skip, limit int) ([]models.Match, error) {
+		return matchStore.UserMatches(ctx, id, skip, limit)
+	})
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"scrambled_words/logging"
+	"scrambled_words/models"
+	"scrambled_words/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProfile shows a player's wins, skill rating and recent rating changes.
+func GetProfile(c *gin.Context) {
+	ctx, cancel := storeContext(c.Request.Context())
+	defer cancel()
+
+	user, err := users.FindByID(ctx, c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrInvalidID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("Failed to look up user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+
+	history := user.RatingHistory
+	if history == nil {
+		history = []models.RatingChange{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":             user.ID.Hex(),
+		"username":       user.Username,
+		"wins":           user.Wins,
+		"rating":         user.CurrentRating(),
+		"rating_history": history,
+	})
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scrambled_words/logging"
+	"scrambled_words/models"
+	"scrambled_words/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const maxReplaySpeed = 100
+
+// Replay message types, sent in the same envelope as the game protocol.
+const (
+	typeReplayStart = "replay_start"
+	typeReplayEvent = "replay_event"
+	typeReplayEnd   = "replay_end"
+)
+
+type replayMessage struct {
+	Version int         `json:"v"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+// ReplayMatch streams the logged events of a finished match over a
+// WebSocket, spaced out as they happened divided by the speed query
+// parameter, from 1 (the original pace) to maxReplaySpeed. The replay covers
+// the game of the match's room since its previous match ended, so it
+// includes the players joining, but not the events of other matches.
+func ReplayMatch(c *gin.Context) {
+	speed := 1.0
+	if value := c.Query("speed"); value != "" {
+		s, err := strconv.ParseFloat(value, 64)
+		if err != nil || s < 1 || s > maxReplaySpeed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "speed must be between 1 and " + strconv.Itoa(maxReplaySpeed)})
+			return
+		}
+		speed = s
+	}
+
+	logger := logging.FromContext(c.Request.Context())
+	ctx, cancel := storeContext(c.Request.Context())
+	defer cancel()
+
+	match, err := matchStore.FindMatch(ctx, c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrInvalidID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid match ID"})
+		return
+	case errors.Is(err, store.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Match not found"})
+		return
+	case err != nil:
+		logger.Error("Failed to look up match", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up match"})
+		return
+	}
+
+	var after time.Time
+	previous, err := matchStore.PreviousMatch(ctx, match.Room, match.EndedAt)
+	switch {
+	case err == nil:
+		after = previous.EndedAt
+	case !errors.Is(err, store.ErrNotFound):
+		logger.Error("Failed to look up previous match", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load match events"})
+		return
+	}
+
+	events, err := eventLog.Events(ctx, match.Room, match.ID.Hex(), after, match.EndedAt)
+	if err != nil {
+		logger.Error("Failed to load match events", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load match events"})
+		return
+	}
+	cancel()
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	logger.Info("Replaying match", "match_id", match.ID.Hex(), "events", len(events), "speed", speed)
+	if err := replay(conn, match, events, speed); err != nil {
+		logger.Info("Replay ended early", "match_id", match.ID.Hex(), "error", err)
+	}
+}
+
+// errReplayAbandoned means the viewer closed the socket mid-replay.
+var errReplayAbandoned = errors.New("viewer disconnected")
+
+func replay(conn *websocket.Conn, match *models.Match, events []models.GameEvent, speed float64) error {
+	// Reading is the only way to notice the viewer leaving; anything they
+	// send is ignored.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msgType string, payload interface{}) error {
+		return conn.WriteJSON(replayMessage{Version: 1, Type: msgType, Payload: payload})
+	}
+
+	if err := send(typeReplayStart, gin.H{"match": match}); err != nil {
+		return err
+	}
+	for i, event := range events {
+		if i > 0 {
+			wait := time.Duration(float64(event.At.Sub(events[i-1].At)) / speed)
+			select {
+			case <-gone:
+				return errReplayAbandoned
+			case <-time.After(wait):
+			}
+		}
+		if err := send(typeReplayEvent, event); err != nil {
+			return err
+		}
+	}
+	if err := send(typeReplayEnd, gin.H{"events": len(events)}); err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished"))
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"scrambled_words/logging"
+	"scrambled_words/models"
+	"scrambled_words/store"
+)
+
+// The storage used by the handlers, set with Configure.
+var (
+	users       store.UserStore
+	gameStore   store.GameStateStore
+	eventBus    store.EventBus
+	leaderboard store.LeaderboardStore
+	matchStore  store.MatchStore
+	eventLog    store.EventLog
+	writeQueue  *store.WriteBehind
+)
+
+const storeTimeout = 5 * time.Second
+
+// Configure sets the storage the handlers use. It must be called before
+// LoadGameState and before any route is served.
+func Configure(stores store.Stores) {
+	users = stores.Users
+	gameStore = stores.GameState
+	eventBus = stores.Events
+	leaderboard = stores.Leaderboard
+	matchStore = stores.Matches
+	eventLog = stores.EventLog
+	writeQueue = stores.Queue
+}
+
+// StartWriteBehind retries the writes buffered while a store is down, until
+// ctx is done.
+func StartWriteBehind(ctx context.Context) {
+	if writeQueue != nil {
+		go writeQueue.Run(ctx)
+	}
+}
+
+// FlushWriteBehind makes a last attempt at the buffered writes before the
+// gateway exits.
+func FlushWriteBehind(ctx context.Context) {
+	if writeQueue == nil {
+		return
+	}
+	if err := writeQueue.Flush(ctx); err != nil {
+		slog.Error("Buffered writes lost on shutdown", "writes", writeQueue.Pending(), "error", err)
+	}
+}
+
+// storeContext bounds a store call by storeTimeout. It keeps the values of
+// ctx, such as the trace, but not its cancellation, so a write is not cut
+// short because the player hung up.
+func storeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), storeTimeout)
+}
+
+// saveGameState persists the game state. Callers must hold mu.
+func saveGameState(ctx context.Context) {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if err := gameStore.Save(ctx, &gameState); err != nil {
+		slog.Error("Failed to save game state", "error", err)
+	}
+}
+
+func savePlayer(ctx context.Context, player models.Player) {
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if err := gameStore.SavePlayer(ctx, player); err != nil {
+		slog.Error("Failed to save player", logging.KeyPlayerID, player.ID, "error", err)
+	}
+}
@@ -1,16 +1,14 @@
 package controllers
 
 import (
-	"context"
-	"log"
+	"log/slog"
 	"net/http"
-	"scrambled_words/db"
+	"scrambled_words/logging"
 	"scrambled_words/models"
 	"scrambled_words/shared"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,9 +18,11 @@ var upgrader = websocket.Upgrader{
 }
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		logger.Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 	defer conn.Close()
@@ -31,39 +31,36 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	shared.Clients[conn] = true
 	shared.Mu.Unlock()
 
-	log.Printf("New WebSocket connection. Total clients: %d\n", len(shared.Clients))
+	logger.Info("WebSocket connected")
 
 	for {
 		var msg shared.Message
 		err := conn.ReadJSON(&msg)
 		if err != nil {
-			log.Println("WebSocket read error:", err)
+			logger.Info("WebSocket read ended", "error", err)
 			break
 		}
 
 		if msg.Type == "register" {
 			shared.Mu.Lock()
 			username := msg.Payload.(map[string]interface{})["username"].(string)
-			var user models.User
-			collection := db.GetCollection("scrambled_words", "users")
-
-			err = collection.FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
+			ctx, cancel := storeContext(r.Context())
+			user, err := users.FindByUsername(ctx, username)
+			cancel()
 			if err != nil {
-				log.Println("User not found:", err)
+				logger.Info("Registration for unknown user", "error", err)
 				shared.Mu.Unlock()
 				return
 			}
 
 			shared.Players[conn] = shared.Player{ID: user.ID, Name: username, Score: 0}
 			player := models.Player{
+				ID:    user.ID.Hex(),
 				Name:  shared.Players[conn].Name,
 				Score: shared.Players[conn].Score,
 			}
-			playersCollection := db.GetCollection("scrambled_words", "players")
-			_, err = playersCollection.InsertOne(context.TODO(), player)
-			if err != nil {
-				log.Println("Failed to save player:", err)
-			}
+			savePlayer(r.Context(), player)
+			logger = logger.With(logging.KeyPlayerID, player.ID)
 
 			gameState.Players = append(gameState.Players, player)
 			shared.Mu.Unlock()
@@ -78,22 +75,18 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	delete(shared.Clients, conn)
 	delete(shared.Players, conn)
 	shared.Mu.Unlock()
-	log.Printf("WebSocket disconnected. Total clients: %d\n", len(shared.Clients))
+	logger.Info("WebSocket disconnected")
 
 	broadcastPlayerList()
 }
 
 func broadcastPlayerList() {
-	log.Println("Acquiring lock in broadcastPlayerList()")
 	shared.Mu.Lock()
-	defer func() {
-		log.Println("Releasing lock in broadcastPlayerList()")
-		shared.Mu.Unlock()
-	}()
+	defer shared.Mu.Unlock()
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("Recovered from panic in broadcastPlayerList:", r)
+			slog.Error("Recovered from panic in broadcastPlayerList", "panic", r)
 		}
 	}()
 
@@ -113,7 +106,7 @@ func broadcastPlayerList() {
 	for conn := range shared.Players {
 		err := conn.WriteJSON(message)
 		if err != nil {
-			log.Println("Error broadcasting to client:", err)
+			slog.Warn("Failed to send player list to client", "error", err)
 			conn.Close()
 			delete(shared.Players, conn)
 		}
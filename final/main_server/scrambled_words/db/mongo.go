@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,22 +13,30 @@ import (
 
 var Client *mongo.Client
 
-func Connect() error {
+// ErrUnavailable means a database could not be reached at startup. Its
+// client is set up all the same and reconnects by itself, so the gateway can
+// start in degraded mode.
+var ErrUnavailable = errors.New("database unavailable")
+
+// Connect sets up Client. It fails with ErrUnavailable if MongoDB does not
+// answer, and with another error if the client cannot be created at all,
+// e.g. because the URI is invalid.
+func Connect(uri string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	var err error
-	Client, err = mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	Client, err = mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
 		return err
 	}
 
 	err = Client.Ping(ctx, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
 	}
 
-	log.Println("Connected to MongoDB!")
+	slog.Info("Connected to MongoDB")
 	return nil
 }
 
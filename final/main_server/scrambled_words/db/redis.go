@@ -2,10 +2,8 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"time"
+	"log/slog"
 
 	"scrambled_words/models"
 
@@ -14,51 +12,42 @@ import (
 
 var redisClient *redis.Client
 
-func InitRedis() {
+// InitRedis sets up the Redis client. It fails with ErrUnavailable if Redis
+// does not answer.
+func InitRedis(addr string) error {
 	redisClient = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
+		Addr:     addr,
 		Password: "",
 		DB:       0,
 	})
+	redisClient.AddHook(tracingHook{})
 
 	_, err := redisClient.Ping(context.Background()).Result()
 	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
 	}
 
-	fmt.Println(" Connected to Redis!")
+	slog.Info("Connected to Redis")
+	return nil
 }
 
-func SaveGameState(gameState *models.GameState) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	data, err := json.Marshal(gameState)
-	if err != nil {
-		log.Println(" Failed to serialize game state:", err)
-		return
-	}
-
-	err = redisClient.Set(ctx, "game_state", data, 0).Err()
-	if err != nil {
-		log.Println(" Failed to save game state in Redis:", err)
-	}
+// SaveGameState persists the game state, merging in concurrent changes made
+// by other servers. See saveGameState.
+func SaveGameState(ctx context.Context, gameState *models.GameState) error {
+	return saveGameState(ctx, redisClient, gameState)
 }
 
-func LoadGameState() (*models.GameState, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	data, err := redisClient.Get(ctx, "game_state").Bytes()
-	if err != nil {
-		return nil, err
-	}
+// LoadGameState returns redis.Nil if no game state has been saved yet.
+func LoadGameState(ctx context.Context) (*models.GameState, error) {
+	return loadGameState(ctx, redisClient)
+}
 
-	var gameState models.GameState
-	err = json.Unmarshal(data, &gameState)
-	if err != nil {
-		return nil, err
-	}
+// SavePlayer stores a single player of the game state without touching the
+// others.
+func SavePlayer(ctx context.Context, player models.Player) error {
+	return savePlayers(ctx, redisClient, player)
+}
 
-	return &gameState, nil
+func RemovePlayer(ctx context.Context, player models.Player) error {
+	return removePlayer(ctx, redisClient, player)
 }
@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+const (
+	breakerFailureThreshold = 3
+	breakerSlowCall         = 2 * time.Second
+	breakerCooldown         = 10 * time.Second
+)
+
+// circuitBreaker tracks the health of a single game server. Calls that fail
+// or take longer than slowCall count as failures; once failureThreshold of
+// them happen in a row the breaker opens and the backend is skipped until
+// cooldown has passed. After that a single trial call is let through
+// (half-open) and its outcome decides whether the breaker closes again.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	state         breakerState
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+	lastError     string
+	lastLatency   time.Duration
+
+	failureThreshold int
+	slowCall         time.Duration
+	cooldown         time.Duration
+	now              func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: breakerFailureThreshold,
+		slowCall:         breakerSlowCall,
+		cooldown:         breakerCooldown,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a call to the backend may be attempted.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trialInFlight = true
+		return true
+	case breakerHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record feeds the outcome of a call back into the breaker.
+func (b *circuitBreaker) Record(latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastLatency = latency
+	b.trialInFlight = false
+
+	if err == nil && latency <= b.slowCall {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	if err != nil {
+		b.lastError = err.Error()
+	} else {
+		b.lastError = "slow call: " + latency.String()
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) snapshot() gin.H {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := gin.H{
+		"state":           b.state.String(),
+		"failures":        b.failures,
+		"last_error":      b.lastError,
+		"last_latency_ms": b.lastLatency.Milliseconds(),
+	}
+	if b.state != breakerClosed {
+		status["opened_at"] = b.openedAt
+		status["retry_at"] = b.openedAt.Add(b.cooldown)
+	}
+	return status
+}
+
+var (
+	breakers   = make(map[string]*circuitBreaker)
+	breakersMu sync.Mutex
+)
+
+func breakerFor(server string) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[server]
+	if !ok {
+		b = newCircuitBreaker()
+		breakers[server] = b
+	}
+	return b
+}
+
+func BreakerStatus(c *gin.Context) {
+	status := gin.H{}
+	for _, server := range GameServers {
+		status[server] = breakerFor(server).snapshot()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"breakers": status,
+	})
+}
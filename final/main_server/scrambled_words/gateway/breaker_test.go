@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time { return f.t }
+
+func newTestBreaker() (*circuitBreaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := newCircuitBreaker()
+	b.now = clock.now
+	return b, clock
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker()
+	errDown := errors.New("connection refused")
+
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.Record(time.Millisecond, errDown)
+		if b.State() != breakerClosed {
+			t.Fatalf("breaker opened after %d failures", i+1)
+		}
+	}
+
+	b.Record(time.Millisecond, errDown)
+	if b.State() != breakerOpen {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+	if b.Allow() {
+		t.Fatal("open breaker should reject calls before cooldown")
+	}
+}
+
+func TestBreakerCountsSlowCallsAsFailures(t *testing.T) {
+	b, _ := newTestBreaker()
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.Record(breakerSlowCall+time.Millisecond, nil)
+	}
+	if b.State() != breakerOpen {
+		t.Fatalf("expected open after slow calls, got %s", b.State())
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b, _ := newTestBreaker()
+	errDown := errors.New("connection refused")
+
+	b.Record(time.Millisecond, errDown)
+	b.Record(time.Millisecond, errDown)
+	b.Record(time.Millisecond, nil)
+	b.Record(time.Millisecond, errDown)
+
+	if b.State() != breakerClosed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+}
+
+func TestBreakerHalfOpenAllowsSingleTrial(t *testing.T) {
+	b, clock := newTestBreaker()
+	errDown := errors.New("connection refused")
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.Record(time.Millisecond, errDown)
+	}
+
+	clock.t = clock.t.Add(breakerCooldown)
+	if !b.Allow() {
+		t.Fatal("breaker should allow a trial call after cooldown")
+	}
+	if b.State() != breakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", b.State())
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker should allow only one trial call")
+	}
+
+	b.Record(time.Millisecond, errDown)
+	if b.State() != breakerOpen {
+		t.Fatalf("failed trial should reopen the breaker, got %s", b.State())
+	}
+
+	clock.t = clock.t.Add(breakerCooldown)
+	if !b.Allow() {
+		t.Fatal("breaker should allow another trial after cooldown")
+	}
+	b.Record(time.Millisecond, nil)
+	if b.State() != breakerClosed {
+		t.Fatalf("successful trial should close the breaker, got %s", b.State())
+	}
+}
@@ -0,0 +1,382 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"scrambled_words/logging"
+	"scrambled_words/routes"
+	"scrambled_words/tracing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// Readiness statuses of a game server that takes new players. A degraded
+// one has lost a database and buffers its writes until it returns, so it is
+// only used when no server is ready.
+const (
+	statusReady    = "ready"
+	statusDegraded = "degraded"
+)
+
+// Readiness is the part of a game server's /readyz report the gateway acts
+// on.
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// failingChecks names the dependencies whose check failed.
+func (r Readiness) failingChecks() []string {
+	var failing []string
+	for name, result := range r.Checks {
+		if !result.OK {
+			failing = append(failing, name+": "+result.Error)
+		}
+	}
+	sort.Strings(failing)
+	return failing
+}
+
+// CheckServerReadiness fetches a game server's readiness report. It returns
+// an error only when the server cannot be reached or does not answer with a
+// report; a server that is draining, full or missing a dependency answers
+// with a status other than ready.
+func CheckServerReadiness(ctx context.Context, url string) (Readiness, error) {
+	ctx, span := tracing.Start(ctx, "gateway.health_check",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("backend", url)),
+	)
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/readyz", nil)
+	if err != nil {
+		span.RecordError(err)
+		return Readiness{}, err
+	}
+	tracing.Inject(ctx, req.Header)
+
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("Game server is down", "backend", url, "error", err)
+		span.RecordError(err)
+		return Readiness{}, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		err = fmt.Errorf("readiness check returned %s", resp.Status)
+		span.RecordError(err)
+		return Readiness{}, err
+	}
+	var readiness Readiness
+	if err := json.NewDecoder(resp.Body).Decode(&readiness); err != nil {
+		err = fmt.Errorf("decode readiness report: %w", err)
+		span.RecordError(err)
+		return Readiness{}, err
+	}
+	span.SetAttributes(attribute.String("readiness.status", readiness.Status))
+	return readiness, nil
+}
+
+// GameServers are the backends the gateway forwards to, in order of
+// preference, normally config.GatewayConfig.Backends. Set it before the
+// router starts serving.
+var GameServers []string
+
+// getHealthyServer returns the first game server whose circuit breaker lets
+// the call through and that reports itself ready, or failing that the first
+// degraded one. Backends with an open breaker are skipped without being
+// probed. A backend that answers but is not ready, e.g. because it is
+// draining or full, is skipped without counting against its breaker.
+func getHealthyServer(ctx context.Context) string {
+	var degraded string
+	for _, server := range GameServers {
+		breaker := breakerFor(server)
+		if !breaker.Allow() {
+			continue
+		}
+
+		start := time.Now()
+		readiness, err := CheckServerReadiness(ctx, server)
+		ready := err == nil && readiness.Status == statusReady
+		recordHealth(server, ready)
+		if err != nil {
+			breaker.Record(time.Since(start), err)
+			continue
+		}
+		if !ready {
+			breaker.Record(time.Since(start), nil)
+			slog.Info("Game server is not ready", "backend", server,
+				"status", readiness.Status, "failing_checks", readiness.failingChecks())
+			if readiness.Status == statusDegraded && degraded == "" {
+				degraded = server
+			}
+			continue
+		}
+		return server
+	}
+	return degraded
+}
+
+// clientMessage is a message read from the player's connection.
+type clientMessage struct {
+	messageType int
+	data        []byte
+}
+
+// WebSocketHandler proxies a player's WebSocket to a healthy game server.
+// When the game server goes away it reconnects the player to another one.
+// The player's connection is read by a single goroutine for its whole
+// lifetime, so nothing the player sends is lost to a dead backend.
+func WebSocketHandler(c *gin.Context) {
+	logger := logging.FromContext(c.Request.Context())
+
+	clientConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logger.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer clientConn.Close()
+
+	fromClient := make(chan clientMessage)
+	clientGone := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(clientGone)
+		defer close(fromClient)
+		for {
+			messageType, msg, err := clientConn.ReadMessage()
+			if err != nil {
+				logger.Info("Client WebSocket disconnected", "error", err)
+				return
+			}
+			select {
+			case fromClient <- clientMessage{messageType, msg}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+
+		targetServer := getHealthyServer(c.Request.Context())
+		if targetServer == "" {
+			logger.Warn("No available game servers for WebSocket")
+			clientConn.WriteMessage(websocket.TextMessage, []byte("Error: No game servers available. Retrying..."))
+			if !waitToRetry(clientGone) {
+				return
+			}
+			continue
+		}
+
+		targetWS := fmt.Sprintf("ws://%s/ws", targetServer[7:])
+		backendLogger := logger.With("backend", targetServer)
+
+		dialCtx, span := tracing.Start(c.Request.Context(), "gateway.websocket_dial",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("backend", targetServer)),
+		)
+		header := http.Header{logging.RequestIDHeader: {logging.RequestID(dialCtx)}}
+		tracing.Inject(dialCtx, header)
+
+		dialStart := time.Now()
+		serverConn, _, err := websocket.DefaultDialer.DialContext(dialCtx, targetWS, header)
+		breakerFor(targetServer).Record(time.Since(dialStart), err)
+		tracing.End(span, err)
+		if err != nil {
+			backendLogger.Warn("Failed to connect to game server WebSocket", "error", err)
+			clientConn.WriteMessage(websocket.TextMessage, []byte("Error: Unable to connect to game server. Retrying..."))
+			if !waitToRetry(clientGone) {
+				return
+			}
+			continue
+		}
+
+		clientConn.WriteMessage(websocket.TextMessage, []byte("Connected to game server: "+targetServer))
+
+		backendLogger.Info("Proxying WebSocket to game server")
+		proxiedWebSockets.WithLabelValues(targetServer).Inc()
+		reconnect := proxyToServer(backendLogger, clientConn, serverConn, fromClient)
+		proxiedWebSockets.WithLabelValues(targetServer).Dec()
+		if !reconnect {
+			return
+		}
+		clientConn.WriteMessage(websocket.TextMessage, []byte("Game server disconnected. Reconnecting..."))
+	}
+}
+
+// waitToRetry waits before the next attempt to reach a game server. It
+// returns false if the player left in the meantime.
+func waitToRetry(clientGone <-chan struct{}) bool {
+	select {
+	case <-clientGone:
+		return false
+	case <-time.After(5 * time.Second):
+		return true
+	}
+}
+
+// proxyToServer relays messages both ways until one side goes away. It
+// returns true if the game server went away and the player should be
+// reconnected, false if the player is gone.
+func proxyToServer(logger *slog.Logger, clientConn, serverConn *websocket.Conn, fromClient <-chan clientMessage) bool {
+	defer serverConn.Close()
+
+	serverDone := make(chan struct{})
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		for {
+			messageType, msg, err := serverConn.ReadMessage()
+			if err != nil {
+				logger.Info("Game server WebSocket disconnected", "error", err)
+				return
+			}
+			if err := clientConn.WriteMessage(messageType, msg); err != nil {
+				logger.Info("Failed to forward message to client", "error", err)
+				close(clientGone)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-fromClient:
+			if !ok {
+				serverConn.Close()
+				<-serverDone
+				return false
+			}
+			if err := serverConn.WriteMessage(msg.messageType, msg.data); err != nil {
+				logger.Warn("Failed to forward message to server", "error", err)
+				serverConn.Close()
+				<-serverDone
+				return true
+			}
+		case <-serverDone:
+			select {
+			case <-clientGone:
+				return false
+			default:
+				return true
+			}
+		}
+	}
+}
+
+func ForwardRequest(c *gin.Context) {
+
+	targetServer := getHealthyServer(c.Request.Context())
+	if targetServer == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No game servers available"})
+		return
+	}
+
+	ctx, span := tracing.Start(c.Request.Context(), "gateway.forward",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("backend", targetServer),
+			attribute.String("http.route", c.Request.URL.Path),
+		),
+	)
+	defer span.End()
+
+	url := fmt.Sprintf("%s%s", targetServer, c.Request.URL.Path)
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, url, c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+		return
+	}
+
+	// The headers include RequestIDHeader, set by logging.Middleware, and
+	// the trace context of the forward span.
+	req.Header = c.Request.Header.Clone()
+	tracing.Inject(ctx, req.Header)
+
+	client := &http.Client{}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		breakerFor(targetServer).Record(time.Since(start), err)
+		recordForward(targetServer, 0, time.Since(start))
+		logging.FromContext(c.Request.Context()).Warn("Failed to reach game server", "backend", targetServer, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach game server"})
+		return
+	}
+	defer resp.Body.Close()
+
+	if isBackendFailure(resp.StatusCode) {
+		err = fmt.Errorf("game server responded with %d", resp.StatusCode)
+	}
+	breakerFor(targetServer).Record(time.Since(start), err)
+	recordForward(targetServer, resp.StatusCode, time.Since(start))
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
+		return
+	}
+
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+}
+
+// isBackendFailure reports whether a status code means the game server itself
+// is unavailable, as opposed to an application-level error for this request.
+func isBackendFailure(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// NewRouter sets up the gateway's own routes and the ones forwarded to the
+// game servers. The handlers use whatever storage was passed to
+// controllers.Configure.
+func NewRouter(corsOrigins []string) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery(), logging.Middleware(), tracing.Middleware())
+	r.GET("/ws", WebSocketHandler)
+
+	r.Use(routes.CORS(corsOrigins))
+
+	routes.RegisterRoutes(r)
+	r.GET("/admin/breakers", BreakerStatus)
+	r.GET("/metrics", gin.WrapH(MetricsHandler()))
+	gameEndpoints := []string{"/start", "/submit", "/menu"}
+	for _, endpoint := range gameEndpoints {
+		r.Any(endpoint, ForwardRequest)
+	}
+	return r
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The gateway's metrics live in their own registry, like the game servers',
+// so that tests can run everything in one process.
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	backendRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "scrambled",
+		Subsystem: "gateway",
+		Name:      "backend_requests_total",
+		Help:      "Requests forwarded to game servers, by backend and status code.",
+	}, []string{"backend", "code"})
+
+	backendLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "scrambled",
+		Subsystem: "gateway",
+		Name:      "backend_request_duration_seconds",
+		Help:      "Time taken by game servers to answer forwarded requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend"})
+
+	backendHealthy = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "scrambled",
+		Subsystem: "gateway",
+		Name:      "backend_healthy",
+		Help:      "Whether the last readiness check found a game server ready (1) or not (0).",
+	}, []string{"backend"})
+
+	proxiedWebSockets = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "scrambled",
+		Subsystem: "gateway",
+		Name:      "proxied_websockets",
+		Help:      "Player WebSockets currently proxied to each game server.",
+	}, []string{"backend"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// recordForward counts a forwarded request. A status of 0 means the game
+// server could not be reached at all.
+func recordForward(backend string, status int, latency time.Duration) {
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	backendRequests.WithLabelValues(backend, code).Inc()
+	backendLatency.WithLabelValues(backend).Observe(latency.Seconds())
+}
+
+func recordHealth(backend string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	backendHealthy.WithLabelValues(backend).Set(value)
+}
+
+// MetricsHandler serves the gateway's metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
@@ -0,0 +1,111 @@
+// Package logging sets up the structured logger shared by the whole server
+// and carries the request and player IDs that log lines are tagged with.
+// Lines are written as JSON so they can be searched by field, e.g. every
+// line for one request_id across the gateway and the game servers.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// RequestIDHeader carries the request ID from the gateway to the game
+// servers, on both forwarded requests and proxied WebSockets.
+const RequestIDHeader = "X-Request-ID"
+
+// Field names shared by every log line that has them.
+const (
+	KeyRequestID = "request_id"
+	KeyPlayerID  = "player_id"
+)
+
+// redactedKeys never make it into the logs, whatever logs them, so that a
+// stray slog.Any("word", ...) cannot give the answers away.
+var redactedKeys = map[string]bool{
+	"word":     true,
+	"guess":    true,
+	"answer":   true,
+	"password": true,
+}
+
+// New returns a JSON logger writing to w at the given level and above.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}))
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if redactedKeys[a.Key] {
+		return slog.String(a.Key, "[redacted]")
+	}
+	return a
+}
+
+// Setup makes a JSON logger at the named level the default, for slog and
+// for anything still using the log package.
+func Setup(level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	slog.SetDefault(New(os.Stderr, lvl))
+	return nil
+}
+
+// Fatal logs at error level and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type loggerKey struct{}
+type requestIDKey struct{}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithLogger stores a logger in ctx.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// With adds fields to the logger stored in ctx.
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
+
+// WithPlayer tags every line logged through ctx with the player's ID.
+func WithPlayer(ctx context.Context, playerID string) context.Context {
+	return With(ctx, KeyPlayerID, playerID)
+}
+
+// WithRequestID stores the request ID in ctx and tags the lines logged
+// through it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return With(ctx, KeyRequestID, id)
+}
+
+// RequestID returns the request ID stored in ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random ID for a request entering the gateway.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quietPaths are polled constantly by Prometheus, so their requests are
+// only logged at debug level.
+var quietPaths = map[string]bool{
+	"/metrics": true,
+}
+
+// Middleware gives every request a new ID, which the gateway passes on to
+// the game servers in RequestIDHeader, echoes it in the response and logs
+// the request once it is done. IDs sent by clients are replaced, since the
+// gateway is where requests enter. It replaces gin's text logger.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := NewRequestID()
+		c.Request.Header.Set(RequestIDHeader, id)
+		c.Header(RequestIDHeader, id)
+
+		ctx := WithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if quietPaths[c.Request.URL.Path] {
+			level = slog.LevelDebug
+		}
+		FromContext(ctx).Log(ctx, level, "Request handled",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
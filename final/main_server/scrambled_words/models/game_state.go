@@ -14,4 +14,6 @@ type GameState struct {
 	Players  []Player `json:"players"`
 	Started  bool     `json:"started"`
 	Winner   *Player  `json:"winner"`
+	// Revision is bumped on every save and used to detect concurrent writes.
+	Revision int64 `json:"revision"`
 }
@@ -14,6 +14,10 @@ func RegisterRoutes(r *gin.Engine) {
 	// r.POST("/menu", controllers.CheckMenu)
 	// r.POST("/submit", controllers.SubmitAnswer)
 	r.GET("/leaderboard", controllers.GetLeaderboard)
+	r.GET("/matches/recent", controllers.GetRecentMatches)
+	r.GET("/matches/:id/replay", controllers.ReplayMatch)
+	r.GET("/users/:id", controllers.GetProfile)
+	r.GET("/users/:id/matches", controllers.GetUserMatches)
 	// r.GET("/ws", func(c *gin.Context) {
 	// 	controllers.HandleWebSocket(c.Writer, c.Request)
 	// })
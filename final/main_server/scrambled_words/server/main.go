@@ -1,224 +1,115 @@
 package main
 
 import (
-	"fmt"
-	"io"
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"scrambled_words/config"
 	"scrambled_words/controllers"
 	"scrambled_words/db"
-	"scrambled_words/routes"
-	"scrambled_words/shared"
-	"sync"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
+	"scrambled_words/gateway"
+	"scrambled_words/logging"
+	"scrambled_words/store"
+	"scrambled_words/tracing"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+const shutdownTimeout = 10 * time.Second
 
-var clients = make(map[*websocket.Conn]bool)
+var (
+	configPath  = flag.String("config", "", "config file, YAML or TOML (default $"+config.PathEnv+")")
+	printConfig = flag.Bool("print-config", false, "print the effective config and exit")
+	memory      = flag.Bool("memory", false, "keep users and game state in memory instead of MongoDB and Redis")
+)
+
+func main() {
+	flag.Parse()
 
-func CheckServerHealth(url string) bool {
-	client := http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Get(url + "/health")
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Printf("Server %s is down: %v", url, err)
-		return false
+		logging.Fatal("Failed to load config", "error", err)
 	}
-	defer resp.Body.Close()
-
-	return resp.StatusCode == http.StatusOK
-}
-
-var gameServers = []string{
-	"http://localhost:8081",
-	"http://localhost:8082",
-}
-
-var mu sync.Mutex
-
-func getHealthyServer() string {
-	for _, server := range gameServers {
-		resp, err := http.Get(server + "/health")
-		if err == nil && resp.StatusCode == http.StatusOK {
-			return server
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			logging.Fatal("Failed to print config", "error", err)
 		}
+		return
 	}
-	return ""
-}
-
-func WebSocketHandler(c *gin.Context) {
-
-	clientConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err := logging.Setup(cfg.Log.Level); err != nil {
+		logging.Fatal("Failed to set up logging", "error", err)
+	}
+	slog.SetDefault(slog.Default().With("server", "gateway"))
+	shutdownTracing, err := tracing.Setup(cfg.Tracing, "gateway")
 	if err != nil {
-		log.Println("WebSocket upgrade failed:", err)
-		return
+		logging.Fatal("Failed to set up tracing", "error", err)
 	}
-	defer clientConn.Close()
-
-	mu.Lock()
-	clients[clientConn] = true
-	mu.Unlock()
 
-	defer func() {
-		mu.Lock()
-		delete(clients, clientConn)
-		mu.Unlock()
-	}()
-
-	for {
-
-		targetServer := getHealthyServer()
-		if targetServer == "" {
-			log.Println("No available game servers for WebSocket")
-			clientConn.WriteMessage(websocket.TextMessage, []byte("Error: No game servers available. Retrying..."))
-			time.Sleep(5 * time.Second)
-			continue
+	if *memory {
+		slog.Warn("Using in-memory storage, nothing will be persisted")
+		controllers.Configure(store.NewMemory())
+	} else {
+		err := db.Connect(cfg.Mongo.URI)
+		switch {
+		case errors.Is(err, db.ErrUnavailable):
+			slog.Warn("MongoDB is unavailable, starting in degraded mode", "error", err)
+		case err != nil:
+			logging.Fatal("Failed to connect to the database", "error", err)
+		default:
+			ensureIndexes(context.Background())
 		}
-
-		targetWS := fmt.Sprintf("ws://%s/ws", targetServer[7:])
-
-		serverConn, _, err := websocket.DefaultDialer.Dial(targetWS, nil)
-		if err != nil {
-			log.Println("Failed to connect to game server WebSocket:", err)
-			clientConn.WriteMessage(websocket.TextMessage, []byte("Error: Unable to connect to game server. Retrying..."))
-			time.Sleep(5 * time.Second)
-			continue
+		if err := db.InitRedis(cfg.Redis.Addr); err != nil {
+			slog.Warn("Redis is unavailable, starting in degraded mode", "error", err)
 		}
-		defer serverConn.Close()
-
-		clientConn.WriteMessage(websocket.TextMessage, []byte("Connected to game server: "+targetServer))
-
-		go func() {
-			for {
-				messageType, msg, err := clientConn.ReadMessage()
-				if err != nil {
-					log.Println("Client WebSocket disconnected:", err)
-					return
-				}
-				if err := serverConn.WriteMessage(messageType, msg); err != nil {
-					log.Println("Failed to forward message to server:", err)
-					return
-				}
-			}
-		}()
-
-		for {
-			messageType, msg, err := serverConn.ReadMessage()
-			if err != nil {
-				log.Println("Game server WebSocket disconnected:", err)
-				clientConn.WriteMessage(websocket.TextMessage, []byte("Game server disconnected. Reconnecting..."))
-				break
-			}
-			if err := clientConn.WriteMessage(messageType, msg); err != nil {
-				log.Println("Failed to forward message to client:", err)
-				return
-			}
-		}
-
-		serverConn.Close()
+		queue := store.NewWriteBehind(store.DefaultMaxPendingWrites)
+		controllers.Configure(queue.Wrap(store.NewProduction()))
 	}
-}
+	controllers.LoadGameState()
 
-func ForwardRequest(c *gin.Context) {
+	gateway.GameServers = cfg.Gateway.Backends
+	r := gateway.NewRouter(cfg.Gateway.CORSOrigins)
 
-	var targetServer string
-	for _, server := range gameServers {
-		resp, err := http.Get(server + "/health")
-		if err == nil && resp.StatusCode == http.StatusOK {
-			targetServer = server
-			break
-		}
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	controllers.StartWriteBehind(ctx)
 
-	if targetServer == "" {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No game servers available"})
-		return
-	}
+	srv := &http.Server{Addr: cfg.Gateway.Listen, Handler: r}
+	go func() {
+		slog.Info("Gateway is listening", "addr", cfg.Gateway.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal("Failed to start server", "error", err)
+		}
+	}()
 
-	url := fmt.Sprintf("%s%s", targetServer, c.Request.URL.Path)
-	req, err := http.NewRequest(c.Request.Method, url, c.Request.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-		return
-	}
+	<-ctx.Done()
+	stop()
 
-	req.Header = c.Request.Header
+	slog.Info("Shutdown signal received")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach game server"})
-		return
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server shutdown failed", "error", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
-		return
+	controllers.FlushWriteBehind(shutdownCtx)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
-
-	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	slog.Info("Gateway stopped")
 }
 
-func broadcastMessages() {
-	for {
-		msg := <-shared.Broadcast
-		for client := range shared.Clients {
-			err := client.WriteJSON(msg)
-			log.Printf("Broadcasting message: %+v\n", msg)
-			log.Printf("Connected clients: %d\n", len(clients))
-			if err != nil {
-				log.Println("WebSocket write error:", err)
-				client.Close()
-				shared.Mu.Lock()
-				delete(shared.Clients, client)
-				shared.Mu.Unlock()
-			}
-		}
-	}
-}
-
-func main() {
-
-	if err := db.Connect(); err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+// ensureIndexes creates the indexes of the collections the gateway reads.
+// The gateway still works without them, only slower.
+func ensureIndexes(ctx context.Context) {
+	if err := store.NewMongoUserStore().EnsureIndexes(ctx); err != nil {
+		slog.Warn("Failed to create match indexes", "error", err)
 	}
-
-	r := gin.Default()
-	r.GET("/ws", WebSocketHandler)
-
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:5500")
-
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-		c.Next()
-	})
-
-	routes.RegisterRoutes(r)
-	gameEndpoints := []string{"/start", "/submit", "/menu"}
-	for _, endpoint := range gameEndpoints {
-		r.Any(endpoint, ForwardRequest)
-	}
-
-	db.InitRedis()
-	controllers.LoadGameState()
-
-	log.Println("Main server is running on http://localhost:8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	if err := store.NewMongoEventLog().EnsureIndexes(ctx); err != nil {
+		slog.Warn("Failed to create game event indexes", "error", err)
 	}
 }
@@ -0,0 +1,447 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"scrambled_words/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserStore keeps users and their matches in memory. It also serves
+// the leaderboard.
+type MemoryUserStore struct {
+	mu      sync.Mutex
+	users   map[primitive.ObjectID]models.User
+	matches []models.Match
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[primitive.ObjectID]models.User)}
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+
+	user.ID = primitive.NewObjectID()
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) find(match func(models.User) bool) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if match(u) {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryUserStore) FindByID(ctx context.Context, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	return s.find(func(u models.User) bool { return u.ID == objID })
+}
+
+func (s *MemoryUserStore) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.find(func(u models.User) bool { return u.Email == email })
+}
+
+func (s *MemoryUserStore) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.find(func(u models.User) bool { return u.Username == username })
+}
+
+func (s *MemoryUserStore) update(id string, change func(*models.User)) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[objID]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&user)
+	s.users[objID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) SetWord(ctx context.Context, id, word string) error {
+	return s.update(id, func(u *models.User) { u.Word = word })
+}
+
+func (s *MemoryUserStore) SetScore(ctx context.Context, id string, score int) error {
+	return s.update(id, func(u *models.User) { u.Score = score })
+}
+
+func (s *MemoryUserStore) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	return s.update(id, func(u *models.User) {
+		u.Word = word
+		u.Score = score
+	})
+}
+
+func (s *MemoryUserStore) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	var score int
+	solved := false
+	err := s.update(id, func(u *models.User) {
+		if u.Word != word {
+			return
+		}
+		u.Word = newWord
+		u.Score++
+		score, solved = u.Score, true
+	})
+	if err == nil && !solved {
+		err = ErrWordChanged
+	}
+	return score, err
+}
+
+func (s *MemoryUserStore) RecordWin(ctx context.Context, match *models.Match) error {
+	var winnerIDs []primitive.ObjectID
+	for _, id := range match.WinnerIDs() {
+		winnerID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return ErrInvalidID
+		}
+		winnerIDs = append(winnerIDs, winnerID)
+	}
+	if match.ID.IsZero() {
+		match.ID = primitive.NewObjectID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.matches {
+		if m.ID == match.ID {
+			return nil
+		}
+	}
+	found := false
+	for _, winnerID := range winnerIDs {
+		if winner, ok := s.users[winnerID]; ok {
+			winner.Wins++
+			s.users[winnerID] = winner
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	for id, change := range match.RatingChanges() {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		if user, ok := s.users[userID]; ok {
+			user.Rating = change.Rating
+			user.RatingHistory = appendRatingChange(user.RatingHistory, change)
+			s.users[userID] = user
+		}
+	}
+
+	stored := *match
+	stored.Players = append([]models.MatchPlayer(nil), match.Players...)
+	stored.Words = append([]models.SolvedWord(nil), match.Words...)
+	stored.Teams = append([]models.MatchTeam(nil), match.Teams...)
+	s.matches = append(s.matches, stored)
+	return nil
+}
+
+func (s *MemoryUserStore) RecentMatches(ctx context.Context, skip, limit int) ([]models.Match, error) {
+	return s.listMatches(func(models.Match) bool { return true }, skip, limit), nil
+}
+
+func (s *MemoryUserStore) UserMatches(ctx context.Context, userID string, skip, limit int) ([]models.Match, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return nil, ErrInvalidID
+	}
+	return s.listMatches(func(m models.Match) bool {
+		for _, p := range m.Players {
+			if p.ID == userID {
+				return true
+			}
+		}
+		return false
+	}, skip, limit), nil
+}
+
+func (s *MemoryUserStore) FindMatch(ctx context.Context, id string) (*models.Match, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.matches {
+		if m.ID == objID {
+			return &m, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryUserStore) PreviousMatch(ctx context.Context, room string, t time.Time) (*models.Match, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var previous *models.Match
+	for i, m := range s.matches {
+		if m.Room == room && m.EndedAt.Before(t) && (previous == nil || m.EndedAt.After(previous.EndedAt)) {
+			previous = &s.matches[i]
+		}
+	}
+	if previous == nil {
+		return nil, ErrNotFound
+	}
+	match := *previous
+	return &match, nil
+}
+
+func (s *MemoryUserStore) listMatches(match func(models.Match) bool, skip, limit int) []models.Match {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []models.Match{}
+	for i := len(s.matches) - 1; i >= 0; i-- {
+		if match(s.matches[i]) {
+			matches = append(matches, s.matches[i])
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].EndedAt.After(matches[j].EndedAt)
+	})
+	if skip >= len(matches) {
+		return []models.Match{}
+	}
+	matches = matches[skip:]
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+func (s *MemoryUserStore) Leaderboard(ctx context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if users[i].Wins != users[j].Wins {
+			return users[i].Wins > users[j].Wins
+		}
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+func (s *MemoryUserStore) RatingLeaderboard(ctx context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if users[i].CurrentRating() != users[j].CurrentRating() {
+			return users[i].CurrentRating() > users[j].CurrentRating()
+		}
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+// MemoryGameStateStore keeps the game state in memory. States are copied in
+// and out so callers never share slices with the store.
+type MemoryGameStateStore struct {
+	mu      sync.Mutex
+	state   *models.GameState
+	players map[string]models.Player
+}
+
+func NewMemoryGameStateStore() *MemoryGameStateStore {
+	return &MemoryGameStateStore{players: make(map[string]models.Player)}
+}
+
+func (s *MemoryGameStateStore) Load(ctx context.Context) (*models.GameState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		return nil, ErrNotFound
+	}
+
+	gameState := *s.state
+	gameState.Players = []models.Player{}
+	for _, player := range s.players {
+		gameState.Players = append(gameState.Players, player)
+	}
+	sort.Slice(gameState.Players, func(i, j int) bool {
+		return gameState.Players[i].Name < gameState.Players[j].Name
+	})
+	return &gameState, nil
+}
+
+func (s *MemoryGameStateStore) Save(ctx context.Context, gameState *models.GameState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gameState.Revision++
+	stored := *gameState
+	stored.Players = nil
+	s.state = &stored
+	return nil
+}
+
+func (s *MemoryGameStateStore) SavePlayer(ctx context.Context, player models.Player) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.players[memoryPlayerKey(player)] = player
+	return nil
+}
+
+func (s *MemoryGameStateStore) RemovePlayer(ctx context.Context, player models.Player) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.players, memoryPlayerKey(player))
+	return nil
+}
+
+func memoryPlayerKey(player models.Player) string {
+	if player.ID != "" {
+		return player.ID
+	}
+	return "name:" + player.Name
+}
+
+// MemoryEventBus delivers events to subscribers in the same process. Events
+// are copied so subscribers may keep them.
+type MemoryEventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+func NewMemoryEventBus() *MemoryEventBus {
+	return &MemoryEventBus{subscribers: make(map[chan []byte]struct{})}
+}
+
+func (b *MemoryEventBus) Publish(ctx context.Context, event []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- append([]byte(nil), event...):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *MemoryEventBus) Subscribe(ctx context.Context, handle func([]byte)) {
+	ch := make(chan []byte, 64)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-ch:
+			handle(event)
+		}
+	}
+}
+
+// MemoryEventLog keeps the game event log in memory.
+type MemoryEventLog struct {
+	mu     sync.Mutex
+	events []models.GameEvent
+}
+
+func NewMemoryEventLog() *MemoryEventLog {
+	return &MemoryEventLog{}
+}
+
+func (l *MemoryEventLog) Append(ctx context.Context, event *models.GameEvent) error {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.events {
+		if e.ID == event.ID {
+			return nil
+		}
+	}
+	l.events = append(l.events, *event)
+	return nil
+}
+
+func (l *MemoryEventLog) Events(ctx context.Context, room, matchID string, after, until time.Time) ([]models.GameEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := []models.GameEvent{}
+	for _, e := range l.events {
+		inMatch := e.MatchID == "" || e.MatchID == matchID
+		if e.Room == room && inMatch && e.At.After(after) && !e.At.After(until) {
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, nil
+}
+
+// NewMemory returns stores that keep everything in memory, for tests and for
+// running the server without MongoDB or Redis.
+func NewMemory() Stores {
+	users := NewMemoryUserStore()
+	return Stores{
+		Users:       users,
+		GameState:   NewMemoryGameStateStore(),
+		Leaderboard: users,
+		Matches:     users,
+		EventLog:    NewMemoryEventLog(),
+		Events:      NewMemoryEventBus(),
+	}
+}
@@ -0,0 +1,401 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"scrambled_words/db"
+	"scrambled_words/models"
+	"scrambled_words/tracing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MongoUserStore keeps users in the scrambled_words.users collection and
+// finished games in scrambled_words.matches. It also serves the leaderboard.
+type MongoUserStore struct {
+	collection *mongo.Collection
+	matches    *mongo.Collection
+}
+
+// NewMongoUserStore needs db.Connect to have been called.
+func NewMongoUserStore() *MongoUserStore {
+	return &MongoUserStore{
+		collection: db.GetCollection("scrambled_words", "users"),
+		matches:    db.GetCollection("scrambled_words", "matches"),
+	}
+}
+
+// startSpan traces a single operation on the users collection.
+func (s *MongoUserStore) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return startSpan(ctx, s.collection, operation)
+}
+
+func startSpan(ctx context.Context, collection *mongo.Collection, operation string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "mongo."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.collection", collection.Name()),
+		),
+	)
+}
+
+func (s *MongoUserStore) Create(ctx context.Context, user *models.User) error {
+	countCtx, span := s.startSpan(ctx, "CountDocuments")
+	count, err := s.collection.CountDocuments(countCtx, bson.M{"email": user.Email})
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailTaken
+	}
+
+	user.ID = primitive.NewObjectID()
+	insertCtx, span := s.startSpan(ctx, "InsertOne")
+	_, err = s.collection.InsertOne(insertCtx, user)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *MongoUserStore) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	ctx, span := s.startSpan(ctx, "FindOne")
+	var user models.User
+	err := s.collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		span.End()
+		return nil, ErrNotFound
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *MongoUserStore) FindByID(ctx context.Context, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	return s.findOne(ctx, bson.M{"_id": objID})
+}
+
+func (s *MongoUserStore) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.findOne(ctx, bson.M{"email": email})
+}
+
+func (s *MongoUserStore) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.findOne(ctx, bson.M{"username": username})
+}
+
+func (s *MongoUserStore) update(ctx context.Context, id string, update bson.M) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	ctx, span := s.startSpan(ctx, "UpdateOne")
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) SetWord(ctx context.Context, id, word string) error {
+	return s.update(ctx, id, bson.M{"$set": bson.M{"word": word}})
+}
+
+func (s *MongoUserStore) SetScore(ctx context.Context, id string, score int) error {
+	return s.update(ctx, id, bson.M{"$set": bson.M{"score": score}})
+}
+
+func (s *MongoUserStore) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	return s.update(ctx, id, bson.M{"$set": bson.M{"word": word, "score": score}})
+}
+
+func (s *MongoUserStore) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, ErrInvalidID
+	}
+
+	// Matching on the word makes the check and the award a single
+	// operation: of two servers solving the same word only one matches.
+	findCtx, span := s.startSpan(ctx, "FindOneAndUpdate")
+	var user models.User
+	err = s.collection.FindOneAndUpdate(findCtx,
+		bson.M{"_id": objID, "word": word},
+		bson.M{"$set": bson.M{"word": newWord}, "$inc": bson.M{"score": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"score": 1}),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		span.End()
+		if _, err := s.FindByID(ctx, id); err != nil {
+			return 0, err
+		}
+		return 0, ErrWordChanged
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return 0, err
+	}
+	return user.Score, nil
+}
+
+// RecordWin runs in a transaction, which needs MongoDB to run as a replica
+// set; a single node one will do.
+func (s *MongoUserStore) RecordWin(ctx context.Context, match *models.Match) (err error) {
+	var winnerIDs []primitive.ObjectID
+	for _, id := range match.WinnerIDs() {
+		winnerID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return ErrInvalidID
+		}
+		winnerIDs = append(winnerIDs, winnerID)
+	}
+	if match.ID.IsZero() {
+		match.ID = primitive.NewObjectID()
+	}
+
+	ctx, span := tracing.Start(ctx, "mongo.RecordWin", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mongodb")))
+	defer func() { tracing.End(span, err) }()
+
+	session, err := s.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		insertCtx, span := startSpan(ctx, s.matches, "InsertOne")
+		_, err := s.matches.InsertOne(insertCtx, match)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+
+		updateCtx, span := s.startSpan(ctx, "UpdateMany")
+		result, err := s.collection.UpdateMany(updateCtx, bson.M{"_id": bson.M{"$in": winnerIDs}}, bson.M{"$inc": bson.M{"wins": 1}})
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrNotFound
+		}
+
+		for id, change := range match.RatingChanges() {
+			userID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				continue
+			}
+			updateCtx, span := s.startSpan(ctx, "UpdateOne")
+			_, err = s.collection.UpdateOne(updateCtx, bson.M{"_id": userID}, bson.M{
+				"$set": bson.M{"rating": change.Rating},
+				"$push": bson.M{"rating_history": bson.M{
+					"$each":  bson.A{change},
+					"$slice": -ratingHistoryLimit,
+				}},
+			})
+			tracing.End(span, err)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// An earlier attempt committed; only its reply was lost.
+		return nil
+	}
+	return err
+}
+
+func (s *MongoUserStore) RecentMatches(ctx context.Context, skip, limit int) ([]models.Match, error) {
+	return s.findMatches(ctx, bson.M{}, skip, limit)
+}
+
+func (s *MongoUserStore) UserMatches(ctx context.Context, userID string, skip, limit int) ([]models.Match, error) {
+	if !primitive.IsValidObjectID(userID) {
+		return nil, ErrInvalidID
+	}
+	return s.findMatches(ctx, bson.M{"players.id": userID}, skip, limit)
+}
+
+func (s *MongoUserStore) findMatches(ctx context.Context, filter bson.M, skip, limit int) (matches []models.Match, err error) {
+	ctx, span := startSpan(ctx, s.matches, "Find")
+	defer func() { tracing.End(span, err) }()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "ended_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := s.matches.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	matches = []models.Match{}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+func (s *MongoUserStore) FindMatch(ctx context.Context, id string) (*models.Match, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	return s.findMatch(ctx, bson.M{"_id": objID}, nil)
+}
+
+func (s *MongoUserStore) PreviousMatch(ctx context.Context, room string, t time.Time) (*models.Match, error) {
+	filter := bson.M{"room": roomFilter(room), "ended_at": bson.M{"$lt": t}}
+	return s.findMatch(ctx, filter, bson.D{{Key: "ended_at", Value: -1}})
+}
+
+// roomFilter matches the room field of the documents of the game of room.
+// The lobby game leaves the field out.
+func roomFilter(room string) any {
+	if room == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return room
+}
+
+func (s *MongoUserStore) findMatch(ctx context.Context, filter bson.M, sort bson.D) (*models.Match, error) {
+	ctx, span := startSpan(ctx, s.matches, "FindOne")
+	opts := options.FindOne()
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	var match models.Match
+	err := s.matches.FindOne(ctx, filter, opts).Decode(&match)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		span.End()
+		return nil, ErrNotFound
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// EnsureIndexes creates the indexes the match listings rely on. Creating an
+// index that already exists does nothing.
+func (s *MongoUserStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.matches.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ended_at", Value: -1}}},
+		{Keys: bson.D{{Key: "players.id", Value: 1}, {Key: "ended_at", Value: -1}}},
+	})
+	return err
+}
+
+func (s *MongoUserStore) Leaderboard(ctx context.Context) (users []models.User, err error) {
+	ctx, span := s.startSpan(ctx, "Find")
+	defer func() { tracing.End(span, err) }()
+
+	cursor, err := s.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"wins": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *MongoUserStore) RatingLeaderboard(ctx context.Context) (users []models.User, err error) {
+	ctx, span := s.startSpan(ctx, "Aggregate")
+	defer func() { tracing.End(span, err) }()
+
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$addFields", Value: bson.M{"rating": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$rating", 0}}, "$rating", models.InitialRating,
+		}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "rating", Value: -1}, {Key: "username", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// MongoEventLog keeps the game event log in scrambled_words.game_events.
+type MongoEventLog struct {
+	collection *mongo.Collection
+}
+
+// NewMongoEventLog needs db.Connect to have been called.
+func NewMongoEventLog() *MongoEventLog {
+	return &MongoEventLog{collection: db.GetCollection("scrambled_words", "game_events")}
+}
+
+func (l *MongoEventLog) Append(ctx context.Context, event *models.GameEvent) error {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+
+	ctx, span := startSpan(ctx, l.collection, "InsertOne")
+	_, err := l.collection.InsertOne(ctx, event)
+	tracing.End(span, err)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *MongoEventLog) Events(ctx context.Context, room, matchID string, after, until time.Time) (events []models.GameEvent, err error) {
+	ctx, span := startSpan(ctx, l.collection, "Find")
+	defer func() { tracing.End(span, err) }()
+
+	filter := bson.M{
+		"room":     roomFilter(room),
+		"match_id": bson.M{"$in": bson.A{matchID, "", nil}},
+		"at":       bson.M{"$gt": after, "$lte": until},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := l.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events = []models.GameEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// EnsureIndexes creates the index replays read the log by.
+func (l *MongoEventLog) EnsureIndexes(ctx context.Context) error {
+	keys := bson.D{{Key: "room", Value: 1}, {Key: "at", Value: 1}}
+	_, err := l.collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+	return err
+}
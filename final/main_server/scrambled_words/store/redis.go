@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"scrambled_words/db"
+	"scrambled_words/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisGameStateStore keeps the game state in Redis. It needs
+// db.InitRedis to have been called.
+type RedisGameStateStore struct{}
+
+func (RedisGameStateStore) Load(ctx context.Context) (*models.GameState, error) {
+	gameState, err := db.LoadGameState(ctx)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return gameState, err
+}
+
+func (RedisGameStateStore) Save(ctx context.Context, gameState *models.GameState) error {
+	return db.SaveGameState(ctx, gameState)
+}
+
+func (RedisGameStateStore) SavePlayer(ctx context.Context, player models.Player) error {
+	return db.SavePlayer(ctx, player)
+}
+
+func (RedisGameStateStore) RemovePlayer(ctx context.Context, player models.Player) error {
+	return db.RemovePlayer(ctx, player)
+}
+
+// RedisEventBus publishes game events on db.EventsChannel.
+type RedisEventBus struct{}
+
+func (RedisEventBus) Publish(ctx context.Context, event []byte) error {
+	return db.PublishEvent(ctx, event)
+}
+
+func (RedisEventBus) Subscribe(ctx context.Context, handle func([]byte)) {
+	db.SubscribeEvents(ctx, handle)
+}
+
+// NewProduction returns the MongoDB and Redis backed stores. db.Connect and
+// db.InitRedis must have been called first.
+func NewProduction() Stores {
+	users := NewMongoUserStore()
+	return Stores{
+		Users:       users,
+		GameState:   RedisGameStateStore{},
+		Leaderboard: users,
+		Matches:     users,
+		EventLog:    NewMongoEventLog(),
+		Events:      RedisEventBus{},
+	}
+}
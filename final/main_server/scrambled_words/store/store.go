@@ -0,0 +1,119 @@
+// Package store defines the storage the servers depend on, with
+// MongoDB/Redis implementations for production and in-memory ones for tests
+// and running without any databases.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"scrambled_words/models"
+)
+
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrInvalidID  = errors.New("invalid id")
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrWordChanged means the word was solved or skipped by another request
+	// before the guess was checked.
+	ErrWordChanged = errors.New("word changed")
+)
+
+// ratingHistoryLimit is how many rating changes are kept per user.
+const ratingHistoryLimit = 50
+
+// appendRatingChange adds a change to a rating history, dropping the oldest
+// beyond ratingHistoryLimit.
+func appendRatingChange(history []models.RatingChange, change models.RatingChange) []models.RatingChange {
+	history = append(history, change)
+	if len(history) > ratingHistoryLimit {
+		history = append([]models.RatingChange(nil), history[len(history)-ratingHistoryLimit:]...)
+	}
+	return history
+}
+
+// UserStore holds signed up users and their per-game progress.
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	SetWord(ctx context.Context, id, word string) error
+	SetScore(ctx context.Context, id string, score int) error
+	SetWordAndScore(ctx context.Context, id, word string, score int) error
+	// SolveWord replaces the user's word with newWord and adds a point, but
+	// only if the word is still word, in one atomic update, so that a word
+	// solved twice at once scores once. It returns the new score, or
+	// ErrWordChanged.
+	SolveWord(ctx context.Context, id, word, newWord string) (int, error)
+	// RecordWin adds a win to the match's winner, sets the new rating of
+	// every player the match rated and stores the match, all or nothing. It
+	// assigns the match an ID if it has none, and does nothing for a match
+	// that is already stored, so it is safe to retry.
+	RecordWin(ctx context.Context, match *models.Match) error
+}
+
+// GameStateStore persists the shared game state. Players are saved one at a
+// time so that servers updating different players don't overwrite each
+// other.
+type GameStateStore interface {
+	Load(ctx context.Context) (*models.GameState, error)
+	Save(ctx context.Context, gameState *models.GameState) error
+	SavePlayer(ctx context.Context, player models.Player) error
+	RemovePlayer(ctx context.Context, player models.Player) error
+}
+
+// MatchStore lists finished games, most recently ended first. skip and
+// limit select a page of them.
+type MatchStore interface {
+	RecentMatches(ctx context.Context, skip, limit int) ([]models.Match, error)
+	// UserMatches lists the games the user played in.
+	UserMatches(ctx context.Context, userID string, skip, limit int) ([]models.Match, error)
+	// FindMatch returns ErrInvalidID or ErrNotFound for a match it cannot
+	// find.
+	FindMatch(ctx context.Context, id string) (*models.Match, error)
+	// PreviousMatch returns the last match of the game of room that ended
+	// before t, or ErrNotFound if there is none.
+	PreviousMatch(ctx context.Context, room string, t time.Time) (*models.Match, error)
+}
+
+// EventLog is the append-only log of everything that happens in a game,
+// written by the game servers and read back to replay matches.
+type EventLog interface {
+	Append(ctx context.Context, event *models.GameEvent) error
+	// Events returns the events of the game of room logged after after and
+	// up to until that belong to match matchID or to no match, in the order
+	// they happened.
+	Events(ctx context.Context, room, matchID string, after, until time.Time) ([]models.GameEvent, error)
+}
+
+// LeaderboardStore ranks users by wins, or by skill rating for ranked
+// leaderboards.
+type LeaderboardStore interface {
+	Leaderboard(ctx context.Context) ([]models.User, error)
+	// RatingLeaderboard counts users who are not rated yet as having
+	// models.InitialRating.
+	RatingLeaderboard(ctx context.Context) ([]models.User, error)
+}
+
+// EventBus carries encoded game events between game servers.
+type EventBus interface {
+	Publish(ctx context.Context, event []byte) error
+	// Subscribe calls handle for every published event, including the
+	// subscriber's own, until ctx is done.
+	Subscribe(ctx context.Context, handle func([]byte))
+}
+
+// Stores bundles everything the handlers need.
+type Stores struct {
+	Users       UserStore
+	GameState   GameStateStore
+	Leaderboard LeaderboardStore
+	Matches     MatchStore
+	EventLog    EventLog
+	Events      EventBus
+	// Queue buffers the writes made while a store is down. It is nil when
+	// writes are not buffered.
+	Queue *WriteBehind
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"second_server/models"
+	"second_server/shared"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterChatMasksBannedWords(t *testing.T) {
+	ConfigureChat([]string{"darn", "h.ck"}, nil)
+	t.Cleanup(func() { ConfigureChat(nil, nil) })
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"well darn it", "well **** it"},
+		{"DARN, Darn!", "****, ****!"},
+		{"darned good", "darned good"},
+		{"heck h.ck", "heck ****"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, filterChat(tt.text), tt.text)
+	}
+}
+
+func TestAllowChatLimitsMessagesPerWindow(t *testing.T) {
+	t.Cleanup(func() { chatSent = make(map[string][]time.Time) })
+
+	now := time.Now()
+	for i := 0; i < chatRateLimit; i++ {
+		assert.True(t, allowChat("kal", now.Add(time.Duration(i)*time.Millisecond)))
+	}
+	assert.False(t, allowChat("kal", now.Add(time.Second)))
+	assert.True(t, allowChat("abebe", now.Add(time.Second)), "the limit is per player")
+	assert.True(t, allowChat("kal", now.Add(chatRateWindow+time.Second)))
+}
+
+func TestModerationHoldsForOnePlayerInOneGame(t *testing.T) {
+	t.Cleanup(func() {
+		mutedUntil = make(map[moderated]time.Time)
+		kickedUntil = make(map[moderated]time.Time)
+	})
+
+	now := time.Now()
+	until := now.Add(time.Minute)
+	handleModeration(gameEvent{Kind: eventModeration, Game: "room", Target: "1",
+		Moderation: &shared.ModerationPayload{Action: moderationMute, Player: "kal", Until: until}})
+	handleModeration(gameEvent{Kind: eventModeration, Target: "2",
+		Moderation: &shared.ModerationPayload{Action: moderationKick, Player: "sara", Until: until}})
+
+	got, muted := muteExpiry("room", "1", now)
+	assert.True(t, muted)
+	assert.Equal(t, until, got)
+	_, muted = muteExpiry("", "1", now)
+	assert.False(t, muted, "the player is muted in the room only")
+	_, muted = muteExpiry("room", "3", now)
+	assert.False(t, muted, "another player of the same game is not muted")
+	assert.True(t, isKicked("", "2"))
+	assert.False(t, isKicked("room", "2"))
+
+	for _, want := range []string{"room", ""} {
+		select {
+		case got := <-shared.Broadcast:
+			assert.Equal(t, want, got.Room, "moderation is told to the game it happened in")
+		default:
+			t.Fatal("moderation was not delivered")
+		}
+	}
+}
+
+func TestHostPassesToAConnectedPlayer(t *testing.T) {
+	rostersMu.Lock()
+	rosters["other"] = roster{players: []rosterPlayer{{PlayerSummary: shared.PlayerSummary{Name: "abebe"}, ID: "2"}}, updatedAt: time.Now()}
+	rostersMu.Unlock()
+	t.Cleanup(func() {
+		rostersMu.Lock()
+		delete(rosters, "other")
+		rostersMu.Unlock()
+	})
+
+	game := &models.GameState{
+		HostID:  "1",
+		Players: []models.Player{{ID: "1", Name: "kal"}, {ID: "3", Name: "gone"}, {ID: "2", Name: "abebe"}},
+	}
+	mu.Lock()
+	_, dropped := dropPlayer(game, "1")
+	mu.Unlock()
+
+	assert.True(t, dropped)
+	assert.Equal(t, "2", game.HostID, "a player who hung up does not get the game")
+	assert.Len(t, game.Players, 2)
+}
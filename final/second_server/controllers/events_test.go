@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"second_server/shared"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rosterEvent(t *testing.T, origin string, names ...string) []byte {
+	t.Helper()
+	players := []rosterPlayer{}
+	for _, name := range names {
+		players = append(players, rosterPlayer{PlayerSummary: shared.PlayerSummary{Name: name}})
+	}
+	data, err := json.Marshal(gameEvent{Kind: eventRoster, Origin: origin, SentAt: time.Now(), Roster: players})
+	assert.NoError(t, err)
+	return data
+}
+
+func playerNames() []string {
+	rostersMu.Lock()
+	defer rostersMu.Unlock()
+
+	names := []string{}
+	for _, p := range allPlayers() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestRosterEventsMergePlayersAcrossServers(t *testing.T) {
+	t.Cleanup(func() { rosters = make(map[string]roster) })
+
+	handleEvent(rosterEvent(t, "server-a", "kal", "abebe"))
+	handleEvent(rosterEvent(t, "server-b", "sara"))
+	assert.Equal(t, []string{"kal", "abebe", "sara"}, playerNames())
+
+	handleEvent(rosterEvent(t, "server-a", "kal"))
+	assert.Equal(t, []string{"kal", "sara"}, playerNames())
+
+	handleEvent(rosterEvent(t, "server-b"))
+	assert.Equal(t, []string{"kal"}, playerNames())
+}
+
+func TestRosterEventsAddUpSpectators(t *testing.T) {
+	t.Cleanup(func() { rosters = make(map[string]roster) })
+
+	data, err := json.Marshal(gameEvent{Kind: eventRoster, Origin: "server-a", SentAt: time.Now(), Spectators: map[string]int{"": 2}})
+	assert.NoError(t, err)
+	handleEvent(data)
+	data, err = json.Marshal(gameEvent{Kind: eventRoster, Origin: "server-b", SentAt: time.Now(), Spectators: map[string]int{"": 1, "room": 1},
+		Roster: []rosterPlayer{{PlayerSummary: shared.PlayerSummary{Name: "sara"}}}})
+	assert.NoError(t, err)
+	handleEvent(data)
+
+	rostersMu.Lock()
+	defer rostersMu.Unlock()
+	assert.Equal(t, map[string]int{"": 3, "room": 1}, spectatorCounts(), "a server with only spectators keeps its roster")
+}
+
+func TestRosterOfSilentServerExpires(t *testing.T) {
+	t.Cleanup(func() { rosters = make(map[string]roster) })
+
+	handleEvent(rosterEvent(t, "server-a", "kal"))
+	handleEvent(rosterEvent(t, "server-b", "sara"))
+
+	rostersMu.Lock()
+	r := rosters["server-b"]
+	r.updatedAt = time.Now().Add(-rosterTTL - time.Second)
+	rosters["server-b"] = r
+	rostersMu.Unlock()
+
+	assert.Equal(t, []string{"kal"}, playerNames())
+}
+
+func TestBroadcastEventIsDeliveredLocally(t *testing.T) {
+	msg := shared.NewMessage(shared.TypeGameOver, shared.GameOverPayload{Winner: "kal"})
+	data, err := json.Marshal(gameEvent{Kind: eventBroadcast, Origin: "server-b", Game: "room", Message: &msg})
+	assert.NoError(t, err)
+
+	handleEvent(data)
+
+	select {
+	case got := <-shared.Broadcast:
+		assert.Equal(t, shared.TypeGameOver, got.Message.Type)
+		assert.Equal(t, "room", got.Room, "the message is for the players of the room only")
+	default:
+		t.Fatal("broadcast event was not queued for local delivery")
+	}
+}
+
+func TestGamePlayersKeepsGamesApart(t *testing.T) {
+	players := []rosterPlayer{
+		{PlayerSummary: shared.PlayerSummary{Name: "kal"}},
+		{PlayerSummary: shared.PlayerSummary{Name: "sara"}, Room: "room"},
+		{PlayerSummary: shared.PlayerSummary{Name: "abebe"}},
+	}
+
+	assert.Equal(t, []shared.PlayerSummary{{Name: "kal"}, {Name: "abebe"}}, gamePlayers(players, ""))
+	assert.Equal(t, []shared.PlayerSummary{{Name: "sara"}}, gamePlayers(players, "room"))
+	assert.Equal(t, []shared.PlayerSummary{}, gamePlayers(players, "other"))
+}
@@ -2,13 +2,17 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
-	"second_server/db"
+	"second_server/logging"
+	"second_server/metrics"
 	"second_server/models"
 	"second_server/shared"
+	"second_server/store"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -16,12 +20,20 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
+// gameState is the lobby game, played by everyone not in a room. It is the
+// only game kept in the game store.
 var gameState = models.GameState{}
+
+// roomGames holds the game of each room by room ID. Like the rooms, they
+// are kept in memory and in step across the game servers by events.
+var roomGames = make(map[string]*models.GameState)
 var mu sync.Mutex
 
+// winningScore is how many words a player must solve to win a match.
+const winningScore = 3
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
@@ -30,21 +42,49 @@ func LoadGameState() {
 	mu.Lock()
 	defer mu.Unlock()
 
-	storedState, err := db.LoadGameState()
+	ctx, cancel := storeContext(context.Background())
+	defer cancel()
+
+	storedState, err := gameStore.Load(ctx)
 	if err == nil {
 		gameState = *storedState
 	} else {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to load game state", "error", err)
+		}
 		gameState = models.GameState{}
 	}
 }
 
+// gameOf returns the game of the room, or the lobby game for "". Callers
+// must hold mu.
+func gameOf(room string) *models.GameState {
+	if room == "" {
+		return &gameState
+	}
+	game, ok := roomGames[room]
+	if !ok {
+		game = &models.GameState{Room: room}
+		roomGames[room] = game
+	}
+	return game
+}
+
+// eventGame returns the game an event from another server is about, or nil
+// if the event is about a room that is no longer open. Callers must hold
+// mu.
+func eventGame(room string) *models.GameState {
+	if room != "" && !roomOpen(room) {
+		return nil
+	}
+	return gameOf(room)
+}
+
+// generateWord picks a random word. It leaves the game state alone, so it
+// needs no lock.
 func generateWord() string {
 	words := []string{"apple", "banana", "cherry", "grape", "orange"}
-	word := words[rand.Intn(len(words))]
-	shuffled := shuffleString(word)
-	gameState.Word = word
-	gameState.Shuffled = shuffled
-	return word
+	return words[rand.Intn(len(words))]
 }
 
 func shuffleString(s string) string {
@@ -55,8 +95,10 @@ func shuffleString(s string) string {
 }
 
 func JoinGame(c *gin.Context) {
-	mu.Lock()
-	defer mu.Unlock()
+	if IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is draining"})
+		return
+	}
 
 	var player models.Player
 	if err := c.ShouldBindJSON(&player); err != nil {
@@ -64,8 +106,10 @@ func JoinGame(c *gin.Context) {
 		return
 	}
 
+	mu.Lock()
 	player.Score = 0
 	gameState.Players = append(gameState.Players, player)
+	savePlayer(c.Request.Context(), &gameState, player)
 
 	playerNames := []string{}
 	for _, p := range gameState.Players {
@@ -77,9 +121,10 @@ func JoinGame(c *gin.Context) {
 		"player":       player,
 		"joined_users": playerNames,
 	})
-	log.Println("Saving updated game state to Redis...")
-	db.SaveGameState(&gameState)
-	log.Println("Game state saved to Redis.")
+	logging.FromContext(c.Request.Context()).Info("Player joined", logging.KeyPlayerID, player.ID)
+	saveGameState(c.Request.Context(), &gameState)
+	mu.Unlock()
+	recordEvent(c.Request.Context(), &gameState, models.GameEvent{Type: models.EventJoin, PlayerID: player.ID, Player: player.Name})
 }
 
 func CheckMenu(c *gin.Context) {
@@ -96,29 +141,26 @@ func CheckMenu(c *gin.Context) {
 		return
 	}
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := storeContext(c.Request.Context())
 	defer cancel()
 
-	objID, err := primitive.ObjectIDFromHex(request.PlayerID)
-	if err != nil {
+	if !primitive.IsValidObjectID(request.PlayerID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Player ID"})
 		return
 	}
 
 	if request.Type == "new" {
 
-		_, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"score": 0}})
-		if err != nil {
+		err := users.SetScore(ctx, request.PlayerID, 0)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset score"})
 			return
 		}
 
-		for i := range gameState.Players {
-			if gameState.Players[i].ID == request.PlayerID {
-				gameState.Players[i].Score = 0
-				break
-			}
+		game := gameOf(playerRoom(request.PlayerID))
+		if p := getPlayerByID(game, request.PlayerID); p != nil {
+			p.Score = 0
+			savePlayer(ctx, game, *p)
 		}
 	}
 
@@ -127,86 +169,218 @@ func CheckMenu(c *gin.Context) {
 	})
 }
 
-func StartGame(c *gin.Context) {
-	shared.Mu.Lock()
-	defer shared.Mu.Unlock()
+// gameError is a failed game action. Status is used for HTTP responses and
+// Code for WebSocket error messages.
+type gameError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *gameError) payload() *shared.ErrorPayload {
+	return &shared.ErrorPayload{Code: e.Code, Message: e.Message}
+}
+
+func (e *gameError) respond(c *gin.Context) {
+	c.JSON(e.Status, gin.H{"error": e.Message})
+}
+
+var (
+	errInvalidPlayerID = &gameError{http.StatusBadRequest, shared.ErrCodeInvalidPayload, "Invalid Player ID"}
+	errDraining        = &gameError{http.StatusServiceUnavailable, shared.ErrCodeDraining, "Server is draining"}
+	errWordChanged     = &gameError{http.StatusConflict, shared.ErrCodeWordChanged, "Word was already solved or skipped"}
+	errRaceSkip        = &gameError{http.StatusConflict, shared.ErrCodeForbidden, "Words cannot be skipped in a race"}
+)
+
+func internalError(message string) *gameError {
+	return &gameError{http.StatusInternalServerError, shared.ErrCodeInternal, message}
+}
 
+// findUser looks up a player's account, reporting a missing one with
+// notFoundStatus.
+func findUser(ctx context.Context, id string, notFoundStatus int) (*models.User, *gameError) {
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	user, err := users.FindByID(storeCtx, id)
+	switch {
+	case errors.Is(err, store.ErrInvalidID):
+		return nil, errInvalidPlayerID
+	case errors.Is(err, store.ErrNotFound):
+		return nil, &gameError{notFoundStatus, shared.ErrCodeNotFound, "Player not found"}
+	case err != nil:
+		logging.FromContext(ctx).Error("Failed to look up player", "error", err)
+		return nil, internalError("Failed to look up player")
+	}
+	return user, nil
+}
+
+// guessOutcome is the result of a submitted guess, shared by the HTTP and
+// WebSocket handlers. Team is the winning team when the guess won a team
+// game.
+type guessOutcome struct {
+	Player  models.Player
+	Correct bool
+	Won     bool
+	Team    string
+	NewWord string
+	Scores  []shared.ScoreEntry
+	Teams   []shared.TeamScore
+}
+
+func (o *guessOutcome) message() string {
+	switch {
+	case o.Won && o.Team != "":
+		return fmt.Sprintf("Team %s won the game!", o.Team)
+	case o.Won:
+		return fmt.Sprintf("%s won the game!", o.Player.Name)
+	case o.Correct:
+		return "Correct! New word assigned."
+	default:
+		return "Incorrect, try again!"
+	}
+}
+
+func StartGame(c *gin.Context) {
 	var request struct {
 		PlayerID string `json:"player_id"`
+		Mode     string `json:"mode"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
 		return
 	}
+	if err := (&shared.StartGameRequest{Mode: request.Mode}).Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	playerID, err := primitive.ObjectIDFromHex(request.PlayerID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Player ID"})
+	ctx := logging.WithPlayer(c.Request.Context(), request.PlayerID)
+	started, gameErr := startGame(ctx, request.PlayerID, request.Mode)
+	if gameErr != nil {
+		gameErr.respond(c)
 		return
 	}
 
-	userCollection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	message := shared.NewMessage(shared.TypeStartGame, started)
 
-	var targetPlayer shared.Player
-	err = userCollection.FindOne(ctx, bson.M{"_id": playerID}).Decode(&targetPlayer)
-	if err != nil {
-		log.Println("Player not found in users collection:", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
-		return
+	shared.Mu.Lock()
+	for client, player := range shared.Players {
+		if player.ID.Hex() == request.PlayerID {
+			client.Send(message)
+			break
+		}
 	}
+	shared.Mu.Unlock()
 
-	newWord := generateWord()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"word":    started.Word,
+		"mode":    started.Mode,
+	})
+}
 
-	_, err = userCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": playerID},
-		bson.M{"$set": bson.M{"word": newWord}},
-	)
-	if err != nil {
-		log.Printf("Failed to update player word: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update player word"})
-		return
+// startGame assigns the player a word in the game of their room, or the
+// lobby game, and returns it. The first player to start a match picks its
+// mode, except in a room, which plays the mode it was opened with. In a
+// race everyone is given the word of the current round instead of a fresh
+// one, and a team match is announced to the other servers so that their
+// players score for their teams too. ctx carries the logger for the
+// request.
+func startGame(ctx context.Context, id, mode string) (shared.StartGamePayload, *gameError) {
+	if IsDraining() {
+		return shared.StartGamePayload{}, errDraining
 	}
 
-	for conn, player := range shared.Players {
-		if player.ID == playerID {
-			player.Word = newWord
-			shared.Players[conn] = player
-			break
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+
+	user, gameErr := findUser(ctx, id, http.StatusNotFound)
+	if gameErr != nil {
+		return shared.StartGamePayload{}, gameErr
+	}
+
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	room := playerRoom(id)
+	if room != "" {
+		mode = roomMode(room)
+	}
+
+	mu.Lock()
+	game := gameOf(room)
+	matchStarted := startMatch(game)
+	if matchStarted {
+		game.Mode = mode
+		switch mode {
+		case shared.ModeRace:
+			startRound(game, 1, game.StartedAt)
+		case shared.ModeTeam:
+			startTeamMatch(game)
 		}
 	}
+	if game.Mode == "" {
+		game.Mode = shared.ModeClassic
+	}
+	started := shared.StartGamePayload{Mode: game.Mode}
+	var newWord, scrambled string
+	if started.Mode == shared.ModeRace {
+		newWord, scrambled = game.Word, game.Shuffled
+	} else {
+		newWord = generateWord()
+		scrambled = shuffleString(newWord)
+	}
+	started.Word = newWord
+	if started.Mode == shared.ModeRace {
+		// Everyone races to unscramble the same word, so only its scrambled
+		// form is given out.
+		started.Word = scrambled
+	}
+	race := currentRace(game)
+	teams := currentTeamScores(game)
+	if matchStarted {
+		saveGameState(ctx, game)
+	}
+	mu.Unlock()
 
-	message := shared.Message{
-		Type:    "start_game",
-		Payload: gin.H{"word": newWord},
+	if matchStarted {
+		resetScores(ctx, game, id)
+		user.Score = 0
+	}
+	if err := users.SetWord(storeCtx, id, newWord); err != nil {
+		logging.FromContext(ctx).Error("Failed to update player word", "error", err)
+		return shared.StartGamePayload{}, internalError("Failed to update player word")
 	}
 
-	for conn, player := range shared.Players {
-		if player.ID == playerID {
-			err := conn.WriteJSON(message)
-			if err != nil {
-				log.Println("Error sending message to client:", err)
-				conn.Close()
-				delete(shared.Clients, conn)
-				delete(shared.Players, conn)
-			}
+	for client, player := range shared.Players {
+		if player.ID == user.ID {
+			player.Word = newWord
+			player.Scrambled = scrambled
+			shared.Players[client] = player
 			break
 		}
 	}
+	go broadcastPlayerList()
+
+	if matchStarted {
+		switch started.Mode {
+		case shared.ModeRace:
+			publishRound(room, race)
+		case shared.ModeTeam:
+			publishTeamScores(room, teams)
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"word":    newWord,
-	})
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventWordAssigned, PlayerID: id, Player: user.Username, Word: newWord, Scrambled: scrambled, Score: user.Score})
+	if matchStarted {
+		metrics.GamesStarted.Inc()
+	}
+	logging.FromContext(ctx).Info("Game started", "mode", started.Mode)
+	return started, nil
 }
 
 func SubmitAnswer(c *gin.Context) {
-	log.Println("Acquiring lock in SubmitAnswer()")
-	shared.Mu.Lock()
-	defer shared.Mu.Unlock()
-
 	var request struct {
 		PlayerID string `json:"player_id"`
 		Guess    string `json:"guess"`
@@ -216,151 +390,530 @@ func SubmitAnswer(c *gin.Context) {
 		return
 	}
 
-	collection := db.GetCollection("scrambled_words", "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var player models.Player
-	objID, err := primitive.ObjectIDFromHex(request.PlayerID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Player ID"})
+	ctx := logging.WithPlayer(c.Request.Context(), request.PlayerID)
+	outcome, gameErr := submitGuess(ctx, request.PlayerID, request.Guess)
+	if gameErr != nil {
+		gameErr.respond(c)
 		return
 	}
 
-	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&player)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Player not found"})
-		return
+	switch {
+	case outcome.Won:
+		c.JSON(http.StatusOK, gin.H{
+			"message":      outcome.message(),
+			"correct":      true,
+			"player":       outcome.Player,
+			"new_word":     outcome.NewWord,
+			"scores":       outcome.Scores,
+			"teams":        outcome.Teams,
+			"winning_team": outcome.Team,
+		})
+	case outcome.Correct:
+		c.JSON(http.StatusOK, gin.H{
+			"message": outcome.message(),
+			"correct": true,
+			"player": gin.H{
+				"name":  outcome.Player.Name,
+				"score": outcome.Player.Score,
+			},
+			"new_word": outcome.NewWord,
+			"scores":   outcome.Scores,
+			"teams":    outcome.Teams,
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"message": outcome.message(),
+			"correct": false,
+			"scores":  outcome.Scores,
+			"teams":   outcome.Teams,
+		})
+	}
+}
+
+// submitGuess checks a guess against the player's current word, awarding a
+// point and a new word when it is right and ending the game when the player
+// reaches the winning score. In a team game the point counts for the
+// player's team as well, and the game ends when a team reaches the target
+// instead. Neither the guess nor the word is logged.
+func submitGuess(ctx context.Context, id, guess string) (*guessOutcome, *gameError) {
+	logger := logging.FromContext(ctx)
+
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+
+	user, gameErr := findUser(ctx, id, http.StatusBadRequest)
+	if gameErr != nil {
+		return nil, gameErr
+	}
+	player := models.Player{ID: id, Name: user.Username, Word: user.Word, Score: user.Score}
+	room := playerRoom(id)
+
+	mu.Lock()
+	game := gameOf(room)
+	racing := game.Started && game.Mode == shared.ModeRace
+	teamGame := game.Started && game.Mode == shared.ModeTeam
+	matchStart := game.StartedAt
+	teamTarget := teamCfg.Target
+	if p := getPlayerByID(game, id); p != nil {
+		player.Team = p.Team
+	}
+	mu.Unlock()
+	if racing {
+		return submitRaceGuess(ctx, game, player, guess)
 	}
 
-	log.Printf("Player ID: %s - Retrieved Word from DB: %s", request.PlayerID, player.Word)
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
 
 	if player.Word == "" {
 		player.Word = generateWord()
-		updateResult, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"word": player.Word}})
-		if err != nil {
-			log.Println("Error assigning word to player:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign word"})
-			return
+		if err := users.SetWord(storeCtx, id, player.Word); err != nil {
+			logger.Error("Failed to assign word to player", "error", err)
+			return nil, internalError("Failed to assign word")
 		}
-		log.Printf("New word assigned from DB: %s (Update result: %v)", player.Word, updateResult)
+		logger.Info("Assigned a word to player without one")
+		recordEvent(ctx, game, models.GameEvent{Type: models.EventWordAssigned, PlayerID: id, Player: player.Name, Word: player.Word, Score: player.Score})
 	}
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventGuess, PlayerID: id, Player: player.Name, Guess: guess, Score: player.Score})
 
 	normalizedWord := strings.ToLower(player.Word)
-	normalizedGuess := strings.ToLower(request.Guess)
-
-	log.Printf("Normalized Word from DB: %s, Normalized Guess: %s", normalizedWord, normalizedGuess)
+	normalizedGuess := strings.ToLower(guess)
+
+	if normalizedGuess != normalizedWord {
+		logger.Debug("Incorrect guess")
+		metrics.RecordGuess(false)
+		outcome := &guessOutcome{Player: player}
+		outcome.Scores, outcome.Teams = getScores(game)
+		return outcome, nil
+	}
 
-	if normalizedGuess == normalizedWord {
-		player.Score++
+	newWord := generateWord()
+	score, err := users.SolveWord(storeCtx, id, player.Word, newWord)
+	switch {
+	case errors.Is(err, store.ErrWordChanged):
+		logger.Info("Word was solved or skipped by another request")
+		return nil, errWordChanged
+	case err != nil:
+		logger.Error("Failed to update word", "error", err)
+		return nil, internalError("Failed to update word")
+	}
+	player.Score = score
+	logger.Info("Correct guess", "score", player.Score)
+	metrics.RecordGuess(true)
 
-		newWord := generateWord()
-		updateResult, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"word": newWord, "score": player.Score}})
+	teamScore := 0
+	if teamGame {
+		teamScore, err = gameStore.AddTeamPoint(storeCtx, matchStart, player.Team)
 		if err != nil {
-			log.Println("Error updating word in DB:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update word"})
-			return
+			logger.Error("Failed to add team point", "team", player.Team, "error", err)
+			return nil, internalError("Failed to update team score")
 		}
-		log.Printf("New word updated in DB: %s (Update result: %v)", newWord, updateResult)
-
-		for conn, p := range shared.Players {
-			if p.Name == player.Name {
-				p.Score = player.Score
-				p.Word = newWord
-				shared.Players[conn] = p
-				break
-			}
+	}
+
+	scrambled := shuffleString(newWord)
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventCorrect, PlayerID: id, Player: player.Name, Word: player.Word, Score: score})
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventWordAssigned, PlayerID: id, Player: player.Name, Word: newWord, Scrambled: scrambled, Score: score})
+
+	for client, p := range shared.Players {
+		if p.Name == player.Name {
+			p.Score = player.Score
+			p.Word = newWord
+			p.Scrambled = scrambled
+			shared.Players[client] = p
+			break
 		}
+	}
 
-		go broadcastPlayerList()
-
-		if player.Score == 3 {
-			gameState.Winner = &player
-			gameState.Started = false
-			log.Println("Broadcasting game over for winner:", player.Name)
-
-			select {
-			case shared.Broadcast <- shared.Message{
-				Type: "game_over",
-				Payload: gin.H{
-					"winner":  player.Name,
-					"message": fmt.Sprintf("%s won the game!", player.Name),
-				},
-			}:
-				log.Println("Game over broadcast sent.")
-			default:
-				log.Println("Broadcast channel is full, dropping message!")
-			}
-
-			_, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$inc": bson.M{"wins": 1}})
-			if err != nil {
-				log.Printf("Failed to update wins: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wins"})
-				return
-			}
-
-			c.JSON(http.StatusOK, gin.H{
-				"message":  fmt.Sprintf("%s won the game!", player.Name),
-				"correct":  true,
-				"player":   player,
-				"new_word": shuffleString(newWord),
-				"scores":   getScores(),
-			})
-		} else {
-			c.JSON(http.StatusOK, gin.H{
-				"message": "Correct! New word assigned.",
-				"correct": true,
-				"player": gin.H{
-					"name":  player.Name,
-					"score": player.Score,
-				},
-				"new_word": shuffleString(newWord),
-				"scores":   getScores(),
-			})
+	mu.Lock()
+	if p := getPlayerByID(game, id); p != nil {
+		p.Score = player.Score
+		savePlayer(ctx, game, *p)
+	}
+	startMatch(game)
+	game.Solved = append(game.Solved, models.SolvedWord{
+		PlayerID: id,
+		Player:   player.Name,
+		Word:     player.Word,
+		SolvedAt: time.Now().UTC(),
+	})
+	var teams teamScores
+	if teamGame {
+		if game.TeamScores == nil {
+			game.TeamScores = make(map[string]int)
+		}
+		game.TeamScores[player.Team] = max(game.TeamScores[player.Team], teamScore)
+		teams = currentTeamScores(game)
+	}
+	saveGameState(ctx, game)
+	mu.Unlock()
+
+	go broadcastPlayerList()
+	solved := shared.WordSolvedPayload{Player: player.Name, Score: player.Score}
+	if teamGame {
+		solved.Team, solved.TeamScore = player.Team, teamScore
+		publishTeamScores(room, teams)
+	}
+	publishGameEvent(room, shared.NewMessage(shared.TypeWordSolved, solved))
+
+	outcome := &guessOutcome{
+		Player:  player,
+		Correct: true,
+		NewWord: scrambled,
+	}
+	outcome.Scores, outcome.Teams = getScores(game)
+
+	// A team that reaches the target wins, and of the players scoring for
+	// it at once, here or on other servers, only the one who claims the win
+	// ends the game. A win that could not be stored is stored again with
+	// the winner's next point.
+	won := wonUnrecorded(game, player)
+	switch {
+	case won:
+	case teamGame && teamScore >= teamTarget:
+		won, err = gameStore.ClaimTeamWin(storeCtx, matchStart, player.Team)
+		if err != nil {
+			logger.Error("Failed to claim team win", "team", player.Team, "error", err)
+			return nil, internalError("Failed to update team score")
+		}
+	case !teamGame:
+		won = player.Score == winningScore
+	}
+	if won {
+		if gameErr := finishMatch(ctx, game, player); gameErr != nil {
+			return nil, gameErr
 		}
+		outcome.Won = true
+		if teamGame {
+			outcome.Team = player.Team
+		}
+	}
+	return outcome, nil
+}
+
+// wonUnrecorded reports whether the player, or their team, won the match of
+// game but the win could not be stored.
+func wonUnrecorded(game *models.GameState, player models.Player) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return game.Unrecorded != nil && slices.Contains(game.Unrecorded.WinnerIDs(), player.ID)
+}
 
+// finishMatch records the win of player, or of their team in a team game,
+// rates the players, ends the match of game, resets everyone's score for
+// the next one and tells its players. The win is stored before anyone is
+// told about it, so a failure leaves nothing to take back; the match is
+// kept, ID and all, so that storing it again cannot count it twice.
+// Callers must hold shared.Mu.
+func finishMatch(ctx context.Context, game *models.GameState, player models.Player) *gameError {
+	logger := logging.FromContext(ctx)
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	mu.Lock()
+	unrecorded := game.Unrecorded
+	mu.Unlock()
+	var match models.Match
+	if unrecorded != nil {
+		match = *unrecorded
 	} else {
-		log.Println("Incorrect guess. Try again.")
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Incorrect, try again!",
-			"correct": false,
-			"scores":  getScores(),
-		})
+		match = finishedMatch(game, player)
+		rateMatch(storeCtx, &match)
+	}
+	if err := users.RecordWin(storeCtx, &match); err != nil {
+		logger.Error("Failed to record win", "match_id", match.ID.Hex(), "error", err)
+		mu.Lock()
+		game.Unrecorded = &match
+		mu.Unlock()
+		return internalError("Failed to record win")
+	}
+
+	mu.Lock()
+	game.Winner = &player
+	endMatch(game)
+	saveGameState(ctx, game)
+	mu.Unlock()
+	resetScores(ctx, game, player.ID)
+	logger.Info("Player won the game", "winner", player.Name, "team", match.WinningTeam, "match_id", match.ID.Hex())
+	recordEvent(ctx, game, models.GameEvent{
+		Type:     models.EventGameOver,
+		PlayerID: player.ID,
+		Player:   player.Name,
+		Score:    player.Score,
+		MatchID:  match.ID.Hex(),
+		At:       match.EndedAt,
+	})
+
+	if match.WinningTeam != "" {
+		final := teamScores{
+			MatchStartedAt: match.StartedAt,
+			MatchID:        match.ID.Hex(),
+			Scores:         make(map[string]int),
+			Winner:         match.WinningTeam,
+		}
+		for _, team := range match.Teams {
+			final.Scores[team.Name] = team.Score
+		}
+		publishTeamScores(game.Room, final)
+	}
+
+	won := guessOutcome{Player: player, Correct: true, Won: true, Team: match.WinningTeam}
+	publishGameEvent(game.Room, shared.NewMessage(shared.TypeGameOver, shared.GameOverPayload{
+		Winner:  player.Name,
+		Team:    match.WinningTeam,
+		Message: won.message(),
+	}))
+	metrics.Wins.Inc()
+	return nil
+}
+
+// skipWord gives the player a new word without scoring the current one.
+func skipWord(ctx context.Context, id string) (string, *gameError) {
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+
+	room := playerRoom(id)
+	mu.Lock()
+	game := gameOf(room)
+	racing := game.Started && game.Mode == shared.ModeRace
+	mu.Unlock()
+	if racing {
+		return "", errRaceSkip
 	}
+
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	newWord := generateWord()
+	err := users.SetWord(storeCtx, id, newWord)
+	switch {
+	case errors.Is(err, store.ErrInvalidID):
+		return "", errInvalidPlayerID
+	case errors.Is(err, store.ErrNotFound):
+		return "", &gameError{http.StatusNotFound, shared.ErrCodeNotFound, "Player not found"}
+	case err != nil:
+		logging.FromContext(ctx).Error("Failed to skip word", "error", err)
+		return "", internalError("Failed to assign word")
+	}
+
+	scrambled := shuffleString(newWord)
+	var name string
+	for client, p := range shared.Players {
+		if p.ID.Hex() == id {
+			name = p.Name
+			p.Word = newWord
+			p.Scrambled = scrambled
+			shared.Players[client] = p
+			break
+		}
+	}
+	go broadcastPlayerList()
+
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventSkip, PlayerID: id, Player: name})
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventWordAssigned, PlayerID: id, Player: name, Word: newWord, Scrambled: scrambled})
+	return scrambled, nil
+}
+
+// startMatch starts a new match of game unless one is under way, and
+// reports whether it did. Callers must hold mu.
+func startMatch(game *models.GameState) bool {
+	if game.Started {
+		return false
+	}
+	game.Started = true
+	game.StartedAt = time.Now().UTC()
+	game.MatchID = primitive.NewObjectID().Hex()
+	game.Solved = nil
+	return true
+}
+
+// endMatch clears the finished match from game. Callers must hold mu.
+func endMatch(game *models.GameState) {
+	game.Unrecorded = nil
+	game.Started = false
+	game.StartedAt = time.Time{}
+	game.MatchID = ""
+	game.Solved = nil
+	game.Mode = ""
+	game.Round = 0
+	game.RoundStartedAt = time.Time{}
+	game.Rounds = nil
+	game.TeamScores = nil
 }
 
-func getScores() []map[string]interface{} {
+// finishedMatch records the match of game won by winner, under the ID it
+// was given when it started, with everyone's final score and the words
+// solved along the way. A team game also records
+// who played on which team, the team scores and the winner's team.
+func finishedMatch(game *models.GameState, winner models.Player) models.Match {
 	mu.Lock()
 	defer mu.Unlock()
 
-	var scores []map[string]interface{}
+	teamGame := game.Mode == shared.ModeTeam
+	if !teamGame {
+		winner.Team = ""
+	}
+	endedAt := time.Now().UTC()
+	id, err := primitive.ObjectIDFromHex(game.MatchID)
+	if err != nil {
+		id = primitive.NewObjectID()
+	}
+	match := models.Match{
+		ID:         id,
+		WinnerID:   winner.ID,
+		Winner:     winner.Name,
+		Players:    []models.MatchPlayer{{ID: winner.ID, Name: winner.Name, Score: winner.Score, Team: winner.Team}},
+		Words:      append([]models.SolvedWord(nil), game.Solved...),
+		Room:       game.Room,
+		StartedAt:  game.StartedAt,
+		EndedAt:    endedAt,
+		DurationMS: endedAt.Sub(game.StartedAt).Milliseconds(),
+	}
+	for _, p := range game.Players {
+		if p.ID == winner.ID {
+			continue
+		}
+		player := models.MatchPlayer{ID: p.ID, Name: p.Name, Score: p.Score}
+		if teamGame {
+			player.Team = p.Team
+		}
+		match.Players = append(match.Players, player)
+	}
 
-	for _, player := range gameState.Players {
-		scores = append(scores, map[string]interface{}{
-			"name":   player.Name,
-			"points": player.Score,
-		})
+	if teamGame {
+		match.WinningTeam = winner.Team
+		for _, team := range shared.Teams {
+			match.Teams = append(match.Teams, models.MatchTeam{Name: team, Score: game.TeamScores[team]})
+		}
+	}
+	return match
+}
+
+// resetScores sets the score of every player of game, here and on the other
+// servers, and of the players with the given IDs back to zero, so that
+// nobody carries points from one match into the next. Callers must hold
+// shared.Mu and not mu.
+func resetScores(ctx context.Context, game *models.GameState, ids ...string) {
+	mu.Lock()
+	room := game.Room
+	for i := range game.Players {
+		game.Players[i].Score = 0
+		savePlayer(ctx, game, game.Players[i])
+		ids = append(ids, game.Players[i].ID)
+	}
+	mu.Unlock()
+
+	rostersMu.Lock()
+	for _, p := range allPlayers() {
+		if p.Room == room {
+			ids = append(ids, p.ID)
+		}
 	}
-	if len(scores) == 0 {
-		return []map[string]interface{}{}
+	rostersMu.Unlock()
+
+	for client, p := range shared.Players {
+		if p.Room == room {
+			p.Score = 0
+			shared.Players[client] = p
+		}
 	}
 
-	return scores
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+	slices.Sort(ids)
+	for _, id := range slices.Compact(ids) {
+		if err := users.SetScore(storeCtx, id, 0); err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to reset score", logging.KeyPlayerID, id, "error", err)
+		}
+	}
 }
 
-func getPlayerByID(id string) *models.Player {
-	for i, p := range gameState.Players {
+// getScores returns the score of everyone in game, sorted by team, and the
+// same scores grouped by team with each team's score.
+func getScores(game *models.GameState) ([]shared.ScoreEntry, []shared.TeamScore) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	scores := []shared.ScoreEntry{}
+	for _, player := range game.Players {
+		scores = append(scores, shared.ScoreEntry{
+			Name:   player.Name,
+			Points: player.Score,
+			Team:   player.Team,
+		})
+	}
+
+	return scores, groupScores(scores, game.TeamScores)
+}
+
+func getPlayerByID(game *models.GameState, id string) *models.Player {
+	for i, p := range game.Players {
 		if p.ID == id {
-			return &gameState.Players[i]
+			return &game.Players[i]
 		}
 	}
 	return nil
 }
 
-func LeaveGame(c *gin.Context) {
-	mu.Lock()
-	defer mu.Unlock()
+// addPlayer puts the player in game, or updates them if they are in it
+// already, and makes them the host of a game without one. Callers must
+// hold mu.
+func addPlayer(ctx context.Context, game *models.GameState, player models.Player) {
+	if existing := getPlayerByID(game, player.ID); existing != nil {
+		*existing = player
+	} else {
+		game.Players = append(game.Players, player)
+	}
+	savePlayer(ctx, game, player)
+	if game.HostID == "" || getPlayerByID(game, game.HostID) == nil {
+		game.HostID = player.ID
+		saveGameState(ctx, game)
+	}
+}
+
+// dropPlayer takes the player out of game, handing the game to the next
+// connected player if they hosted it, and returns them if they were in it.
+// Callers must hold mu and not rostersMu.
+func dropPlayer(game *models.GameState, id string) (models.Player, bool) {
+	var dropped models.Player
+	found := false
+	for i, player := range game.Players {
+		if player.ID == id {
+			game.Players = append(game.Players[:i], game.Players[i+1:]...)
+			dropped, found = player, true
+			break
+		}
+	}
+	if game.HostID == id {
+		game.HostID = nextHost(game)
+	}
+	return dropped, found
+}
+
+// nextHost picks who hosts game once its host has gone: the first of its
+// players still connected to a game server, or the first player at all
+// when none has shown up on a roster yet. Callers must hold mu and not
+// rostersMu.
+func nextHost(game *models.GameState) string {
+	if len(game.Players) == 0 {
+		return ""
+	}
+
+	rostersMu.Lock()
+	connected := make(map[string]bool)
+	for _, p := range allPlayers() {
+		connected[p.ID] = true
+	}
+	rostersMu.Unlock()
+
+	for _, player := range game.Players {
+		if connected[player.ID] {
+			return player.ID
+		}
+	}
+	return game.Players[0].ID
+}
 
+func LeaveGame(c *gin.Context) {
 	var request struct {
 		PlayerID string `json:"player_id"`
 	}
@@ -370,15 +923,22 @@ func LeaveGame(c *gin.Context) {
 		return
 	}
 
-	for i, player := range gameState.Players {
-		if player.ID == request.PlayerID {
-			gameState.Players = append(gameState.Players[:i], gameState.Players[i+1:]...)
-			break
-		}
-	}
+	leaveGame(logging.WithPlayer(c.Request.Context(), request.PlayerID), request.PlayerID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Player left the game",
 	})
-	db.SaveGameState(&gameState)
+}
+
+// leaveGame removes the player from the lobby game and persists it.
+// Players leave the game of a room by leaving the room.
+func leaveGame(ctx context.Context, id string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if player, ok := dropPlayer(&gameState, id); ok {
+		removePlayer(ctx, &gameState, player)
+		logging.FromContext(ctx).Info("Player left the game")
+	}
+	saveGameState(ctx, &gameState)
 }
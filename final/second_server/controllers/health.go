@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"second_server/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxClients is how many WebSocket connections the server takes before it
+// reports itself full and turns new ones away. Set it before serving.
+var MaxClients = 500
+
+// readinessTimeout bounds each dependency check, so that a hung database
+// fails readiness instead of hanging the gateway's probe.
+const readinessTimeout = 2 * time.Second
+
+// Readiness statuses, from most to least severe. A degraded server has lost
+// a dependency but keeps playing, buffering its writes until the dependency
+// returns; the gateway only uses it when no server is ready.
+const (
+	StatusDraining    = "draining"
+	StatusUnavailable = "unavailable"
+	StatusFull        = "full"
+	StatusDegraded    = "degraded"
+	StatusReady       = "ready"
+)
+
+// CheckResult is the outcome of pinging one dependency.
+type CheckResult struct {
+	OK        bool   `json:"ok"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Capacity struct {
+	Clients    int `json:"clients"`
+	MaxClients int `json:"max_clients"`
+	Available  int `json:"available"`
+}
+
+// Readiness is the body of /readyz. The gateway prefers servers whose status
+// is ready.
+type Readiness struct {
+	Status   string                 `json:"status"`
+	Draining bool                   `json:"draining"`
+	Checks   map[string]CheckResult `json:"checks"`
+	Capacity Capacity               `json:"capacity"`
+	// PendingWrites counts the writes buffered until a store comes back.
+	PendingWrites int `json:"pending_writes"`
+}
+
+// CheckReadiness pings every dependency of the configured stores at once and
+// combines the results with the draining state, the free capacity and the
+// writes waiting for a store.
+func CheckReadiness(ctx context.Context) Readiness {
+	readiness := Readiness{
+		Status:   StatusReady,
+		Draining: IsDraining(),
+		Checks:   make(map[string]CheckResult, len(healthChecks)),
+	}
+
+	var wg sync.WaitGroup
+	var checksMu sync.Mutex
+	for _, check := range healthChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(checkCtx)
+			result := CheckResult{OK: err == nil, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			checksMu.Lock()
+			readiness.Checks[check.Name] = result
+			checksMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	clients := clientCount()
+	readiness.Capacity = Capacity{
+		Clients:    clients,
+		MaxClients: MaxClients,
+		Available:  max(MaxClients-clients, 0),
+	}
+
+	queueFull := false
+	if writeQueue != nil {
+		readiness.PendingWrites = writeQueue.Pending()
+		queueFull = writeQueue.Full()
+	}
+
+	checksPassed := readiness.checksPassed()
+	switch {
+	case readiness.Draining:
+		readiness.Status = StatusDraining
+	case queueFull:
+		readiness.Status = StatusUnavailable
+	case !checksPassed && writeQueue == nil:
+		// Without a queue, writes made now would be lost.
+		readiness.Status = StatusUnavailable
+	case readiness.Capacity.Available == 0:
+		readiness.Status = StatusFull
+	case !checksPassed, readiness.PendingWrites > 0:
+		readiness.Status = StatusDegraded
+	}
+	return readiness
+}
+
+func (r Readiness) checksPassed() bool {
+	for _, result := range r.Checks {
+		if !result.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Livez only tells whether the process is up and serving. It does not look
+// at any dependency, so a database outage never gets the server restarted.
+func Livez(c *gin.Context) {
+	c.String(http.StatusOK, "OK")
+}
+
+// Readyz tells whether the server should get new players, with the detail
+// of every check. A degraded server still answers 200 since it can play.
+func Readyz(c *gin.Context) {
+	readiness := CheckReadiness(c.Request.Context())
+	status := http.StatusOK
+	if readiness.Status != StatusReady && readiness.Status != StatusDegraded {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, readiness)
+}
+
+func clientCount() int {
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+	return len(shared.Clients)
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"second_server/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessFailsWithoutWriteBuffer(t *testing.T) {
+	checks, queue := healthChecks, writeQueue
+	t.Cleanup(func() { healthChecks, writeQueue = checks, queue })
+
+	healthChecks = []store.HealthCheck{
+		{Name: "mongo", Check: func(context.Context) error { return nil }},
+		{Name: "redis", Check: func(context.Context) error { return errors.New("connection refused") }},
+	}
+	writeQueue = nil
+	readiness := CheckReadiness(context.Background())
+	assert.Equal(t, StatusUnavailable, readiness.Status, "writes made now would be lost")
+	assert.True(t, readiness.Checks["mongo"].OK)
+	assert.Equal(t, "connection refused", readiness.Checks["redis"].Error)
+
+	writeQueue = store.NewWriteBehind(store.DefaultMaxPendingWrites)
+	assert.Equal(t, StatusDegraded, CheckReadiness(context.Background()).Status)
+}
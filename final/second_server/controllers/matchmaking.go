@@ -0,0 +1,364 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"second_server/config"
+	"second_server/logging"
+	"second_server/models"
+	"second_server/shared"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matchmakingTick is how often the queue is looked at again, to form rooms
+// as rating windows widen and to tell queued players where they stand.
+const matchmakingTick = time.Second
+
+var (
+	queueMu     sync.Mutex
+	matchmaking = config.Default().Matchmaking
+)
+
+// queueUpdate is what a game server tells the others when it took players
+// out of the queue and put them in a room: the IDs of the players and the
+// room they play in.
+type queueUpdate struct {
+	Players []string                  `json:"players"`
+	Match   *shared.MatchFoundPayload `json:"match"`
+}
+
+// ConfigureMatchmaking sets the room size and rating windows of the
+// matchmaking queue.
+func ConfigureMatchmaking(cfg config.MatchmakingConfig) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	matchmaking = cfg
+}
+
+// StartMatchmaker forms rooms from the queue as the rating windows of
+// waiting players widen, and keeps them told of their place in the queue,
+// until ctx is done.
+func StartMatchmaker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(matchmakingTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				matchQueue()
+				sendQueueStatus()
+			}
+		}
+	}()
+}
+
+// playerRating is the rating players are matched by: their skill rating.
+func playerRating(user *models.User) int {
+	return user.CurrentRating()
+}
+
+// ratingWindow is how far apart ratings may be for a player who has waited
+// that long for a room.
+func ratingWindow(cfg config.MatchmakingConfig, waited time.Duration) int {
+	window := cfg.RatingWindow
+	if cfg.WidenSeconds > 0 {
+		window += cfg.WindowGrowth * int(waited/(time.Duration(cfg.WidenSeconds)*time.Second))
+	}
+	return min(window, cfg.MaxRatingWindow)
+}
+
+// formRoom picks the players of the next room, or returns nil if no room can
+// be formed yet. The longest waiting player who can be matched goes first,
+// with the players closest to their rating who queued for the same mode and
+// are within their rating window.
+func formRoom(entries []models.QueueEntry, cfg config.MatchmakingConfig, now time.Time) []int {
+	for i, anchor := range entries {
+		window := ratingWindow(cfg, now.Sub(anchor.QueuedAt))
+		var candidates []int
+		for j, other := range entries {
+			if j != i && other.Mode == anchor.Mode && abs(other.Rating-anchor.Rating) <= window {
+				candidates = append(candidates, j)
+			}
+		}
+		if len(candidates) < cfg.RoomSize-1 {
+			continue
+		}
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return abs(entries[candidates[a]].Rating-anchor.Rating) < abs(entries[candidates[b]].Rating-anchor.Rating)
+		})
+		return append([]int{i}, candidates[:cfg.RoomSize-1]...)
+	}
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// enqueue puts a player in the matchmaking queue for a game of the given
+// mode. A player already queued keeps their place and only changes mode.
+func enqueue(ctx context.Context, player shared.Player, mode string) (shared.QueueStatusPayload, *gameError) {
+	if IsDraining() {
+		return shared.QueueStatusPayload{}, errDraining
+	}
+	if mode == "" {
+		mode = shared.ModeClassic
+	}
+	user, gameErr := findUser(ctx, player.ID.Hex(), http.StatusNotFound)
+	if gameErr != nil {
+		return shared.QueueStatusPayload{}, gameErr
+	}
+
+	logger := logging.FromContext(ctx)
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	entry, err := queueStore.Enqueue(storeCtx, models.QueueEntry{
+		PlayerID: player.ID.Hex(),
+		Name:     player.Name,
+		Rating:   playerRating(user),
+		Mode:     mode,
+		QueuedAt: time.Now().UTC(),
+		Origin:   serverID,
+	})
+	if err != nil {
+		logger.Error("Failed to join the queue", "error", err)
+		return shared.QueueStatusPayload{}, internalError("Failed to join the queue")
+	}
+	queue, err := queueStore.Queue(storeCtx)
+	if err != nil {
+		logger.Error("Failed to read the queue", "error", err)
+		return shared.QueueStatusPayload{}, internalError("Failed to read the queue")
+	}
+
+	queueMu.Lock()
+	status := queueStatus(queue, *entry, time.Now())
+	queueMu.Unlock()
+	logger.Info("Player queued for a room", "mode", mode, "rating", entry.Rating)
+	return status, nil
+}
+
+// dequeue takes the player out of the matchmaking queue and reports whether
+// they were in it.
+func dequeue(playerID string) bool {
+	ctx, cancel := storeContext(context.Background())
+	defer cancel()
+
+	queued, err := queueStore.Dequeue(ctx, playerID)
+	if err != nil {
+		slog.Error("Failed to leave the queue", logging.KeyPlayerID, playerID, "error", err)
+	}
+	return queued
+}
+
+// queueStatus describes where a queued player stands among the players of
+// queue queued for the same mode. Callers must hold queueMu.
+func queueStatus(queue []models.QueueEntry, entry models.QueueEntry, now time.Time) shared.QueueStatusPayload {
+	status := shared.QueueStatusPayload{
+		Mode:   entry.Mode,
+		Rating: entry.Rating,
+		Window: ratingWindow(matchmaking, now.Sub(entry.QueuedAt)),
+	}
+	for _, e := range queue {
+		if e.Mode != entry.Mode {
+			continue
+		}
+		status.Queued++
+		if e.PlayerID == entry.PlayerID {
+			status.Position = status.Queued
+		}
+	}
+	return status
+}
+
+// sendQueueStatus tells the queued players connected here where they stand.
+func sendQueueStatus() {
+	ctx, cancel := storeContext(context.Background())
+	defer cancel()
+	queue, err := queueStore.Queue(ctx)
+	if err != nil {
+		slog.Error("Failed to read the queue", "error", err)
+		return
+	}
+
+	queueMu.Lock()
+	now := time.Now()
+	statuses := make(map[string]shared.QueueStatusPayload)
+	for _, e := range queue {
+		if e.Origin == serverID {
+			statuses[e.PlayerID] = queueStatus(queue, e, now)
+		}
+	}
+	queueMu.Unlock()
+
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+	for client, p := range shared.Players {
+		if status, ok := statuses[p.ID.Hex()]; ok {
+			client.Send(shared.NewMessage(shared.TypeQueueStatus, status))
+		}
+	}
+}
+
+// dropGoneServers takes out of the queue the players of game servers that
+// have gone quiet, as their rosters are, and returns the players left. A
+// server that has only just started may not have heard from the others
+// yet, so until rosterTTL has passed it leaves their players be.
+func dropGoneServers(ctx context.Context, queue []models.QueueEntry) []models.QueueEntry {
+	if time.Since(startedAt) < rosterTTL {
+		return queue
+	}
+
+	rostersMu.Lock()
+	gone := func(e models.QueueEntry) bool {
+		r, ok := rosters[e.Origin]
+		return e.Origin != serverID && (!ok || time.Since(r.updatedAt) > rosterTTL)
+	}
+	var dropped []string
+	for _, e := range queue {
+		if gone(e) {
+			dropped = append(dropped, e.PlayerID)
+		}
+	}
+	rostersMu.Unlock()
+
+	for _, id := range dropped {
+		if _, err := queueStore.Dequeue(ctx, id); err != nil {
+			slog.Error("Failed to drop player of a gone server from the queue", logging.KeyPlayerID, id, "error", err)
+		}
+	}
+	return slices.DeleteFunc(queue, func(e models.QueueEntry) bool { return slices.Contains(dropped, e.PlayerID) })
+}
+
+// matchQueue opens every room that can be formed from the queue. Every
+// server tries, but only the one that takes the players of a room out of
+// the queue opens it; the others leave them be.
+func matchQueue() {
+	ctx, cancel := storeContext(context.Background())
+	defer cancel()
+
+	queue, err := queueStore.Queue(ctx)
+	if err != nil {
+		slog.Error("Failed to read the queue", "error", err)
+		return
+	}
+	queue = dropGoneServers(ctx, queue)
+
+	queueMu.Lock()
+	cfg := matchmaking
+	queueMu.Unlock()
+
+	for {
+		picked := formRoom(queue, cfg, time.Now())
+		if picked == nil {
+			return
+		}
+		entries := make([]models.QueueEntry, 0, len(picked))
+		ids := make([]string, 0, len(picked))
+		for _, i := range picked {
+			entries = append(entries, queue[i])
+			ids = append(ids, queue[i].PlayerID)
+		}
+
+		taken, err := queueStore.Take(ctx, ids)
+		switch {
+		case err != nil:
+			slog.Error("Failed to take matched players out of the queue", "error", err)
+			return
+		case !taken:
+			// Another server matched some of them first; what is left is
+			// looked at again on the next tick.
+			return
+		}
+		openRoom(ctx, entries)
+		queue = slices.DeleteFunc(queue, func(e models.QueueEntry) bool { return slices.Contains(ids, e.PlayerID) })
+	}
+}
+
+// openRoom opens a private room for the players taken out of the queue, in
+// the mode they queued for and hosted by the longest waiting one. Each
+// server then tells its players who they are playing with and starts the
+// room's game for them.
+func openRoom(ctx context.Context, entries []models.QueueEntry) {
+	opened := room{
+		Name:      entries[0].Mode + " match",
+		Mode:      entries[0].Mode,
+		Private:   true,
+		Capacity:  len(entries),
+		HostID:    entries[0].PlayerID,
+		CreatedAt: time.Now().UTC(),
+	}
+	matched := make([]string, 0, len(entries))
+	for _, e := range entries {
+		leaveRoom(ctx, e.PlayerID)
+		opened.Members = append(opened.Members, roomMember{ID: e.PlayerID, Name: e.Name})
+		matched = append(matched, e.PlayerID)
+	}
+
+	created, msgErr := updateRoom(ctx, primitive.NewObjectID().Hex(), func(r *room) error {
+		*r = opened.clone()
+		return nil
+	})
+	if msgErr != nil {
+		slog.Error("Failed to open room for matched players", "players", len(entries), "error", msgErr.Message)
+		return
+	}
+
+	found := shared.MatchFoundPayload{Room: created.ID, Mode: created.Mode}
+	for _, e := range entries {
+		found.Players = append(found.Players, shared.RatedPlayer{Name: e.Name, Rating: e.Rating})
+	}
+	slog.Info("Room formed", "room", found.Room, "mode", found.Mode, "players", len(entries))
+	publishRoom(created)
+	publishQueue(queueUpdate{Players: matched, Match: &found})
+}
+
+func publishQueue(update queueUpdate) {
+	event := gameEvent{Kind: eventQueue, Origin: serverID, SentAt: time.Now(), Queue: &update}
+	if err := publish(event); err != nil {
+		slog.Warn("Failed to publish match, telling local players only", "error", err)
+		go handleQueue(event)
+	}
+}
+
+// handleQueue tells the players connected here whom a server put in a room
+// who they are playing with and starts the room's game for them.
+func handleQueue(event gameEvent) {
+	update := event.Queue
+	if update.Match == nil {
+		return
+	}
+
+	shared.Mu.Lock()
+	matched := make(map[*shared.Client]string)
+	for client, p := range shared.Players {
+		if slices.Contains(update.Players, p.ID.Hex()) {
+			matched[client] = p.ID.Hex()
+		}
+	}
+	shared.Mu.Unlock()
+
+	for client, id := range matched {
+		client.Send(shared.NewMessage(shared.TypeMatchFound, *update.Match))
+
+		ctx := logging.WithPlayer(context.Background(), id)
+		started, gameErr := startGame(ctx, id, update.Match.Mode)
+		if gameErr != nil {
+			client.Send(shared.NewError("", gameErr.payload()))
+			continue
+		}
+		client.Send(shared.NewMessage(shared.TypeStartGame, started))
+	}
+}
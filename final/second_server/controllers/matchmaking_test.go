@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"second_server/config"
+	"second_server/models"
+	"second_server/shared"
+	"second_server/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMatchmaking = config.MatchmakingConfig{
+	RoomSize:        2,
+	RatingWindow:    1,
+	WindowGrowth:    2,
+	WidenSeconds:    10,
+	MaxRatingWindow: 5,
+}
+
+func TestRatingWindowWidensWhilePlayersWait(t *testing.T) {
+	assert.Equal(t, 1, ratingWindow(testMatchmaking, 0))
+	assert.Equal(t, 1, ratingWindow(testMatchmaking, 9*time.Second))
+	assert.Equal(t, 3, ratingWindow(testMatchmaking, 10*time.Second))
+	assert.Equal(t, 5, ratingWindow(testMatchmaking, time.Hour), "the window stops at the maximum")
+}
+
+func TestFormRoomMatchesSimilarRatings(t *testing.T) {
+	now := time.Now()
+	entry := func(name string, rating int, mode string, waited time.Duration) models.QueueEntry {
+		return models.QueueEntry{PlayerID: name, Name: name, Rating: rating, Mode: mode, QueuedAt: now.Add(-waited)}
+	}
+
+	kal := entry("kal", 0, shared.ModeClassic, 0)
+	sara := entry("sara", 4, shared.ModeClassic, 0)
+	assert.Nil(t, formRoom([]models.QueueEntry{kal, sara}, testMatchmaking, now), "ratings too far apart")
+
+	kal.QueuedAt = now.Add(-20 * time.Second)
+	assert.Equal(t, []int{0, 1}, formRoom([]models.QueueEntry{kal, sara}, testMatchmaking, now), "the window widened")
+
+	abebe := entry("abebe", 1, shared.ModeRace, time.Minute)
+	lena := entry("lena", 2, shared.ModeClassic, 0)
+	assert.Equal(t, []int{1, 3}, formRoom([]models.QueueEntry{abebe, kal, sara, lena}, testMatchmaking, now),
+		"modes are not mixed and the closest rating is picked")
+}
+
+func TestMatchedPlayersGetOneRoom(t *testing.T) {
+	Configure(store.NewMemory())
+	ConfigureMatchmaking(testMatchmaking)
+	t.Cleanup(func() { ConfigureMatchmaking(config.Default().Matchmaking) })
+	roomsMu.Lock()
+	saved := rooms
+	rooms = make(map[string]*room)
+	roomsMu.Unlock()
+	t.Cleanup(func() {
+		roomsMu.Lock()
+		rooms = saved
+		roomsMu.Unlock()
+	})
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, name := range []string{"kal", "sara"} {
+		// Sara is queued on another server, which every server sees.
+		origin := serverID
+		if name == "sara" {
+			origin = "elsewhere"
+		}
+		_, err := queueStore.Enqueue(ctx, models.QueueEntry{PlayerID: name, Name: name, Mode: shared.ModeClassic, QueuedAt: now, Origin: origin})
+		require.NoError(t, err)
+	}
+
+	matchQueue()
+	queue, err := queueStore.Queue(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, queue, "the matched players left the queue")
+	roomsMu.Lock()
+	require.Len(t, rooms, 1)
+	for _, r := range rooms {
+		assert.Equal(t, []roomMember{{ID: "kal", Name: "kal"}, {ID: "sara", Name: "sara"}}, r.Members)
+	}
+	roomsMu.Unlock()
+}
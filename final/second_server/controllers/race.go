@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"second_server/logging"
+	"second_server/metrics"
+	"second_server/models"
+	"second_server/shared"
+)
+
+// raceRound is what a game server tells the others when a race round starts
+// or the race ends: the match it belongs to, everyone's word for the round,
+// and the rounds won so far. Word is empty once the race is over.
+type raceRound struct {
+	MatchStartedAt time.Time            `json:"match_started_at"`
+	MatchID        string               `json:"match_id"`
+	Number         int                  `json:"number"`
+	Word           string               `json:"word,omitempty"`
+	Scrambled      string               `json:"scrambled,omitempty"`
+	StartedAt      time.Time            `json:"started_at"`
+	Rounds         []models.RoundResult `json:"rounds,omitempty"`
+	// Result is the round that was just won; the first round has none.
+	Result *shared.RoundResultPayload `json:"result,omitempty"`
+}
+
+// startRound picks the word everyone in game races to solve next, never
+// the word they just solved. Callers must hold mu.
+func startRound(game *models.GameState, number int, at time.Time) {
+	word := generateWord()
+	for word == game.Word {
+		word = generateWord()
+	}
+	game.Word = word
+	game.Shuffled = shuffleString(word)
+	game.Round = number
+	game.RoundStartedAt = at
+}
+
+// currentRace describes the race in game. Callers must hold mu.
+func currentRace(game *models.GameState) raceRound {
+	return raceRound{
+		MatchStartedAt: game.StartedAt,
+		MatchID:        game.MatchID,
+		Number:         game.Round,
+		Word:           game.Word,
+		Scrambled:      game.Shuffled,
+		StartedAt:      game.RoundStartedAt,
+		Rounds:         append([]models.RoundResult(nil), game.Rounds...),
+	}
+}
+
+// publishRound tells every server about a round of the race in the game of
+// room.
+func publishRound(room string, round raceRound) {
+	event := gameEvent{Kind: eventRound, Origin: serverID, SentAt: time.Now(), Game: room, Round: &round}
+	if err := publish(event); err != nil {
+		slog.Warn("Failed to publish race round, updating local players only", "error", err)
+		// The caller may hold shared.Mu, which handleRound takes.
+		go handleRound(event)
+	}
+}
+
+// handleRound brings this server up to date with a race round started on
+// another server, gives the players of the race here the new word and tells
+// them how the last round went.
+func handleRound(event gameEvent) {
+	round := *event.Round
+
+	if event.Origin != serverID {
+		mu.Lock()
+		game := eventGame(event.Game)
+		switch {
+		case game == nil:
+		case round.Word == "":
+			if game.StartedAt.Equal(round.MatchStartedAt) {
+				endMatch(game)
+			}
+		case !game.StartedAt.Equal(round.MatchStartedAt) || round.Number > game.Round:
+			game.Started = true
+			game.StartedAt = round.MatchStartedAt
+			game.MatchID = round.MatchID
+			game.Solved = nil
+			game.Mode = shared.ModeRace
+			game.Word = round.Word
+			game.Shuffled = round.Scrambled
+			game.Round = round.Number
+			game.RoundStartedAt = round.StartedAt
+			game.Rounds = round.Rounds
+		}
+		mu.Unlock()
+	}
+
+	if round.Word != "" {
+		shared.Mu.Lock()
+		for client, p := range shared.Players {
+			if p.Room == event.Game {
+				p.Scrambled = round.Scrambled
+				shared.Players[client] = p
+			}
+		}
+		shared.Mu.Unlock()
+		broadcastPlayerList()
+	}
+	if round.Result != nil {
+		deliverLocally(event.Game, shared.NewMessage(shared.TypeRoundResult, *round.Result))
+	}
+}
+
+// submitRaceGuess checks a guess against the word of the current round of
+// the race in game. The first player to solve it wins the round and a
+// point, and everyone in the race gets the next word at once. A player's
+// points are the rounds of the race they won, whatever score they had
+// before it. Callers must hold shared.Mu.
+func submitRaceGuess(ctx context.Context, game *models.GameState, player models.Player, guess string) (*guessOutcome, *gameError) {
+	logger := logging.FromContext(ctx)
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventGuess, PlayerID: player.ID, Player: player.Name, Guess: guess, Score: player.Score})
+
+	mu.Lock()
+	word, number := game.Word, game.Round
+	matchStart, roundStart := game.StartedAt, game.RoundStartedAt
+	mu.Unlock()
+
+	if !strings.EqualFold(guess, word) {
+		logger.Debug("Incorrect guess")
+		metrics.RecordGuess(false)
+		outcome := &guessOutcome{Player: player}
+		outcome.Scores, outcome.Teams = getScores(game)
+		return outcome, nil
+	}
+	if wonUnrecorded(game, player) {
+		// The round that won the race was claimed already; only storing the
+		// win is left.
+		if gameErr := finishMatch(ctx, game, player); gameErr != nil {
+			return nil, gameErr
+		}
+		outcome := &guessOutcome{Player: player, Correct: true, Won: true}
+		outcome.Scores, outcome.Teams = getScores(game)
+		return outcome, nil
+	}
+
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	claimed, err := gameStore.ClaimRound(storeCtx, matchStart, number, player.ID)
+	if err != nil {
+		logger.Error("Failed to claim race round", "error", err)
+		return nil, internalError("Failed to claim round")
+	}
+	if !claimed {
+		logger.Info("Race round was won by another player")
+		return nil, errWordChanged
+	}
+
+	solvedAt := time.Now().UTC()
+	solveMS := solvedAt.Sub(roundStart).Milliseconds()
+	result := models.RoundResult{
+		Round:    number,
+		PlayerID: player.ID,
+		Player:   player.Name,
+		SolveMS:  solveMS,
+		Players:  racers(game.Room, player, solveMS),
+	}
+
+	mu.Lock()
+	game.Rounds = append(game.Rounds, result)
+	player.Score = racePoints(game.Rounds, player.ID)
+	won := player.Score == winningScore
+	game.Solved = append(game.Solved, models.SolvedWord{
+		PlayerID: player.ID,
+		Player:   player.Name,
+		Word:     word,
+		SolvedAt: solvedAt,
+	})
+	if !won {
+		startRound(game, number+1, solvedAt)
+	}
+	next := currentRace(game)
+	if won {
+		next.Word, next.Scrambled = "", ""
+	}
+	if p := getPlayerByID(game, player.ID); p != nil {
+		p.Score = player.Score
+		savePlayer(ctx, game, *p)
+	}
+	saveGameState(ctx, game)
+	mu.Unlock()
+
+	if err := users.SetWordAndScore(storeCtx, player.ID, next.Word, player.Score); err != nil {
+		logger.Error("Failed to update score", "error", err)
+		return nil, internalError("Failed to update score")
+	}
+	logger.Info("Won race round", "round", number, "score", player.Score, "solve_ms", result.SolveMS)
+	metrics.RecordGuess(true)
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventCorrect, PlayerID: player.ID, Player: player.Name, Word: word, Score: player.Score})
+
+	outcome := &guessOutcome{Player: player, Correct: true, NewWord: next.Scrambled}
+	if !won {
+		recordEvent(ctx, game, models.GameEvent{Type: models.EventWordAssigned, PlayerID: player.ID, Player: player.Name, Word: next.Word, Scrambled: next.Scrambled, Score: player.Score})
+	}
+
+	next.Result = &shared.RoundResultPayload{
+		Round:    number,
+		Word:     word,
+		Winner:   player.Name,
+		SolveMS:  result.SolveMS,
+		NextWord: next.Scrambled,
+		Players:  playerTimes(result.Players),
+		Times:    roundTimes(next.Rounds),
+	}
+	publishRound(game.Room, next)
+
+	if won {
+		if gameErr := finishMatch(ctx, game, player); gameErr != nil {
+			return nil, gameErr
+		}
+		outcome.Won = true
+	}
+
+	outcome.Scores, outcome.Teams = getScores(game)
+	return outcome, nil
+}
+
+// racers lists how everyone racing in the game of room did in a round
+// winner solved in solveMS: the winner first, then everyone else as not
+// having solved it. Callers must not hold rostersMu.
+func racers(room string, winner models.Player, solveMS int64) []models.PlayerResult {
+	results := []models.PlayerResult{{PlayerID: winner.ID, Player: winner.Name, Solved: true, SolveMS: solveMS}}
+
+	rostersMu.Lock()
+	defer rostersMu.Unlock()
+	for _, p := range allPlayers() {
+		if p.Room == room && p.ID != winner.ID {
+			results = append(results, models.PlayerResult{PlayerID: p.ID, Player: p.Name})
+		}
+	}
+	return results
+}
+
+// racePoints counts the rounds of a race the player won.
+func racePoints(rounds []models.RoundResult, playerID string) int {
+	points := 0
+	for _, r := range rounds {
+		if r.PlayerID == playerID {
+			points++
+		}
+	}
+	return points
+}
+
+func roundTimes(rounds []models.RoundResult) []shared.RoundTime {
+	times := make([]shared.RoundTime, 0, len(rounds))
+	for _, r := range rounds {
+		times = append(times, shared.RoundTime{Round: r.Round, Player: r.Player, SolveMS: r.SolveMS, Players: playerTimes(r.Players)})
+	}
+	return times
+}
+
+func playerTimes(results []models.PlayerResult) []shared.PlayerTime {
+	times := make([]shared.PlayerTime, 0, len(results))
+	for _, r := range results {
+		times = append(times, shared.PlayerTime{Player: r.Player, Solved: r.Solved, SolveMS: r.SolveMS})
+	}
+	return times
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"second_server/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartRoundNeverRepeatsTheWord(t *testing.T) {
+	mu.Lock()
+	defer mu.Unlock()
+	saved := gameState
+	defer func() { gameState = saved }()
+
+	gameState.Word = "apple"
+	for round := 1; round <= 20; round++ {
+		previous := gameState.Word
+		startRound(&gameState, round, time.Now())
+
+		assert.NotEqual(t, previous, gameState.Word)
+		assert.Equal(t, round, gameState.Round)
+		assert.Equal(t, sortedLetters(gameState.Word), sortedLetters(gameState.Shuffled), "the scrambled word is the round's word")
+	}
+}
+
+func sortedLetters(s string) string {
+	letters := []rune(s)
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	return string(letters)
+}
+
+func TestRacePointsAreTheRoundsWon(t *testing.T) {
+	rounds := []models.RoundResult{
+		{Round: 1, PlayerID: "kal"},
+		{Round: 2, PlayerID: "abebe"},
+		{Round: 3, PlayerID: "kal"},
+	}
+	assert.Equal(t, 2, racePoints(rounds, "kal"))
+	assert.Equal(t, 1, racePoints(rounds, "abebe"))
+	assert.Zero(t, racePoints(rounds, "sara"))
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRatingChangesRewardTheWinner(t *testing.T) {
+	changes := ratingChanges([]standing{{rating: 1000, score: 3}, {rating: 1000, score: 1}}, nil)
+
+	assert.Equal(t, []int{16, -16}, changes)
+}
+
+func TestRatingChangesMoveLessForAnExpectedResult(t *testing.T) {
+	upset := ratingChanges([]standing{{rating: 1000, score: 3}, {rating: 1400, score: 1}}, nil)
+	expected := ratingChanges([]standing{{rating: 1400, score: 3}, {rating: 1000, score: 1}}, nil)
+
+	assert.Equal(t, []int{29, -29}, upset)
+	assert.Equal(t, []int{3, -3}, expected)
+}
+
+func TestRatingChangesAverageOverOpponents(t *testing.T) {
+	changes := ratingChanges([]standing{
+		{rating: 1000, score: 3},
+		{rating: 1000, score: 1},
+		{rating: 1000, score: 1},
+	}, nil)
+
+	assert.Equal(t, []int{16, -8, -8}, changes, "players level with each other draw")
+}
+
+func TestRatingChangesRankTeamsTogether(t *testing.T) {
+	changes := ratingChanges([]standing{
+		{rating: 1000, score: 0, team: "red"},
+		{rating: 1000, score: 4, team: "blue"},
+		{rating: 1000, score: 1, team: "red"},
+	}, map[string]int{"red": 5, "blue": 4})
+
+	assert.Equal(t, []int{16, -16, 16}, changes, "teammates are not rated against each other")
+}
@@ -0,0 +1,642 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"maps"
+	"math/big"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"second_server/config"
+	"second_server/logging"
+	"second_server/models"
+	"second_server/shared"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// joinCodeAlphabet leaves out letters and digits that are easily
+	// mistaken for each other, so codes can be read out loud.
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	joinCodeLength   = 6
+)
+
+var (
+	roomsMu sync.Mutex
+	roomCfg = config.Default().Rooms
+	// rooms holds every open room by ID as this server last heard of it.
+	// The rooms themselves live in roomStore, where every change is made,
+	// and are published after each change so the other servers catch up.
+	// A copy is only ever replaced by a newer revision.
+	rooms = make(map[string]*room)
+)
+
+// room gathers players who want to play together. Every room has a game of
+// its own, played in the room's mode, which only its members and its
+// spectators see; everyone else plays the lobby game.
+type room models.Room
+
+type roomMember = models.RoomMember
+
+var (
+	errNotInRoom   = &shared.ErrorPayload{Code: shared.ErrCodeNotFound, Message: "not in a room"}
+	errNotRoomHost = &shared.ErrorPayload{Code: shared.ErrCodeForbidden, Message: "only the host can do this"}
+	errNoSuchRoom  = &shared.ErrorPayload{Code: shared.ErrCodeNotFound, Message: "no room matches, or its code or invite expired or was revoked"}
+)
+
+// ConfigureRooms sets the capacities of rooms and how long join codes and
+// invites last.
+func ConfigureRooms(cfg config.RoomsConfig) {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	roomCfg = cfg
+}
+
+// LoadRooms fills this server's copy of the open rooms from the room store,
+// so that a server that restarts knows the rooms opened before. It must be
+// called after Configure.
+func LoadRooms() {
+	ctx, cancel := storeContext(context.Background())
+	defer cancel()
+	refreshRooms(ctx)
+}
+
+// refreshRooms brings this server's copy of the open rooms up to date with
+// the room store.
+func refreshRooms(ctx context.Context) {
+	stored, err := roomStore.Rooms(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to load rooms", "error", err)
+		return
+	}
+	for _, r := range stored {
+		cacheRoom(room(r))
+	}
+}
+
+// cacheRoom keeps r as this server's copy of it, closing it once it has no
+// members, and reports whether it did. A copy newer than r is kept instead.
+func cacheRoom(r room) bool {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+
+	if cached := rooms[r.ID]; cached != nil && cached.Revision > r.Revision {
+		return false
+	}
+	if len(r.Members) == 0 {
+		delete(rooms, r.ID)
+	} else {
+		stored := r.clone()
+		rooms[r.ID] = &stored
+	}
+	return true
+}
+
+// updateRoom makes a change to the room with the given ID in the room store,
+// where it cannot be lost to a change made at the same time on another
+// server, and returns the room as changed. update sees the room as stored,
+// a room with no members if there is none, and may run more than once. A
+// rejection by update is returned as is and anything else that goes wrong
+// as an internal error.
+func updateRoom(ctx context.Context, id string, update func(*room) error) (room, *shared.ErrorPayload) {
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	updated, err := roomStore.UpdateRoom(storeCtx, id, func(r *models.Room) error {
+		return update((*room)(r))
+	})
+	var msgErr *shared.ErrorPayload
+	if errors.As(err, &msgErr) {
+		if msgErr == errNoSuchRoom {
+			// Closed while this server still had a copy of it.
+			roomsMu.Lock()
+			delete(rooms, id)
+			roomsMu.Unlock()
+		}
+		return room{}, msgErr
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to update room", "room", id, "error", err)
+		return room{}, &shared.ErrorPayload{Code: shared.ErrCodeInternal, Message: "the room could not be changed, try again"}
+	}
+	cacheRoom(room(*updated))
+	return room(*updated), nil
+}
+
+// randomCode returns a join code no open room is using. Callers must not
+// hold roomsMu.
+func randomCode(now time.Time) string {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	for {
+		code := make([]byte, joinCodeLength)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(joinCodeAlphabet))))
+			if err != nil {
+				panic(err)
+			}
+			code[i] = joinCodeAlphabet[n.Int64()]
+		}
+		if findRoom(func(r *room) bool { return r.codeValid(string(code), now) }) == nil {
+			return string(code)
+		}
+	}
+}
+
+func randomToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
+
+func (r *room) codeValid(code string, now time.Time) bool {
+	return r.Code != "" && r.Code == code && now.Before(r.CodeExpiresAt)
+}
+
+func (r *room) inviteValid(token string, now time.Time) bool {
+	expiresAt, ok := r.Invites[token]
+	return ok && now.Before(expiresAt)
+}
+
+func (r *room) member(playerID string) int {
+	for i, m := range r.Members {
+		if m.ID == playerID {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeMember takes the player out of r, handing r to the next member if
+// they hosted it, and reports whether they were in it.
+func (r *room) removeMember(playerID string) bool {
+	i := r.member(playerID)
+	if i < 0 {
+		return false
+	}
+	r.Members = slices.Delete(r.Members, i, i+1)
+	if len(r.Members) > 0 && r.HostID == playerID {
+		r.HostID = r.Members[0].ID
+	}
+	return true
+}
+
+// view describes the room to one of its members.
+func (r *room) view(playerID string) shared.RoomPayload {
+	view := shared.RoomPayload{
+		Room:     r.ID,
+		Name:     r.Name,
+		Mode:     r.Mode,
+		Private:  r.Private,
+		Capacity: r.Capacity,
+		Players:  make([]string, 0, len(r.Members)),
+	}
+	for _, m := range r.Members {
+		view.Players = append(view.Players, m.Name)
+		if m.ID == r.HostID {
+			view.Host = m.Name
+		}
+	}
+	if playerID == r.HostID && r.Code != "" {
+		expiresAt := r.CodeExpiresAt
+		view.Code, view.CodeExpiresAt = r.Code, &expiresAt
+	}
+	return view
+}
+
+func (r *room) clone() room {
+	c := *r
+	c.Members = append([]roomMember(nil), r.Members...)
+	c.Invites = maps.Clone(r.Invites)
+	return c
+}
+
+// findRoom returns the first open room that matches. Callers must hold
+// roomsMu.
+func findRoom(match func(*room) bool) *room {
+	for _, r := range rooms {
+		if match(r) {
+			return r
+		}
+	}
+	return nil
+}
+
+// lookupRoom returns the ID of the room named the way a join request names
+// it: a public room by its ID, or any room by a join code or invite that is
+// still valid. A private room named by its ID is not found unless the
+// player is in it, so its ID alone does not tell anyone it exists. A room
+// this server has not heard of yet is looked for in the room store.
+func lookupRoom(ctx context.Context, playerID, id, code, invite string) string {
+	now := time.Now()
+	match := func(r *room) bool {
+		switch {
+		case id != "":
+			return r.ID == id && (!r.Private || r.member(playerID) >= 0)
+		case code != "":
+			return r.codeValid(code, now)
+		default:
+			return r.inviteValid(invite, now)
+		}
+	}
+
+	found := func() string {
+		roomsMu.Lock()
+		defer roomsMu.Unlock()
+		if r := findRoom(match); r != nil {
+			return r.ID
+		}
+		return ""
+	}
+	if id := found(); id != "" {
+		return id
+	}
+	refreshRooms(ctx)
+	return found()
+}
+
+// roomOpen reports whether the room is open.
+func roomOpen(id string) bool {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	return rooms[id] != nil
+}
+
+// roomMode returns the mode the room plays, or "" if it is not open.
+func roomMode(id string) string {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	if r := rooms[id]; r != nil {
+		return r.Mode
+	}
+	return ""
+}
+
+// playerRoom returns the room whose game the player plays, or "" for the
+// lobby game. Rooms are shared by the game servers, so this holds wherever
+// the player is connected.
+func playerRoom(id string) string {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	if r := roomOf(id); r != nil {
+		return r.ID
+	}
+	return ""
+}
+
+// roomOf returns the room the player is in. Callers must hold roomsMu.
+func roomOf(playerID string) *room {
+	return findRoom(func(r *room) bool { return r.member(playerID) >= 0 })
+}
+
+// createRoom opens a room hosted by the player, taking them out of any room
+// they were in.
+func createRoom(ctx context.Context, player shared.Player, req *shared.CreateRoomPayload) (shared.RoomPayload, *shared.ErrorPayload) {
+	roomsMu.Lock()
+	cfg := roomCfg
+	roomsMu.Unlock()
+
+	capacity := req.Capacity
+	if capacity == 0 {
+		capacity = cfg.DefaultCapacity
+	}
+	if capacity < 2 || capacity > cfg.MaxCapacity {
+		return shared.RoomPayload{}, &shared.ErrorPayload{Code: shared.ErrCodeInvalidPayload, Message: "capacity must be between 2 and " + strconv.Itoa(cfg.MaxCapacity)}
+	}
+
+	leaveRoom(ctx, player.ID.Hex())
+	now := time.Now().UTC()
+	opened := room{
+		Name:      req.Name,
+		Mode:      req.Mode,
+		Private:   req.Private,
+		Capacity:  capacity,
+		HostID:    player.ID.Hex(),
+		Members:   []roomMember{{ID: player.ID.Hex(), Name: player.Name}},
+		CreatedAt: now,
+	}
+	if opened.Name == "" {
+		opened.Name = player.Name + "'s room"
+	}
+	if opened.Mode == "" {
+		opened.Mode = shared.ModeClassic
+	}
+	if opened.Private {
+		opened.Code = randomCode(now)
+		opened.CodeExpiresAt = now.Add(time.Duration(cfg.CodeTTLSeconds) * time.Second)
+	}
+
+	created, msgErr := updateRoom(ctx, primitive.NewObjectID().Hex(), func(r *room) error {
+		*r = opened.clone()
+		return nil
+	})
+	if msgErr != nil {
+		return shared.RoomPayload{}, msgErr
+	}
+
+	logging.FromContext(ctx).Info("Room created", "room", created.ID, "private", created.Private, "capacity", capacity)
+	publishRoom(created)
+	return created.view(player.ID.Hex()), nil
+}
+
+// listRooms lists the public rooms that have space, oldest first. Private
+// rooms are never listed.
+func listRooms() shared.RoomListPayload {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+
+	list := shared.RoomListPayload{Rooms: []shared.RoomSummary{}}
+	var open []*room
+	for _, r := range rooms {
+		if !r.Private && len(r.Members) < r.Capacity {
+			open = append(open, r)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].CreatedAt.Before(open[j].CreatedAt) })
+	for _, r := range open {
+		view := r.view("")
+		list.Rooms = append(list.Rooms, shared.RoomSummary{
+			Room:     r.ID,
+			Name:     r.Name,
+			Host:     view.Host,
+			Mode:     r.Mode,
+			Players:  len(r.Members),
+			Capacity: r.Capacity,
+		})
+	}
+	return list
+}
+
+// joinRoom puts the player in the room named by the request, as lookupRoom
+// finds it. The code, invite and capacity of the room are checked as the
+// player is added to it in the room store, so that of the players taking
+// the last place at once on different servers only one gets it. A player
+// kicked from the room is kept out until the kick expires. The player
+// leaves any room they were in.
+func joinRoom(ctx context.Context, player shared.Player, req *shared.JoinRoomPayload) (shared.RoomPayload, *shared.ErrorPayload) {
+	playerID := player.ID.Hex()
+	id := lookupRoom(ctx, playerID, req.Room, req.Code, req.Invite)
+	if id == "" {
+		return shared.RoomPayload{}, errNoSuchRoom
+	}
+	previous := playerRoom(playerID)
+
+	now := time.Now()
+	member := false
+	joined, msgErr := updateRoom(ctx, id, func(r *room) error {
+		member = r.member(playerID) >= 0
+		switch {
+		case len(r.Members) == 0,
+			req.Room != "" && r.Private && !member,
+			req.Code != "" && !r.codeValid(req.Code, now),
+			req.Invite != "" && !r.inviteValid(req.Invite, now):
+			return errNoSuchRoom
+		case member:
+			return nil
+		case isKicked(r.ID, playerID):
+			return &shared.ErrorPayload{Code: shared.ErrCodeForbidden, Message: "you were kicked from the room"}
+		case len(r.Members) >= r.Capacity:
+			return &shared.ErrorPayload{Code: shared.ErrCodeRoomFull, Message: "the room is full"}
+		}
+		r.Members = append(r.Members, roomMember{ID: playerID, Name: player.Name})
+		return nil
+	})
+	if msgErr != nil {
+		return shared.RoomPayload{}, msgErr
+	}
+	if member {
+		return joined.view(playerID), nil
+	}
+
+	logging.FromContext(ctx).Info("Player joined room", "room", joined.ID, "players", len(joined.Members))
+	if previous != "" && previous != id {
+		removeFromRoom(ctx, previous, playerID)
+	}
+	publishRoom(joined)
+	return joined.view(playerID), nil
+}
+
+// createInvite gives the host of a room a new invite to it. Invites can be
+// used by anyone who has them until they expire or the host revokes them.
+func createInvite(ctx context.Context, playerID string) (shared.InviteCreatedPayload, *shared.ErrorPayload) {
+	roomsMu.Lock()
+	cfg := roomCfg
+	roomsMu.Unlock()
+	id := playerRoom(playerID)
+	if id == "" {
+		return shared.InviteCreatedPayload{}, errNotInRoom
+	}
+
+	now := time.Now().UTC()
+	invite := shared.InviteCreatedPayload{
+		Room:      id,
+		Token:     randomToken(),
+		ExpiresAt: now.Add(time.Duration(cfg.InviteTTLSeconds) * time.Second),
+	}
+	if cfg.InviteURL != "" {
+		invite.Link = cfg.InviteURL + invite.Token
+	}
+	updated, msgErr := updateRoom(ctx, id, func(r *room) error {
+		if r.member(playerID) < 0 {
+			return errNotInRoom
+		}
+		if r.HostID != playerID {
+			return errNotRoomHost
+		}
+		for token, expiresAt := range r.Invites {
+			if !now.Before(expiresAt) {
+				delete(r.Invites, token)
+			}
+		}
+		if r.Invites == nil {
+			r.Invites = make(map[string]time.Time)
+		}
+		r.Invites[invite.Token] = invite.ExpiresAt
+		return nil
+	})
+	if msgErr != nil {
+		return shared.InviteCreatedPayload{}, msgErr
+	}
+
+	logging.FromContext(ctx).Info("Room invite created", "room", updated.ID)
+	publishRoom(updated)
+	return invite, nil
+}
+
+// revokeCode stops the join code and every invite of the host's room from
+// letting anyone else in. Members already in the room stay. With renew the
+// room gets a new code.
+func revokeCode(ctx context.Context, playerID string, renew bool) (shared.RoomPayload, *shared.ErrorPayload) {
+	roomsMu.Lock()
+	cfg := roomCfg
+	roomsMu.Unlock()
+	id := playerRoom(playerID)
+	if id == "" {
+		return shared.RoomPayload{}, errNotInRoom
+	}
+
+	now := time.Now().UTC()
+	var code string
+	if renew {
+		code = randomCode(now)
+	}
+	updated, msgErr := updateRoom(ctx, id, func(r *room) error {
+		if r.member(playerID) < 0 {
+			return errNotInRoom
+		}
+		if r.HostID != playerID {
+			return errNotRoomHost
+		}
+		r.Code, r.CodeExpiresAt, r.Invites = "", time.Time{}, nil
+		if renew {
+			r.Code = code
+			r.CodeExpiresAt = now.Add(time.Duration(cfg.CodeTTLSeconds) * time.Second)
+		}
+		return nil
+	})
+	if msgErr != nil {
+		return shared.RoomPayload{}, msgErr
+	}
+
+	logging.FromContext(ctx).Info("Room code revoked", "room", updated.ID, "renewed", renew)
+	publishRoom(updated)
+	return updated.view(playerID), nil
+}
+
+// leaveRoom takes the player out of their room and reports whether they
+// were in one.
+func leaveRoom(ctx context.Context, playerID string) bool {
+	id := playerRoom(playerID)
+	return id != "" && removeFromRoom(ctx, id, playerID)
+}
+
+// removeFromRoom takes the player out of the room with the given ID, which
+// is closed if they were its last member, and reports whether they were in
+// it.
+func removeFromRoom(ctx context.Context, id, playerID string) bool {
+	left, msgErr := updateRoom(ctx, id, func(r *room) error {
+		if !r.removeMember(playerID) {
+			return errNotInRoom
+		}
+		return nil
+	})
+	if msgErr != nil {
+		if msgErr != errNotInRoom {
+			logging.FromContext(ctx).Error("Failed to leave room", "room", id, "error", msgErr.Message)
+		}
+		return false
+	}
+	logging.FromContext(ctx).Info("Player left room", "room", left.ID, "players", len(left.Members))
+	publishRoom(left)
+	return true
+}
+
+// publishRoom tells every server about a room as it now is. The players
+// connected here change games at once rather than when the event comes
+// back.
+func publishRoom(r room) {
+	if placeMembers(&r) {
+		go broadcastPlayerList()
+	}
+	event := gameEvent{Kind: eventRoom, Origin: serverID, SentAt: time.Now(), Room: &r}
+	if err := publish(event); err != nil {
+		slog.Warn("Failed to publish room, updating local players only", "error", err)
+		go handleRoom(event)
+	}
+}
+
+// handleRoom brings this server up to date with a room changed on another
+// server, closing it once it has no members, and shows the room to its
+// members connected here. A change older than the copy of the room here
+// came late and is ignored.
+func handleRoom(event gameEvent) {
+	r := event.Room
+
+	if event.Origin != serverID {
+		if !cacheRoom(*r) {
+			return
+		}
+		if placeMembers(r) {
+			broadcastPlayerList()
+		}
+	}
+
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+	for client, p := range shared.Players {
+		if id := p.ID.Hex(); r.member(id) >= 0 {
+			client.Send(shared.NewMessage(shared.TypeRoom, r.view(id)))
+		}
+	}
+}
+
+// placeMembers moves the players connected here who joined r into its
+// game, and those who left it back into the lobby game, and reports whether
+// anyone moved. The game and chat of a room that closed go with it, and
+// its spectators go back to watching the lobby game.
+func placeMembers(r *room) bool {
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	ctx := context.Background()
+	moved := false
+	for client, p := range shared.Players {
+		id := p.ID.Hex()
+		to := p.Room
+		switch {
+		case r.member(id) >= 0:
+			to = r.ID
+		case p.Room == r.ID:
+			to = ""
+		}
+		if to == p.Room {
+			continue
+		}
+
+		from := gameOf(p.Room)
+		player, ok := dropPlayer(from, id)
+		if !ok {
+			player = models.Player{ID: id, Name: p.Name, Score: p.Score, Team: p.Team}
+		}
+		removePlayer(ctx, from, player)
+		saveGameState(ctx, from)
+		addPlayer(ctx, gameOf(to), player)
+		p.Room = to
+		shared.Players[client] = p
+		moved = true
+	}
+
+	if len(r.Members) == 0 {
+		delete(roomGames, r.ID)
+		chatMu.Lock()
+		delete(chatHistory, r.ID)
+		chatMu.Unlock()
+		for client, watched := range shared.Spectators {
+			if watched == r.ID {
+				shared.Spectators[client] = ""
+				moved = true
+			}
+		}
+	} else if game := roomGames[r.ID]; game != nil {
+		// Members who left while connected elsewhere, or who hung up, are
+		// no longer in the game.
+		for _, p := range append([]models.Player(nil), game.Players...) {
+			if r.member(p.ID) < 0 {
+				dropPlayer(game, p.ID)
+			}
+		}
+	}
+	return moved
+}
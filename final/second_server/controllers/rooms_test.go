@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"second_server/models"
+	"second_server/shared"
+	"second_server/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRandomCodeIsShortAndUnambiguous(t *testing.T) {
+	code := randomCode(time.Now())
+
+	assert.Len(t, code, joinCodeLength)
+	for _, c := range code {
+		assert.True(t, strings.ContainsRune(joinCodeAlphabet, c), "unexpected %q in %s", c, code)
+	}
+}
+
+func TestRoomCodesAndInvitesExpire(t *testing.T) {
+	now := time.Now()
+	r := &room{
+		Code:          "ABC234",
+		CodeExpiresAt: now.Add(time.Minute),
+		Invites:       map[string]time.Time{"token": now.Add(time.Hour)},
+	}
+
+	assert.True(t, r.codeValid("ABC234", now))
+	assert.False(t, r.codeValid("ABC234", now.Add(time.Minute)), "the code expired")
+	assert.False(t, r.codeValid("", now))
+	assert.True(t, r.inviteValid("token", now))
+	assert.False(t, r.inviteValid("token", now.Add(2*time.Hour)), "the invite expired")
+	assert.False(t, r.inviteValid("other", now))
+}
+
+func TestRoomViewShowsTheCodeToTheHostOnly(t *testing.T) {
+	r := &room{
+		ID:       "room",
+		Private:  true,
+		Capacity: 4,
+		HostID:   "1",
+		Members:  []roomMember{{ID: "1", Name: "kal"}, {ID: "2", Name: "sara"}},
+		Code:     "ABC234",
+	}
+
+	host := r.view("1")
+	assert.Equal(t, "kal", host.Host)
+	assert.Equal(t, []string{"kal", "sara"}, host.Players)
+	assert.Equal(t, "ABC234", host.Code)
+	require.NotNil(t, host.CodeExpiresAt)
+
+	member := r.view("2")
+	assert.Empty(t, member.Code)
+	assert.Nil(t, member.CodeExpiresAt)
+}
+
+// useRoomStore gives the test an empty room store and copy of the rooms.
+func useRoomStore(t *testing.T) store.RoomStore {
+	stores := store.NewMemory()
+	Configure(stores)
+	roomsMu.Lock()
+	saved := rooms
+	rooms = make(map[string]*room)
+	roomsMu.Unlock()
+	t.Cleanup(func() {
+		roomsMu.Lock()
+		rooms = saved
+		roomsMu.Unlock()
+	})
+	return stores.Rooms
+}
+
+func storeRoom(t *testing.T, rooms store.RoomStore, r models.Room) {
+	_, err := rooms.UpdateRoom(context.Background(), r.ID, func(stored *models.Room) error {
+		*stored = r
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestLeavingHandsTheRoomOnAndClosesItWhenEmpty(t *testing.T) {
+	roomStore := useRoomStore(t)
+	storeRoom(t, roomStore, models.Room{ID: "public", Capacity: 2, HostID: "1", Members: []roomMember{{ID: "1", Name: "kal"}, {ID: "2", Name: "sara"}}})
+	storeRoom(t, roomStore, models.Room{ID: "private", Private: true, Capacity: 4, HostID: "3", Members: []roomMember{{ID: "3", Name: "lena"}}})
+	LoadRooms()
+	ctx := context.Background()
+
+	assert.True(t, leaveRoom(ctx, "1"))
+	roomsMu.Lock()
+	require.Contains(t, rooms, "public")
+	assert.Equal(t, "2", rooms["public"].HostID, "the next member hosts the room")
+	roomsMu.Unlock()
+
+	assert.True(t, leaveRoom(ctx, "3"))
+	assert.False(t, roomOpen("private"), "an empty room is closed")
+	stored, err := roomStore.Rooms(ctx)
+	require.NoError(t, err)
+	assert.Len(t, stored, 1, "and no longer stored")
+
+	assert.False(t, leaveRoom(ctx, "4"))
+}
+
+func TestJoiningChecksTheRoomAsStored(t *testing.T) {
+	roomStore := useRoomStore(t)
+	storeRoom(t, roomStore, models.Room{ID: "room", Capacity: 2, HostID: "1", Members: []roomMember{{ID: "1", Name: "kal"}}})
+	LoadRooms()
+
+	// Another server lets sara in; this one has not heard of it yet.
+	_, err := roomStore.UpdateRoom(context.Background(), "room", func(r *models.Room) error {
+		r.Members = append(r.Members, roomMember{ID: "2", Name: "sara"})
+		return nil
+	})
+	require.NoError(t, err)
+
+	abebe := shared.Player{ID: primitive.NewObjectID(), Name: "abebe"}
+	_, msgErr := joinRoom(context.Background(), abebe, &shared.JoinRoomPayload{Room: "room"})
+	require.NotNil(t, msgErr)
+	assert.Equal(t, shared.ErrCodeRoomFull, msgErr.Code)
+
+	roomsMu.Lock()
+	assert.Len(t, rooms["room"].Members, 1, "a rejected join changes nothing here")
+	roomsMu.Unlock()
+}
+
+func TestRoomEventsNeverReplaceANewerCopy(t *testing.T) {
+	useRoomStore(t)
+	newer := &room{ID: "room", Capacity: 4, HostID: "1", Members: []roomMember{{ID: "1"}, {ID: "2"}}, Revision: 3}
+	older := &room{ID: "room", Capacity: 4, HostID: "1", Members: []roomMember{{ID: "1"}}, Revision: 2}
+
+	handleRoom(gameEvent{Kind: eventRoom, Origin: "server-b", Room: newer})
+	handleRoom(gameEvent{Kind: eventRoom, Origin: "server-c", Room: older})
+
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	require.Contains(t, rooms, "room")
+	assert.Len(t, rooms["room"].Members, 2)
+}
+
+func TestListRoomsLeavesOutPrivateAndFullRooms(t *testing.T) {
+	roomsMu.Lock()
+	saved := rooms
+	now := time.Now()
+	rooms = map[string]*room{
+		"newer":   {ID: "newer", Capacity: 4, HostID: "1", Members: []roomMember{{ID: "1", Name: "kal"}}, CreatedAt: now},
+		"older":   {ID: "older", Capacity: 4, HostID: "2", Members: []roomMember{{ID: "2", Name: "sara"}}, CreatedAt: now.Add(-time.Minute)},
+		"full":    {ID: "full", Capacity: 2, HostID: "3", Members: []roomMember{{ID: "3"}, {ID: "4"}}, CreatedAt: now},
+		"private": {ID: "private", Private: true, Capacity: 4, HostID: "5", Members: []roomMember{{ID: "5"}}, CreatedAt: now},
+	}
+	roomsMu.Unlock()
+	defer func() {
+		roomsMu.Lock()
+		rooms = saved
+		roomsMu.Unlock()
+	}()
+
+	list := listRooms()
+
+	assert.Equal(t, []shared.RoomSummary{
+		{Room: "older", Host: "sara", Players: 1, Capacity: 4},
+		{Room: "newer", Host: "kal", Players: 1, Capacity: 4},
+	}, list.Rooms)
+}
+
+func gameNames(game *models.GameState) []string {
+	names := []string{}
+	for _, p := range game.Players {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestRoomMembersPlayTheGameOfTheirRoom(t *testing.T) {
+	Configure(store.NewMemory())
+	kal, sara := &shared.Client{}, &shared.Client{}
+	kalID, saraID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	shared.Mu.Lock()
+	shared.Players[kal] = shared.Player{ID: kalID, Name: "kal"}
+	shared.Players[sara] = shared.Player{ID: saraID, Name: "sara"}
+	shared.Mu.Unlock()
+	mu.Lock()
+	saved := gameState
+	gameState = models.GameState{HostID: kalID.Hex(), Players: []models.Player{
+		{ID: kalID.Hex(), Name: "kal"},
+		{ID: saraID.Hex(), Name: "sara"},
+	}}
+	mu.Unlock()
+	t.Cleanup(func() {
+		shared.Mu.Lock()
+		delete(shared.Players, kal)
+		delete(shared.Players, sara)
+		shared.Mu.Unlock()
+		mu.Lock()
+		gameState = saved
+		roomGames = make(map[string]*models.GameState)
+		mu.Unlock()
+	})
+
+	r := &room{ID: "room", HostID: kalID.Hex(), Members: []roomMember{{ID: kalID.Hex(), Name: "kal"}}}
+	assert.True(t, placeMembers(r))
+	assert.False(t, placeMembers(r), "placing the same members again moves no one")
+
+	shared.Mu.Lock()
+	assert.Equal(t, "room", shared.Players[kal].Room)
+	assert.Empty(t, shared.Players[sara].Room)
+	shared.Mu.Unlock()
+	mu.Lock()
+	assert.Equal(t, []string{"sara"}, gameNames(&gameState))
+	assert.Equal(t, saraID.Hex(), gameState.HostID, "the lobby game is handed on")
+	require.Contains(t, roomGames, "room")
+	assert.Equal(t, []string{"kal"}, gameNames(roomGames["room"]))
+	mu.Unlock()
+
+	r.Members = nil
+	assert.True(t, placeMembers(r))
+
+	shared.Mu.Lock()
+	assert.Empty(t, shared.Players[kal].Room)
+	shared.Mu.Unlock()
+	mu.Lock()
+	assert.Equal(t, []string{"sara", "kal"}, gameNames(&gameState))
+	assert.NotContains(t, roomGames, "room", "the game closes with the room")
+	mu.Unlock()
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"second_server/shared"
+
+	"github.com/gorilla/websocket"
+)
+
+var draining atomic.Bool
+
+// IsDraining reports whether the server is shutting down. A draining server
+// fails its readiness check and refuses new games and WebSocket connections.
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// Drain stops the server from accepting new games, persists the game state
+// along with any writes buffered during an outage and tells every connected
+// client to reconnect elsewhere. Clients get a going-away close frame and
+// until ctx is done to finish the close handshake; whatever is still
+// connected after that is closed forcibly.
+func Drain(ctx context.Context) {
+	if !draining.CompareAndSwap(false, true) {
+		return
+	}
+	slog.Info("Draining game server")
+
+	mu.Lock()
+	saveGameState(ctx, &gameState)
+	mu.Unlock()
+	flushWriteBehind(ctx)
+
+	message := shared.NewMessage(shared.TypeServerDraining, shared.ServerDrainingPayload{
+		Message:   "Server is shutting down, reconnecting to another server",
+		Reconnect: true,
+	})
+
+	shared.Mu.Lock()
+	for client := range shared.Clients {
+		client.Send(message)
+		client.Close(websocket.CloseGoingAway, "server draining")
+	}
+	shared.Mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		shared.Mu.Lock()
+		remaining := len(shared.Clients)
+		shared.Mu.Unlock()
+		if remaining == 0 {
+			slog.Info("All WebSocket connections closed")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			shared.Mu.Lock()
+			for client := range shared.Clients {
+				client.Conn.Close()
+			}
+			shared.Mu.Unlock()
+			slog.Warn("Forced WebSocket connections closed", "connections", remaining)
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"testing"
+
+	"second_server/shared"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmallestTeamBalancesTeams(t *testing.T) {
+	assert.Equal(t, "red", smallestTeam(map[string]int{}), "ties go to the first team")
+	assert.Equal(t, "blue", smallestTeam(map[string]int{"red": 2, "blue": 1}))
+	assert.Equal(t, "red", smallestTeam(map[string]int{"red": 1, "blue": 1}))
+}
+
+func TestGroupScoresByTeam(t *testing.T) {
+	scores := []shared.ScoreEntry{
+		{Name: "kal", Points: 2, Team: "blue"},
+		{Name: "abebe", Points: 1, Team: "red"},
+		{Name: "lena", Points: 3, Team: "blue"},
+	}
+
+	teams := groupScores(scores, map[string]int{"red": 1, "blue": 5})
+
+	assert.Equal(t, []shared.ScoreEntry{
+		{Name: "abebe", Points: 1, Team: "red"},
+		{Name: "kal", Points: 2, Team: "blue"},
+		{Name: "lena", Points: 3, Team: "blue"},
+	}, scores, "scores are sorted by team")
+	assert.Equal(t, []shared.TeamScore{
+		{Name: "red", Points: 1, Players: []shared.ScoreEntry{{Name: "abebe", Points: 1, Team: "red"}}},
+		{Name: "blue", Points: 5, Players: []shared.ScoreEntry{
+			{Name: "kal", Points: 2, Team: "blue"},
+			{Name: "lena", Points: 3, Team: "blue"},
+		}},
+	}, teams)
+}
+
+func TestGroupPlayersListsEmptyTeams(t *testing.T) {
+	teams := groupPlayers([]shared.PlayerSummary{{Name: "kal", Team: "blue"}}, nil)
+
+	assert.Equal(t, []shared.TeamSummary{
+		{Name: "red", Players: []shared.PlayerSummary{}},
+		{Name: "blue", Players: []shared.PlayerSummary{{Name: "kal", Team: "blue"}}},
+	}, teams)
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"second_server/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueuedPlayersKeepTheirPlace(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := enqueue(ctx, client, models.QueueEntry{PlayerID: "sara", Mode: "race", QueuedAt: start.Add(time.Second)})
+	require.NoError(t, err)
+	_, err = enqueue(ctx, client, models.QueueEntry{PlayerID: "kal", Mode: "race", QueuedAt: start})
+	require.NoError(t, err)
+
+	entry, err := enqueue(ctx, client, models.QueueEntry{PlayerID: "kal", Mode: "classic", QueuedAt: start.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.True(t, start.Equal(entry.QueuedAt), "queueing again keeps the player's place")
+
+	queue, err := loadQueue(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, queue, 2)
+	assert.Equal(t, "kal", queue[0].PlayerID)
+	assert.Equal(t, "classic", queue[0].Mode)
+	assert.Equal(t, "sara", queue[1].PlayerID)
+
+	removed, err := dequeue(ctx, client, "sara")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = dequeue(ctx, client, "sara")
+	require.NoError(t, err)
+	assert.False(t, removed)
+}
+
+func TestTakeQueuedHasOneTaker(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	for _, id := range []string{"kal", "sara", "abebe"} {
+		_, err := enqueue(ctx, client, models.QueueEntry{PlayerID: id, QueuedAt: time.Now()})
+		require.NoError(t, err)
+	}
+
+	taken, err := takeQueued(ctx, client, []string{"kal", "sara"})
+	require.NoError(t, err)
+	assert.True(t, taken)
+
+	taken, err = takeQueued(ctx, client, []string{"sara", "abebe"})
+	require.NoError(t, err)
+	assert.False(t, taken, "sara was already taken")
+
+	queue, err := loadQueue(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, "abebe", queue[0].PlayerID, "nobody is taken unless everyone is")
+}
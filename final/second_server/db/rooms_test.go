@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"second_server/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomJoinsRespectCapacity(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	errFull := errors.New("full")
+
+	created, err := updateRoom(ctx, client, "room", func(r *models.Room) error {
+		r.Capacity = 2
+		r.HostID = "kal"
+		r.Members = []models.RoomMember{{ID: "kal", Name: "kal"}}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), created.Revision)
+
+	var wg sync.WaitGroup
+	joined := make(chan string, 4)
+	for _, id := range []string{"sara", "abebe", "lena", "yonas"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_, err := updateRoom(ctx, client, "room", func(r *models.Room) error {
+				if len(r.Members) >= r.Capacity {
+					return errFull
+				}
+				r.Members = append(r.Members, models.RoomMember{ID: id, Name: id})
+				return nil
+			})
+			if err == nil {
+				joined <- id
+			} else {
+				assert.ErrorIs(t, err, errFull)
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(joined)
+
+	assert.Len(t, joined, 1, "only one player fits")
+	rooms, err := loadRooms(ctx, client)
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Len(t, rooms[0].Members, 2)
+	assert.Equal(t, int64(2), rooms[0].Revision)
+
+	left, err := updateRoom(ctx, client, "room", func(r *models.Room) error {
+		r.Members = nil
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, left.Members)
+	rooms, err = loadRooms(ctx, client)
+	require.NoError(t, err)
+	assert.Empty(t, rooms, "a room without members is deleted")
+}
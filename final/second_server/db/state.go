@@ -0,0 +1,244 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"second_server/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	gameStateKey = "game_state"
+	// gameStatePlayersKey is a hash of player key to JSON encoded player, so
+	// that servers updating different players never overwrite each other.
+	gameStatePlayersKey = "game_state:players"
+
+	maxSaveAttempts = 5
+
+	// roundKeyTTL is how long the winner of a race round is remembered,
+	// long enough for any match to be over.
+	roundKeyTTL = 24 * time.Hour
+	// teamScoreTTL is how long the score of a team is kept, for the same
+	// reason.
+	teamScoreTTL = 24 * time.Hour
+)
+
+var errTooManyConflicts = errors.New("game state changed concurrently too many times")
+
+// playerKey identifies a player in the players hash.
+func playerKey(p models.Player) string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return "name:" + p.Name
+}
+
+// saveGameState writes the game state if nobody else has written it since it
+// was loaded, using WATCH/MULTI on the state key. When another server got
+// there first, their state is merged into ours and the write is retried. On
+// success gameState holds the merged state and its new revision.
+//
+// Players are not part of the blob. They live in the players hash and are
+// written one at a time with SavePlayer and RemovePlayer.
+func saveGameState(ctx context.Context, client redis.UniversalClient, gameState *models.GameState) error {
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			stored, err := readGameState(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			next := *gameState
+			if stored != nil {
+				if stored.Revision != gameState.Revision {
+					next = mergeGameState(*gameState, *stored)
+				}
+				next.Revision = stored.Revision
+			}
+			next.Revision++
+
+			blob := next
+			blob.Players = nil
+			data, err := json.Marshal(blob)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, gameStateKey, data, 0)
+				return nil
+			})
+			if err == nil {
+				*gameState = next
+			}
+			return err
+		}, gameStateKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+	return errTooManyConflicts
+}
+
+func readGameState(ctx context.Context, c redis.Cmdable) (*models.GameState, error) {
+	data, err := c.Get(ctx, gameStateKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var gameState models.GameState
+	if err := json.Unmarshal(data, &gameState); err != nil {
+		return nil, fmt.Errorf("decode game state: %w", err)
+	}
+	return &gameState, nil
+}
+
+// loadGameState reads the state blob and fills in the players from the
+// players hash. States written before the hash existed keep the players
+// stored in the blob.
+func loadGameState(ctx context.Context, client redis.UniversalClient) (*models.GameState, error) {
+	gameState, err := readGameState(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	if gameState == nil {
+		return nil, redis.Nil
+	}
+
+	entries, err := client.HGetAll(ctx, gameStatePlayersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return gameState, nil
+	}
+
+	gameState.Players = make([]models.Player, 0, len(entries))
+	for key, data := range entries {
+		var player models.Player
+		if err := json.Unmarshal([]byte(data), &player); err != nil {
+			return nil, fmt.Errorf("decode player %s: %w", key, err)
+		}
+		gameState.Players = append(gameState.Players, player)
+	}
+	sort.Slice(gameState.Players, func(i, j int) bool {
+		return gameState.Players[i].Name < gameState.Players[j].Name
+	})
+	return gameState, nil
+}
+
+func savePlayers(ctx context.Context, client redis.UniversalClient, players ...models.Player) error {
+	if len(players) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, 2*len(players))
+	for _, player := range players {
+		data, err := json.Marshal(player)
+		if err != nil {
+			return err
+		}
+		values = append(values, playerKey(player), data)
+	}
+	return client.HSet(ctx, gameStatePlayersKey, values...).Err()
+}
+
+func removePlayer(ctx context.Context, client redis.UniversalClient, player models.Player) error {
+	return client.HDel(ctx, gameStatePlayersKey, playerKey(player)).Err()
+}
+
+// claimRound makes playerID the winner of a race round with SET NX, so that
+// of the servers racing to award the same round only the first succeeds.
+func claimRound(ctx context.Context, client redis.UniversalClient, matchStart time.Time, round int, playerID string) (bool, error) {
+	key := fmt.Sprintf("race_round:%d:%d", matchStart.UnixNano(), round)
+	return client.SetNX(ctx, key, playerID, roundKeyTTL).Result()
+}
+
+// addTeamPoint increments a team's score with INCR, so that points scored
+// on different servers at the same moment are all counted.
+func addTeamPoint(ctx context.Context, client redis.UniversalClient, matchStart time.Time, team string) (int, error) {
+	key := fmt.Sprintf("team_score:%d:%s", matchStart.UnixNano(), team)
+	pipe := client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, teamScoreTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+// claimTeamWin makes team the winner of a team match with SET NX, so that of
+// the servers whose players took a team to the target at once only the
+// first ends the match.
+func claimTeamWin(ctx context.Context, client redis.UniversalClient, matchStart time.Time, team string) (bool, error) {
+	key := fmt.Sprintf("team_win:%d", matchStart.UnixNano())
+	return client.SetNX(ctx, key, team, teamScoreTTL).Result()
+}
+
+// mergeGameState combines our state with a newer one written by another
+// server. Players from both are kept, with the higher score winning, and a
+// winner recorded by either side is not lost. Words solved on either side
+// are kept too when both sides are in the same match, and their host is
+// taken when we have none. Everything else is ours.
+func mergeGameState(ours, theirs models.GameState) models.GameState {
+	merged := ours
+
+	byKey := make(map[string]int)
+	merged.Players = nil
+	for _, player := range append(append([]models.Player{}, theirs.Players...), ours.Players...) {
+		key := playerKey(player)
+		if i, ok := byKey[key]; ok {
+			if player.Score > merged.Players[i].Score {
+				merged.Players[i] = player
+			}
+			continue
+		}
+		byKey[key] = len(merged.Players)
+		merged.Players = append(merged.Players, player)
+	}
+
+	if merged.Winner == nil {
+		merged.Winner = theirs.Winner
+	}
+	if merged.HostID == "" {
+		merged.HostID = theirs.HostID
+	}
+
+	if ours.StartedAt.Equal(theirs.StartedAt) {
+		merged.Solved = mergeSolved(ours.Solved, theirs.Solved)
+	}
+	return merged
+}
+
+// mergeSolved returns the words solved on either side once each, in the
+// order they were solved.
+func mergeSolved(ours, theirs []models.SolvedWord) []models.SolvedWord {
+	type key struct {
+		playerID, word string
+		at             int64
+	}
+	seen := make(map[key]bool)
+	var merged []models.SolvedWord
+	for _, solved := range append(append([]models.SolvedWord{}, ours...), theirs...) {
+		k := key{solved.PlayerID, solved.Word, solved.SolvedAt.UnixNano()}
+		if !seen[k] {
+			seen[k] = true
+			merged = append(merged, solved)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].SolvedAt.Before(merged[j].SolvedAt)
+	})
+	return merged
+}
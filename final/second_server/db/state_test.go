@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"second_server/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedis(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSaveGameStateBumpsRevision(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+
+	state := &models.GameState{Word: "apple"}
+	require.NoError(t, saveGameState(ctx, client, state))
+	assert.EqualValues(t, 1, state.Revision)
+
+	require.NoError(t, saveGameState(ctx, client, state))
+	assert.EqualValues(t, 2, state.Revision)
+
+	loaded, err := loadGameState(ctx, client)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, loaded.Revision)
+	assert.Equal(t, "apple", loaded.Word)
+}
+
+func TestSaveGameStateMergesConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+
+	base := &models.GameState{}
+	require.NoError(t, saveGameState(ctx, client, base))
+
+	// Two servers start from the same revision.
+	first := *base
+	second := *base
+
+	winner := &models.Player{ID: "a", Name: "kal", Score: 3}
+	first.Winner = winner
+	require.NoError(t, saveGameState(ctx, client, &first))
+
+	second.Started = true
+	require.NoError(t, saveGameState(ctx, client, &second))
+
+	assert.EqualValues(t, 3, second.Revision)
+	assert.True(t, second.Started)
+	assert.Equal(t, winner, second.Winner, "winner saved by the other server was lost")
+}
+
+func TestPlayersAreStoredIndividually(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+
+	require.NoError(t, saveGameState(ctx, client, &models.GameState{}))
+	require.NoError(t, savePlayers(ctx, client, models.Player{ID: "a", Name: "kal", Score: 1}))
+	require.NoError(t, savePlayers(ctx, client, models.Player{ID: "b", Name: "abebe", Score: 2}))
+
+	// A server that never saw abebe saves its state.
+	stale := &models.GameState{Players: []models.Player{{ID: "a", Name: "kal", Score: 1}}}
+	require.NoError(t, saveGameState(ctx, client, stale))
+
+	loaded, err := loadGameState(ctx, client)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Player{
+		{ID: "b", Name: "abebe", Score: 2},
+		{ID: "a", Name: "kal", Score: 1},
+	}, loaded.Players)
+
+	require.NoError(t, removePlayer(ctx, client, models.Player{ID: "b"}))
+	loaded, err = loadGameState(ctx, client)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Players, 1)
+}
+
+func TestClaimRoundHasOneWinner(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	claimed, err := claimRound(ctx, client, start, 1, "a")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = claimRound(ctx, client, start, 1, "b")
+	require.NoError(t, err)
+	assert.False(t, claimed, "the round was already won")
+
+	claimed, err = claimRound(ctx, client, start, 2, "b")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	claimed, err = claimRound(ctx, client, start.Add(time.Hour), 1, "b")
+	require.NoError(t, err)
+	assert.True(t, claimed, "rounds of the next match are new")
+}
+
+func TestClaimTeamWinHasOneWinner(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	claimed, err := claimTeamWin(ctx, client, start, "red")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	for _, team := range []string{"red", "blue"} {
+		claimed, err = claimTeamWin(ctx, client, start, team)
+		require.NoError(t, err)
+		assert.False(t, claimed, "the match was already won")
+	}
+
+	claimed, err = claimTeamWin(ctx, client, start.Add(time.Hour), "blue")
+	require.NoError(t, err)
+	assert.True(t, claimed, "the next match is new")
+}
+
+func TestAddTeamPointCountsEveryPoint(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for want := 1; want <= 3; want++ {
+		score, err := addTeamPoint(ctx, client, start, "red")
+		require.NoError(t, err)
+		assert.Equal(t, want, score)
+	}
+
+	score, err := addTeamPoint(ctx, client, start, "blue")
+	require.NoError(t, err)
+	assert.Equal(t, 1, score, "teams are counted separately")
+
+	score, err = addTeamPoint(ctx, client, start.Add(time.Hour), "red")
+	require.NoError(t, err)
+	assert.Equal(t, 1, score, "the next match starts from zero")
+}
+
+func TestMergeGameStateKeepsHigherScore(t *testing.T) {
+	ours := models.GameState{Players: []models.Player{{ID: "a", Name: "kal", Score: 1}}}
+	theirs := models.GameState{Players: []models.Player{
+		{ID: "a", Name: "kal", Score: 2},
+		{ID: "b", Name: "abebe", Score: 0},
+	}}
+
+	merged := mergeGameState(ours, theirs)
+	assert.ElementsMatch(t, []models.Player{
+		{ID: "a", Name: "kal", Score: 2},
+		{ID: "b", Name: "abebe", Score: 0},
+	}, merged.Players)
+}
+
+func TestMergeGameStateKeepsOurHost(t *testing.T) {
+	merged := mergeGameState(models.GameState{}, models.GameState{HostID: "b"})
+	assert.Equal(t, "b", merged.HostID)
+
+	merged = mergeGameState(models.GameState{HostID: "a"}, models.GameState{HostID: "b"})
+	assert.Equal(t, "a", merged.HostID)
+}
+
+func TestMergeGameStateKeepsWordsSolvedInTheSameMatch(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	kal := models.SolvedWord{PlayerID: "a", Player: "kal", Word: "apple", SolvedAt: start.Add(time.Second)}
+	abebe := models.SolvedWord{PlayerID: "b", Player: "abebe", Word: "grape", SolvedAt: start.Add(2 * time.Second)}
+	ours := models.GameState{StartedAt: start, Solved: []models.SolvedWord{abebe}}
+	theirs := models.GameState{StartedAt: start, Solved: []models.SolvedWord{kal, abebe}}
+
+	merged := mergeGameState(ours, theirs)
+	assert.Equal(t, []models.SolvedWord{kal, abebe}, merged.Solved)
+
+	// Words of an earlier match are not brought back.
+	theirs.StartedAt = start.Add(-time.Hour)
+	merged = mergeGameState(ours, theirs)
+	assert.Equal(t, []models.SolvedWord{abebe}, merged.Solved)
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"second_server/models"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRedisCommandsAreTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(defaultProvider) })
+
+	client := newTestRedis(t).(*redis.Client)
+	client.AddHook(tracingHook{})
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "submit")
+	_, err := loadGameState(ctx, client)
+	assert.ErrorIs(t, err, redis.Nil)
+	require.NoError(t, savePlayers(ctx, client, models.Player{ID: "p1", Name: "kal"}))
+	parent.End()
+
+	spans := recorder.Ended()
+	names := map[string]bool{}
+	for _, span := range spans {
+		names[span.Name()] = true
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+		if span.Name() == "redis.get" {
+			assert.Equal(t, "Unset", span.Status().Code.String(), "a missing key is not an error")
+		}
+	}
+	assert.True(t, names["redis.get"], "spans: %v", names)
+	assert.True(t, names["redis.hset"], "spans: %v", names)
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnswersAreRedacted(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, slog.LevelInfo)
+
+	logger.Info("Guess", "word", "banana", "guess", "bananas", "score", 2)
+
+	assert.NotContains(t, out.String(), "banana")
+	assert.Contains(t, out.String(), `"word":"[redacted]"`)
+	assert.Contains(t, out.String(), `"score":2`)
+}
+
+func TestContextCarriesIDs(t *testing.T) {
+	var out bytes.Buffer
+	ctx := WithLogger(context.Background(), New(&out, slog.LevelInfo))
+	ctx = WithPlayer(WithRequestID(ctx, "req-1"), "player-1")
+
+	FromContext(ctx).Info("hello")
+
+	assert.Equal(t, "req-1", RequestID(ctx))
+	assert.Contains(t, out.String(), `"request_id":"req-1"`)
+	assert.Contains(t, out.String(), `"player_id":"player-1"`)
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quietPaths are polled constantly by the gateway, orchestrators and
+// Prometheus, so their requests are only logged at debug level.
+var quietPaths = map[string]bool{
+	"/livez":   true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// Middleware tags the request with the ID the gateway sent in
+// RequestIDHeader, or a new one if there is none, echoes it in the response
+// and logs the request once it is done. It replaces gin's text logger.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" {
+			id = NewRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		ctx := WithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if quietPaths[c.Request.URL.Path] {
+			level = slog.LevelDebug
+		}
+		FromContext(ctx).Log(ctx, level, "Request handled",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
@@ -1,71 +1,120 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"second_server/config"
 	"second_server/controllers"
 	"second_server/db"
+	"second_server/logging"
 	"second_server/routes"
 	"second_server/shared"
-
-	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
+	"second_server/store"
+	"second_server/tracing"
+	"syscall"
+	"time"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+const shutdownTimeout = 10 * time.Second
+
+// serverName picks this server's section of the shared config.
+const serverName = "second_server"
 
-var clients = make(map[*websocket.Conn]bool)
+var (
+	configPath  = flag.String("config", "", "config file, YAML or TOML (default $"+config.PathEnv+")")
+	printConfig = flag.Bool("print-config", false, "print the effective config and exit")
+	memory      = flag.Bool("memory", false, "keep users and game state in memory instead of MongoDB and Redis")
+)
+
+func main() {
+	flag.Parse()
 
-func broadcastMessages() {
-	for {
-		msg := <-shared.Broadcast
-		for client := range shared.Clients {
-			err := client.WriteJSON(msg)
-			log.Printf("Broadcasting message: %+v\n", msg)
-			log.Printf("Connected clients: %d\n", len(clients))
-			if err != nil {
-				log.Println("WebSocket write error:", err)
-				client.Close()
-				shared.Mu.Lock()
-				delete(shared.Clients, client)
-				shared.Mu.Unlock()
-			}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logging.Fatal("Failed to load config", "error", err)
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			logging.Fatal("Failed to print config", "error", err)
 		}
+		return
+	}
+	if err := logging.Setup(cfg.Log.Level); err != nil {
+		logging.Fatal("Failed to set up logging", "error", err)
+	}
+	slog.SetDefault(slog.Default().With("server", serverName))
+	shutdownTracing, err := tracing.Setup(cfg.Tracing, serverName)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+	serverCfg, err := cfg.GameServer(serverName)
+	if err != nil {
+		logging.Fatal("Failed to find server config", "error", err)
 	}
-}
 
-func main() {
-	if err := db.Connect(); err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+	if *memory {
+		slog.Warn("Using in-memory storage, nothing will be persisted")
+		controllers.Configure(store.NewMemory())
+	} else {
+		if err := db.Connect(cfg.Mongo.URI); errors.Is(err, db.ErrUnavailable) {
+			slog.Warn("MongoDB is unavailable, starting in degraded mode", "error", err)
+		} else if err != nil {
+			logging.Fatal("Failed to connect to the database", "error", err)
+		}
+		if err := db.InitRedisCluster(cfg.Redis.ClusterNodes); err != nil {
+			slog.Warn("Redis is unavailable, starting in degraded mode", "error", err)
+		}
+		queue := store.NewWriteBehind(store.DefaultMaxPendingWrites)
+		controllers.Configure(queue.Wrap(store.NewProduction()))
 	}
-	go broadcastMessages()
-	r := gin.Default()
+	controllers.MaxClients = serverCfg.MaxClients
+	controllers.ConfigureChat(cfg.Chat.BannedWords, cfg.Chat.Admins)
+	controllers.ConfigureMatchmaking(cfg.Matchmaking)
+	controllers.ConfigureRooms(cfg.Rooms)
+	controllers.ConfigureTeams(cfg.Teams)
+	controllers.LoadGameState()
+	controllers.LoadRooms()
+
+	go shared.BroadcastMessages()
+	r := routes.NewRouter(serverCfg.CORSOrigins)
 
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://127.0.0.1:5501")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	controllers.StartEventListener(ctx)
+	controllers.StartMatchmaker(ctx)
+	controllers.StartWriteBehind(ctx)
+
+	srv := &http.Server{Addr: serverCfg.Listen, Handler: r}
+	go func() {
+		slog.Info("Game server is listening", "addr", serverCfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal("Failed to start backup game server", "error", err)
 		}
-		c.Next()
-	})
+	}()
 
-	r.GET("/health", func(c *gin.Context) {
-		c.String(http.StatusOK, "OK")
-	})
+	<-ctx.Done()
+	stop()
 
-	routes.RegisterRoutes(r)
-	db.InitRedisCluster()
-	controllers.LoadGameState()
+	slog.Info("Shutdown signal received, draining connections")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	log.Println("Second server is running on http://localhost:8081")
-	if err := r.Run(":8081"); err != nil {
-		log.Fatalf("Failed to start backup game server: %v", err)
+	controllers.Drain(shutdownCtx)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server shutdown failed", "error", err)
+	}
+	if db.Client != nil {
+		if err := db.Client.Disconnect(shutdownCtx); err != nil {
+			slog.Error("Failed to disconnect from MongoDB", "error", err)
+		}
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
+	slog.Info("Game server stopped")
 }
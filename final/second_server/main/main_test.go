@@ -2,51 +2,497 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 
+	"second_server/controllers"
+	"second_server/logging"
+	"second_server/metrics"
+	"second_server/models"
+	"second_server/routes"
+	"second_server/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	router *gin.Engine
+	stores store.Stores
+	// The server is configured once, since the goroutines its handlers leave
+	// running, such as the player list broadcast, keep using its stores
+	// after a test ends. Tests swap misbehaving users in through users and
+	// fail dependencies through mongo and redis instead.
+	users        = &swappableUsers{}
+	mongo, redis = &dependency{}, &dependency{}
 )
 
-const baseURL = "http://localhost:8081"
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	stores = store.NewMemory()
+	users.use(stores.Users)
+
+	configured := stores
+	configured.Users = users
+	configured.Health = []store.HealthCheck{
+		{Name: "mongo", Check: mongo.Ping},
+		{Name: "redis", Check: redis.Ping},
+	}
+	controllers.Configure(store.NewWriteBehind(store.DefaultMaxPendingWrites).Wrap(configured))
+	controllers.LoadGameState()
+	controllers.LoadRooms()
+	router = routes.NewRouter(nil)
+	os.Exit(m.Run())
+}
+
+// swappableUsers is the UserStore the server is configured with, passing
+// every call on to the store a test put in place.
+type swappableUsers struct {
+	mu    sync.Mutex
+	users store.UserStore
+}
+
+func (s *swappableUsers) use(users store.UserStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = users
+}
+
+func (s *swappableUsers) current() store.UserStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users
+}
+
+func (s *swappableUsers) Create(ctx context.Context, user *models.User) error {
+	return s.current().Create(ctx, user)
+}
+
+func (s *swappableUsers) FindByID(ctx context.Context, id string) (*models.User, error) {
+	return s.current().FindByID(ctx, id)
+}
+
+func (s *swappableUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.current().FindByEmail(ctx, email)
+}
+
+func (s *swappableUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.current().FindByUsername(ctx, username)
+}
+
+func (s *swappableUsers) SetWord(ctx context.Context, id, word string) error {
+	return s.current().SetWord(ctx, id, word)
+}
+
+func (s *swappableUsers) SetScore(ctx context.Context, id string, score int) error {
+	return s.current().SetScore(ctx, id, score)
+}
+
+func (s *swappableUsers) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	return s.current().SetWordAndScore(ctx, id, word, score)
+}
+
+func (s *swappableUsers) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	return s.current().SolveWord(ctx, id, word, newWord)
+}
+
+func (s *swappableUsers) RecordWin(ctx context.Context, match *models.Match) error {
+	return s.current().RecordWin(ctx, match)
+}
+
+// useUsers has the server use swapped instead of the test users until the
+// test ends.
+func useUsers(t *testing.T, swapped store.UserStore) {
+	users.use(swapped)
+	t.Cleanup(func() { users.use(stores.Users) })
+}
+
+// dependency stands in for a database the readiness check pings.
+type dependency struct {
+	mu  sync.Mutex
+	err error
+}
+
+// fail makes the dependency's check fail with err until the test ends.
+func (d *dependency) fail(t *testing.T, err error) {
+	d.set(err)
+	t.Cleanup(func() { d.set(nil) })
+}
 
-func sendPostRequest(endpoint string, requestBody map[string]string) (*http.Response, error) {
+func (d *dependency) set(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+}
+
+func (d *dependency) Ping(context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+func sendPostRequest(endpoint string, requestBody map[string]string) *httptest.ResponseRecorder {
 	jsonValue, _ := json.Marshal(requestBody)
-	resp, err := http.Post(baseURL+endpoint, "application/json", bytes.NewBuffer(jsonValue))
-	return resp, err
+	req := httptest.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func createUser(t *testing.T, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", Score: 2}
+	require.NoError(t, stores.Users.Create(context.Background(), user))
+	return user
 }
 
 func TestCheckMenu_ValidPlayerID(t *testing.T) {
-	resp, err := sendPostRequest("/menu", map[string]string{
-		"player_id": "6794d69bc1b5b71a3a2f1e1a",
+	user := createUser(t, "menu_player")
+
+	resp := sendPostRequest("/menu", map[string]string{
+		"player_id": user.ID.Hex(),
 		"type":      "new",
 	})
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	stored, err := stores.Users.FindByID(context.Background(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 0, stored.Score)
 }
 
 func TestCheckMenu_InvalidPlayerID(t *testing.T) {
-	resp, err := sendPostRequest("/menu", map[string]string{
+	resp := sendPostRequest("/menu", map[string]string{
 		"player_id": "invalid_id",
 		"type":      "new",
 	})
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
 }
 
 func TestStartGame_ValidPlayerID(t *testing.T) {
-	resp, err := sendPostRequest("/start", map[string]string{
-		"player_id": "6794d69bc1b5b71a3a2f1e1a",
+	user := createUser(t, "start_player")
+
+	resp := sendPostRequest("/start", map[string]string{
+		"player_id": user.ID.Hex(),
 	})
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var body struct {
+		Word string `json:"word"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	stored, err := stores.Users.FindByID(context.Background(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, stored.Word, body.Word)
 }
 
 func TestStartGame_InvalidPlayerID(t *testing.T) {
-	resp, err := sendPostRequest("/start", map[string]string{
+	resp := sendPostRequest("/start", map[string]string{
 		"player_id": "invalid_id",
 	})
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestStartGame_UnknownPlayer(t *testing.T) {
+	resp := sendPostRequest("/start", map[string]string{
+		"player_id": "6794d69bc1b5b71a3a2f1e1a",
+	})
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestSubmitAnswer_CorrectGuessScores(t *testing.T) {
+	user := createUser(t, "guess_player")
+	require.NoError(t, stores.Users.SetWordAndScore(context.Background(), user.ID.Hex(), "apple", 0))
+
+	resp := sendPostRequest("/submit", map[string]string{
+		"player_id": user.ID.Hex(),
+		"guess":     "APPLE",
+	})
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var body struct {
+		Correct bool `json:"correct"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.True(t, body.Correct)
+
+	stored, err := stores.Users.FindByID(context.Background(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Score)
+}
+
+// staleUsers answers lookups with the word a player had before another
+// server solved it.
+type staleUsers struct {
+	store.UserStore
+	word string
+}
+
+func (s staleUsers) FindByID(ctx context.Context, id string) (*models.User, error) {
+	user, err := s.UserStore.FindByID(ctx, id)
+	if err == nil {
+		user.Word = s.word
+	}
+	return user, err
+}
+
+func TestSubmitAnswer_WordSolvedElsewhereDoesNotScore(t *testing.T) {
+	user := createUser(t, "race_player")
+	// Another server has already solved apple and moved the player on.
+	require.NoError(t, stores.Users.SetWordAndScore(context.Background(), user.ID.Hex(), "grape", 1))
+
+	useUsers(t, staleUsers{UserStore: stores.Users, word: "apple"})
+
+	resp := sendPostRequest("/submit", map[string]string{
+		"player_id": user.ID.Hex(),
+		"guess":     "apple",
+	})
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	stored, err := stores.Users.FindByID(context.Background(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Score)
+	assert.Equal(t, "grape", stored.Word)
+}
+
+func TestSubmitAnswer_WinningGuessRecordsTheWin(t *testing.T) {
+	user := createUser(t, "winning_player")
+	require.NoError(t, stores.Users.SetWordAndScore(context.Background(), user.ID.Hex(), "apple", 2))
+
+	resp := sendPostRequest("/submit", map[string]string{
+		"player_id": user.ID.Hex(),
+		"guess":     "apple",
+	})
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "winning_player won the game!")
+	assert.Contains(t, resp.Body.String(), `"score":3`, "the final score is shown")
+
+	stored, err := stores.Users.FindByID(context.Background(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Zero(t, stored.Score, "scores start over for the next match")
+	assert.Equal(t, 1, stored.Wins)
+}
+
+func TestStartGame_NewMatchStartsScoresOver(t *testing.T) {
+	winner := createUser(t, "previous_winner")
+	require.Equal(t, http.StatusOK, sendPostRequest("/start", map[string]string{"player_id": winner.ID.Hex()}).Code)
+	winMatch(t, winner.ID.Hex())
+
+	// A score left over from earlier does not count towards the next match.
+	user := createUser(t, "leftover_player")
+	id := user.ID.Hex()
+	require.Equal(t, 2, user.Score)
+	require.Equal(t, http.StatusOK, sendPostRequest("/start", map[string]string{"player_id": id}).Code)
+
+	stored, err := stores.Users.FindByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Zero(t, stored.Score)
+
+	resp := sendPostRequest("/submit", map[string]string{"player_id": id, "guess": stored.Word})
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), "won the game!")
+
+	stored, err = stores.Users.FindByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Score)
+	assert.Zero(t, stored.Wins)
+}
+
+// failingWins fails to record the first win it is given.
+type failingWins struct {
+	store.UserStore
+	failed *bool
+}
+
+func (s failingWins) RecordWin(ctx context.Context, match *models.Match) error {
+	if !*s.failed {
+		*s.failed = true
+		return errors.New("write conflict")
+	}
+	return s.UserStore.RecordWin(ctx, match)
+}
+
+func TestSubmitAnswer_WinIsRetriedAfterItFailedToRecord(t *testing.T) {
+	user := createUser(t, "retried_winner")
+	id := user.ID.Hex()
+	require.NoError(t, stores.Users.SetWordAndScore(context.Background(), id, "apple", 2))
+
+	useUsers(t, failingWins{UserStore: stores.Users, failed: new(bool)})
+
+	resp := sendPostRequest("/submit", map[string]string{"player_id": id, "guess": "apple"})
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	stored, err := stores.Users.FindByID(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, 3, stored.Score, "the point was kept")
+	require.Zero(t, stored.Wins)
+
+	resp = sendPostRequest("/submit", map[string]string{"player_id": id, "guess": stored.Word})
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "retried_winner won the game!")
+
+	stored, err = stores.Users.FindByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Wins, "the win is stored once")
+}
+
+func TestGameEventsAreLogged(t *testing.T) {
+	user := createUser(t, "logged_events_player")
+	id := user.ID.Hex()
+
+	require.Equal(t, http.StatusOK, sendPostRequest("/start", map[string]string{"player_id": id}).Code)
+	require.Equal(t, http.StatusOK, sendPostRequest("/submit", map[string]string{"player_id": id, "guess": "wrong"}).Code)
+	winMatch(t, id)
+
+	var events []models.GameEvent
+	for _, event := range stores.EventLog.(*store.MemoryEventLog).Events() {
+		if event.PlayerID == id {
+			events = append(events, event)
+		}
+	}
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+		assert.False(t, event.At.IsZero())
+	}
+	require.Greater(t, len(types), 5)
+	assert.Equal(t, []string{models.EventWordAssigned, models.EventGuess, models.EventGuess}, types[:3])
+	assert.Equal(t, "wrong", events[1].Guess)
+	assert.Equal(t, []string{models.EventCorrect, models.EventWordAssigned, models.EventGameOver}, types[len(types)-3:])
+	assigned := ""
+	for _, event := range events {
+		switch event.Type {
+		case models.EventWordAssigned:
+			assigned = event.Word
+		case models.EventCorrect:
+			assert.Equal(t, assigned, event.Word, "the word last assigned was solved")
+		}
+	}
+	last := len(events) - 1
+	assert.Equal(t, 3, events[last-2].Score)
+	assert.NotEmpty(t, events[last-1].Scrambled)
+	assert.NotEmpty(t, events[last].MatchID)
+	for _, event := range events {
+		assert.Equal(t, events[last].MatchID, event.MatchID, "every event of the match names it")
+		assert.Empty(t, event.Room)
+	}
+}
+
+// winMatch has the player solve their word until they win the match under
+// way, which ends it.
+func winMatch(t *testing.T, id string) {
+	t.Helper()
+	for range 10 {
+		stored, err := stores.Users.FindByID(context.Background(), id)
+		require.NoError(t, err)
+		resp := sendPostRequest("/submit", map[string]string{"player_id": id, "guess": stored.Word})
+		require.Equal(t, http.StatusOK, resp.Code)
+		if strings.Contains(resp.Body.String(), "won the game!") {
+			return
+		}
+	}
+	t.Fatal("the player never won")
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	user := createUser(t, "metrics_player")
+	id := user.ID.Hex()
+	require.Equal(t, http.StatusOK, sendPostRequest("/start", map[string]string{"player_id": id}).Code)
+	winMatch(t, id)
+	startedBefore := testutil.ToFloat64(metrics.GamesStarted)
+	incorrectBefore := testutil.ToFloat64(metrics.Guesses.WithLabelValues("incorrect"))
+
+	require.Equal(t, http.StatusOK, sendPostRequest("/start", map[string]string{"player_id": id}).Code)
+	require.Equal(t, http.StatusOK, sendPostRequest("/submit", map[string]string{
+		"player_id": id,
+		"guess":     "not the word",
+	}).Code)
+	require.Equal(t, http.StatusOK, sendPostRequest("/start", map[string]string{"player_id": id}).Code)
+
+	assert.Equal(t, startedBefore+1, testutil.ToFloat64(metrics.GamesStarted), "a new word in the same match is not a new game")
+	assert.Equal(t, incorrectBefore+1, testutil.ToFloat64(metrics.Guesses.WithLabelValues("incorrect")))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	for _, name := range []string{
+		"scrambled_games_started_total",
+		`scrambled_guesses_total{result="incorrect"}`,
+		"scrambled_active_players",
+		"scrambled_broadcast_queue_depth",
+		"go_goroutines",
+	} {
+		assert.Contains(t, resp.Body.String(), name)
+	}
+}
+
+func TestLogsCarryIDsButNotAnswers(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(logging.New(&logs, slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	user := createUser(t, "logged_player")
+	require.NoError(t, stores.Users.SetWordAndScore(context.Background(), user.ID.Hex(), "cherry", 0))
+
+	jsonValue, _ := json.Marshal(map[string]string{"player_id": user.ID.Hex(), "guess": "CHERRY"})
+	req := httptest.NewRequest(http.MethodPost, "/submit", bytes.NewBuffer(jsonValue))
+	req.Header.Set(logging.RequestIDHeader, "req-from-gateway")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "req-from-gateway", resp.Header().Get(logging.RequestIDHeader))
+
+	assert.NotContains(t, strings.ToLower(logs.String()), "cherry")
+
+	var sawPlayer bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		assert.Equal(t, "req-from-gateway", entry[logging.KeyRequestID], line)
+		if entry[logging.KeyPlayerID] == user.ID.Hex() {
+			sawPlayer = true
+		}
+	}
+	assert.True(t, sawPlayer, "no log line carries the player ID")
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	get := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, get("/livez").Code)
+
+	resp := get("/readyz")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var ready controllers.Readiness
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &ready))
+	assert.Equal(t, controllers.StatusReady, ready.Status)
+	assert.Equal(t, controllers.MaxClients, ready.Capacity.Available)
+
+	// With writes buffered the server keeps playing through an outage.
+	redis.fail(t, errors.New("connection refused"))
+	resp = get("/readyz")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var degraded controllers.Readiness
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &degraded))
+	assert.Equal(t, controllers.StatusDegraded, degraded.Status)
+	assert.True(t, degraded.Checks["mongo"].OK)
+	assert.False(t, degraded.Checks["redis"].OK)
+	assert.Equal(t, "connection refused", degraded.Checks["redis"].Error)
+	assert.Equal(t, http.StatusOK, get("/livez").Code, "liveness ignores dependencies")
 }
@@ -0,0 +1,85 @@
+// Package metrics exposes the game server's Prometheus metrics on /metrics.
+// They live in their own registry rather than the global one so that
+// several game servers can run in one process, as the integration tests do.
+package metrics
+
+import (
+	"net/http"
+	"second_server/shared"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scrambled"
+
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	GamesStarted = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "games_started_total",
+		Help:      "Matches started.",
+	})
+
+	Guesses = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guesses_total",
+		Help:      "Guesses submitted, by result (correct or incorrect).",
+	}, []string{"result"})
+
+	Wins = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wins_total",
+		Help:      "Games won.",
+	})
+
+	DroppedBroadcasts = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcasts_dropped_total",
+		Help:      "Game messages dropped because the broadcast queue was full.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_players",
+		Help:      "Registered players connected to this server.",
+	}, func() float64 {
+		shared.Mu.Lock()
+		defer shared.Mu.Unlock()
+		return float64(len(shared.Players))
+	})
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "broadcast_queue_depth",
+		Help:      "Game messages waiting to be handed to clients.",
+	}, func() float64 {
+		return float64(len(shared.Broadcast))
+	})
+}
+
+// RecordGuess counts a submitted guess.
+func RecordGuess(correct bool) {
+	if correct {
+		Guesses.WithLabelValues("correct").Inc()
+	} else {
+		Guesses.WithLabelValues("incorrect").Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// QueueEntry is a player waiting in the matchmaking queue for a room.
+// Origin is the game server they are connected to.
+type QueueEntry struct {
+	PlayerID string    `json:"player_id"`
+	Name     string    `json:"name"`
+	Rating   int       `json:"rating"`
+	Mode     string    `json:"mode"`
+	QueuedAt time.Time `json:"queued_at"`
+	Origin   string    `json:"origin"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Room gathers players who want to play together. Every room has a game of
+// its own, played in the room's mode, which only its members see.
+type Room struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Mode     string       `json:"mode"`
+	Private  bool         `json:"private"`
+	Capacity int          `json:"capacity"`
+	HostID   string       `json:"host_id"`
+	Members  []RoomMember `json:"members"`
+	// Code is the join code of a private room, valid until CodeExpiresAt.
+	Code          string    `json:"code,omitempty"`
+	CodeExpiresAt time.Time `json:"code_expires_at,omitempty"`
+	// Invites maps invite tokens to when they expire.
+	Invites   map[string]time.Time `json:"invites,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+	// Revision counts the changes stored to the room, so that a server can
+	// tell an older copy of it from a newer one.
+	Revision int64 `json:"revision"`
+}
+
+type RoomMember struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,239 @@
+package shared
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a single write to a client may take.
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent, pongs included, before
+	// its connection is considered dead.
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so a healthy client always
+	// gets a ping in time to answer it.
+	pingPeriod = (pongWait * 9) / 10
+	// closeGracePeriod is how long to wait for the client to answer a close
+	// frame before the connection is dropped.
+	closeGracePeriod = time.Second
+
+	maxMessageSize = 4096
+	sendBufferSize = 32
+)
+
+// Client is a WebSocket connection with its own outbound queue. Only the
+// client's write pump writes to the connection, so Send may be called from
+// any goroutine. A client whose queue fills up is too slow to keep up with
+// the game and is disconnected.
+type Client struct {
+	Conn *websocket.Conn
+
+	send    chan Message
+	done    chan struct{}
+	stopped chan struct{}
+
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+
+	logger atomic.Pointer[slog.Logger]
+}
+
+// NewClient wraps an upgraded connection, sets up its read limits and
+// keepalive deadlines and starts its write pump.
+func NewClient(conn *websocket.Conn) *Client {
+	c := &Client{
+		Conn:    conn,
+		send:    make(chan Message, sendBufferSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go c.writePump()
+	return c
+}
+
+// Logger returns the logger for lines about this client, tagged with its
+// request and, once registered, player ID.
+func (c *Client) Logger() *slog.Logger {
+	if logger := c.logger.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// SetLogger replaces the client's logger, e.g. once the player registers.
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger.Store(logger)
+}
+
+// Send queues a message for the client. It never blocks; if the queue is
+// full the client is evicted and Send returns false.
+func (c *Client) Send(msg Message) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		c.Logger().Warn("Client send buffer full, evicting slow consumer")
+		c.Close(websocket.ClosePolicyViolation, "too slow")
+		return false
+	}
+}
+
+// Close asks the write pump to flush queued messages, send a close frame with
+// the given code and close the connection. Only the first call has an effect.
+func (c *Client) Close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+func (c *Client) write(msg Message) error {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.Conn.WriteJSON(msg)
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(msg); err != nil {
+				c.Logger().Warn("WebSocket write failed", "error", err)
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.Logger().Warn("WebSocket ping failed", "error", err)
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-c.done:
+			c.flush()
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				frame := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				if err := c.Conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(writeWait)); err == nil {
+					select {
+					case <-c.stopped:
+					case <-time.After(closeGracePeriod):
+					}
+				}
+			}
+			return
+		}
+	}
+}
+
+// flush writes whatever is still queued, e.g. a final message sent right
+// before Close.
+func (c *Client) flush() {
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.write(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Register adds a newly connected client.
+func Register(c *Client) {
+	Mu.Lock()
+	defer Mu.Unlock()
+	Clients[c] = true
+}
+
+// Unregister removes a client once its read loop has ended and closes its
+// connection.
+func Unregister(c *Client) {
+	Mu.Lock()
+	delete(Clients, c)
+	delete(Players, c)
+	delete(Spectators, c)
+	Mu.Unlock()
+
+	c.Close(websocket.CloseNormalClosure, "")
+	close(c.stopped)
+}
+
+// BroadcastMessages hands game messages to the send queues of the clients
+// of their game until Broadcast is closed.
+func BroadcastMessages() {
+	for d := range Broadcast {
+		Mu.Lock()
+		slog.Debug("Broadcasting message", "type", d.Message.Type, "room", d.Room, "clients", len(Clients))
+		SendToGame(d.Room, d.Message)
+		Mu.Unlock()
+	}
+}
+
+// SendToGame queues a message for the clients of the game of room: its
+// players and spectators, or with room empty also every client neither
+// playing nor watching a room. Callers must hold Mu.
+func SendToGame(room string, msg Message) {
+	for client := range Clients {
+		if GameRoom(client) == room {
+			client.Send(msg)
+		}
+	}
+}
+
+// GameRoom returns the room of the game the client plays or watches, or ""
+// for the lobby game. Callers must hold Mu.
+func GameRoom(c *Client) string {
+	if room, spectating := Spectators[c]; spectating {
+		return room
+	}
+	return Players[c].Room
+}
+
+// SendToPlayers queues a message for the players in room, or with room
+// empty for the players not in a room. Callers must hold Mu.
+func SendToPlayers(room string, msg Message) {
+	for client, player := range Players {
+		if player.Room == room {
+			client.Send(msg)
+		}
+	}
+}
+
+// SendToSpectators queues a message for the spectators of the game of
+// room. Callers must hold Mu.
+func SendToSpectators(room string, msg Message) {
+	for client, watched := range Spectators {
+		if watched == room {
+			client.Send(msg)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveClient starts a server that wraps every connection in a Client and
+// hands it to the test, and returns a connection dialed to it.
+func serveClient(t *testing.T) (*Client, *websocket.Conn) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		clients <- NewClient(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	select {
+	case c := <-clients:
+		return c, conn
+	case <-time.After(time.Second):
+		t.Fatal("server never accepted the connection")
+		return nil, nil
+	}
+}
+
+func TestClientSendsQueuedMessagesInOrder(t *testing.T) {
+	client, conn := serveClient(t)
+
+	for _, name := range []string{"a", "b", "c"} {
+		assert.True(t, client.Send(NewMessage(TypePlayerList, PlayerSummary{Name: name})))
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, name := range []string{"a", "b", "c"} {
+		var msg struct {
+			Type    string        `json:"type"`
+			Payload PlayerSummary `json:"payload"`
+		}
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, TypePlayerList, msg.Type)
+		assert.Equal(t, name, msg.Payload.Name)
+	}
+}
+
+func TestClientCloseFlushesAndSendsCloseFrame(t *testing.T) {
+	client, conn := serveClient(t)
+
+	client.Send(NewMessage(TypeServerDraining, ServerDrainingPayload{Reconnect: true}))
+	client.Close(websocket.CloseGoingAway, "server draining")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, TypeServerDraining, msg.Type)
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+}
+
+func TestClientSendEvictsSlowConsumer(t *testing.T) {
+	// No write pump, so nothing drains the queue.
+	client := &Client{
+		send:    make(chan Message, sendBufferSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	for i := 0; i < sendBufferSize; i++ {
+		require.True(t, client.Send(NewMessage(TypePlayerList, nil)))
+	}
+	assert.False(t, client.Send(NewMessage(TypePlayerList, nil)))
+
+	select {
+	case <-client.done:
+	default:
+		t.Fatal("slow consumer was not closed")
+	}
+	assert.Equal(t, websocket.ClosePolicyViolation, client.closeCode)
+	assert.False(t, client.Send(NewMessage(TypePlayerList, nil)), "closed client accepted a message")
+}
+
+func TestSendToGameReachesOnlyTheClientsOfTheGame(t *testing.T) {
+	newClient := func() *Client {
+		return &Client{send: make(chan Message, 1), done: make(chan struct{}), stopped: make(chan struct{})}
+	}
+	lobby, member, connected, watcher := newClient(), newClient(), newClient(), newClient()
+
+	Mu.Lock()
+	defer Mu.Unlock()
+	savedClients, savedPlayers, savedSpectators := Clients, Players, Spectators
+	defer func() { Clients, Players, Spectators = savedClients, savedPlayers, savedSpectators }()
+	Clients = map[*Client]bool{lobby: true, member: true, connected: true, watcher: true}
+	Players = map[*Client]Player{lobby: {Name: "kal"}, member: {Name: "sara", Room: "room"}}
+	Spectators = map[*Client]string{watcher: "room"}
+
+	SendToGame("room", NewMessage(TypeWordSolved, nil))
+	assert.Len(t, member.send, 1)
+	assert.Len(t, watcher.send, 1, "spectators of the room see its game")
+	assert.Empty(t, lobby.send)
+	assert.Empty(t, connected.send)
+
+	<-member.send
+	<-watcher.send
+	SendToGame("", NewMessage(TypeWordSolved, nil))
+	assert.Len(t, lobby.send, 1)
+	assert.Len(t, connected.send, 1, "clients that have not registered watch the lobby game")
+	assert.Empty(t, member.send)
+	assert.Empty(t, watcher.send)
+}
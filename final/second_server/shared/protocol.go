@@ -0,0 +1,657 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProtocolVersion is the version of the WebSocket message protocol spoken by
+// the game servers. Clients put it in the "v" field of every message;
+// messages without one are treated as version 1. See PROTOCOL.md.
+const ProtocolVersion = 1
+
+// Client to server message types. start_game is also sent by the server,
+// both as the reply to a start_game request and when a game is started for
+// the player over HTTP.
+const (
+	TypeRegister    = "register"
+	TypeStartGame   = "start_game"
+	TypeSubmitGuess = "submit_guess"
+	TypeSkip        = "skip"
+	TypeLeave       = "leave"
+	TypeSpectate    = "spectate"
+	TypeChat        = "chat"
+	TypeMute        = "mute"
+	TypeKick        = "kick"
+	TypeTeam        = "team"
+	TypeQueue       = "queue"
+	TypeLeaveQueue  = "leave_queue"
+	TypeCreateRoom  = "create_room"
+	TypeListRooms   = "list_rooms"
+	TypeJoinRoom    = "join_room"
+	TypeInvite      = "invite"
+	TypeRevokeCode  = "revoke_code"
+	TypeLeaveRoom   = "leave_room"
+)
+
+// Server to client message types. chat is also sent by the server, to
+// deliver a chat message to everyone in the game.
+const (
+	TypePlayerList     = "player_list"
+	TypeGuessResult    = "guess_result"
+	TypeSkipped        = "skipped"
+	TypeLeft           = "left"
+	TypeSpectating     = "spectating"
+	TypeWordSolved     = "word_solved"
+	TypeRoundResult    = "round_result"
+	TypeChatHistory    = "chat_history"
+	TypeModeration     = "moderation"
+	TypeQueueStatus    = "queue_status"
+	TypeMatchFound     = "match_found"
+	TypeRoom           = "room"
+	TypeRoomList       = "room_list"
+	TypeInviteCreated  = "invite_created"
+	TypeGameOver       = "game_over"
+	TypeServerDraining = "server_draining"
+	TypeError          = "error"
+)
+
+// Error codes sent in ErrorPayload.Code.
+const (
+	ErrCodeBadMessage         = "bad_message"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeNotRegistered      = "not_registered"
+	ErrCodeSpectator          = "spectator"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeMuted              = "muted"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeDraining           = "draining"
+	ErrCodeWordChanged        = "word_changed"
+	ErrCodeRoomFull           = "room_full"
+	ErrCodeInternal           = "internal"
+)
+
+const (
+	maxUsernameLength = 32
+	maxGuessLength    = 64
+	maxRequestIDLen   = 64
+	maxChatLength     = 200
+	maxMuteMinutes    = 24 * 60
+	maxRoomNameLength = 40
+)
+
+// Envelope is an incoming message whose payload has not been decoded yet.
+type Envelope struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Request is a decoded and validated client message. ID is the optional
+// client-chosen request id that is echoed back in the reply.
+type Request struct {
+	ID      string
+	Type    string
+	Payload interface{}
+}
+
+// RegisterPayload joins the game. Team is optional; players who pick none
+// are put on the smallest team.
+type RegisterPayload struct {
+	Username string `json:"username"`
+	Team     string `json:"team,omitempty"`
+}
+
+func (p *RegisterPayload) Validate() error {
+	p.Username = strings.TrimSpace(p.Username)
+	if p.Username == "" {
+		return errors.New("username is required")
+	}
+	if len(p.Username) > maxUsernameLength {
+		return fmt.Errorf("username must be at most %d characters", maxUsernameLength)
+	}
+	if p.Team != "" && !IsTeam(p.Team) {
+		return fmt.Errorf("team must be one of %s", strings.Join(Teams, ", "))
+	}
+	return nil
+}
+
+// Game modes. In a classic game every player works on words of their own;
+// in a race everyone gets the same word and the first to solve it wins the
+// round. A team game plays like a classic one, but every point also counts
+// for the player's team and the first team to reach the target wins.
+const (
+	ModeClassic = "classic"
+	ModeRace    = "race"
+	ModeTeam    = "team"
+)
+
+// Teams are the teams a player can be on.
+var Teams = []string{"red", "blue"}
+
+func IsTeam(name string) bool {
+	for _, team := range Teams {
+		if team == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TeamPayload moves the player to another team.
+type TeamPayload struct {
+	Team string `json:"team"`
+}
+
+func (p *TeamPayload) Validate() error {
+	if !IsTeam(p.Team) {
+		return fmt.Errorf("team must be one of %s", strings.Join(Teams, ", "))
+	}
+	return nil
+}
+
+// StartGameRequest asks for a word. Mode only matters to the player who
+// starts a new game; anyone joining later plays the game's mode.
+type StartGameRequest struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+func (p *StartGameRequest) Validate() error {
+	switch p.Mode {
+	case "", ModeClassic, ModeRace, ModeTeam:
+		return nil
+	}
+	return fmt.Errorf("mode must be %q, %q or %q", ModeClassic, ModeRace, ModeTeam)
+}
+
+// QueuePayload puts the player in the matchmaking queue for a game of the
+// given mode, classic if none.
+type QueuePayload struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+func (p *QueuePayload) Validate() error {
+	return (&StartGameRequest{Mode: p.Mode}).Validate()
+}
+
+type LeaveQueuePayload struct{}
+
+func (p *LeaveQueuePayload) Validate() error { return nil }
+
+// CreateRoomPayload opens a room hosted by the player. A private room gets
+// a join code and is left out of room listings. Capacity is the most players
+// the room takes, the server's default when zero.
+type CreateRoomPayload struct {
+	Name     string `json:"name,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Private  bool   `json:"private,omitempty"`
+	Capacity int    `json:"capacity,omitempty"`
+}
+
+func (p *CreateRoomPayload) Validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if utf8.RuneCountInString(p.Name) > maxRoomNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxRoomNameLength)
+	}
+	if p.Capacity < 0 {
+		return errors.New("capacity must not be negative")
+	}
+	return (&StartGameRequest{Mode: p.Mode}).Validate()
+}
+
+type ListRoomsPayload struct{}
+
+func (p *ListRoomsPayload) Validate() error { return nil }
+
+// JoinRoomPayload joins a room by one of: the ID of a public room, the join
+// code of a private one, or an invite token.
+type JoinRoomPayload struct {
+	Room   string `json:"room,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Invite string `json:"invite,omitempty"`
+}
+
+func (p *JoinRoomPayload) Validate() error {
+	p.Room = strings.TrimSpace(p.Room)
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	p.Invite = strings.TrimSpace(p.Invite)
+	given := 0
+	for _, value := range []string{p.Room, p.Code, p.Invite} {
+		if value != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		return errors.New("exactly one of room, code and invite is required")
+	}
+	return nil
+}
+
+// InvitePayload asks for an invite to the room the player hosts.
+type InvitePayload struct{}
+
+func (p *InvitePayload) Validate() error { return nil }
+
+// RevokeCodePayload revokes the join code and the invites of the room the
+// player hosts. With Renew the room gets a new join code in their place.
+type RevokeCodePayload struct {
+	Renew bool `json:"renew,omitempty"`
+}
+
+func (p *RevokeCodePayload) Validate() error { return nil }
+
+type LeaveRoomPayload struct{}
+
+func (p *LeaveRoomPayload) Validate() error { return nil }
+
+type SubmitGuessPayload struct {
+	Guess string `json:"guess"`
+}
+
+func (p *SubmitGuessPayload) Validate() error {
+	p.Guess = strings.TrimSpace(p.Guess)
+	if p.Guess == "" {
+		return errors.New("guess is required")
+	}
+	if len(p.Guess) > maxGuessLength {
+		return fmt.Errorf("guess must be at most %d characters", maxGuessLength)
+	}
+	return nil
+}
+
+type SkipPayload struct{}
+
+func (p *SkipPayload) Validate() error { return nil }
+
+type LeavePayload struct{}
+
+func (p *LeavePayload) Validate() error { return nil }
+
+// SpectatePayload names the room whose game to watch, the same way
+// JoinRoomPayload does. With none of them given the lobby game is watched.
+type SpectatePayload struct {
+	Room   string `json:"room,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Invite string `json:"invite,omitempty"`
+}
+
+func (p *SpectatePayload) Validate() error {
+	if p.Room == "" && p.Code == "" && p.Invite == "" {
+		return nil
+	}
+	room := JoinRoomPayload{Room: p.Room, Code: p.Code, Invite: p.Invite}
+	if err := room.Validate(); err != nil {
+		return err
+	}
+	p.Room, p.Code, p.Invite = room.Room, room.Code, room.Invite
+	return nil
+}
+
+// ChatPayload is a chat message. Clients send only Text; the server fills in
+// the sender and the time when it delivers the message.
+type ChatPayload struct {
+	Player string    `json:"player,omitempty"`
+	Text   string    `json:"text"`
+	SentAt time.Time `json:"sent_at,omitempty"`
+}
+
+func (p *ChatPayload) Validate() error {
+	p.Text = strings.TrimSpace(p.Text)
+	if p.Text == "" {
+		return errors.New("text is required")
+	}
+	if utf8.RuneCountInString(p.Text) > maxChatLength {
+		return fmt.Errorf("text must be at most %d characters", maxChatLength)
+	}
+	return nil
+}
+
+// MutePayload silences a player in chat for Minutes, or for the server's
+// default when Minutes is zero.
+type MutePayload struct {
+	Player  string `json:"player"`
+	Minutes int    `json:"minutes,omitempty"`
+}
+
+func (p *MutePayload) Validate() error {
+	p.Player = strings.TrimSpace(p.Player)
+	if p.Player == "" {
+		return errors.New("player is required")
+	}
+	if p.Minutes < 0 || p.Minutes > maxMuteMinutes {
+		return fmt.Errorf("minutes must be between 0 and %d", maxMuteMinutes)
+	}
+	return nil
+}
+
+type KickPayload struct {
+	Player string `json:"player"`
+}
+
+func (p *KickPayload) Validate() error {
+	p.Player = strings.TrimSpace(p.Player)
+	if p.Player == "" {
+		return errors.New("player is required")
+	}
+	return nil
+}
+
+// PlayerSummary is a player as others see them. Scrambled, the word the
+// player is working on, is only sent to spectators.
+type PlayerSummary struct {
+	Name      string `json:"name"`
+	Score     int    `json:"score"`
+	Team      string `json:"team,omitempty"`
+	Scrambled string `json:"scrambled,omitempty"`
+}
+
+// PlayerListPayload lists every player, and the same players again grouped
+// by team with each team's score.
+type PlayerListPayload struct {
+	Players []PlayerSummary `json:"players"`
+	Teams   []TeamSummary   `json:"teams"`
+	// Spectators is how many clients are watching the game.
+	Spectators int `json:"spectators"`
+}
+
+type TeamSummary struct {
+	Name    string          `json:"name"`
+	Score   int             `json:"score"`
+	Players []PlayerSummary `json:"players"`
+}
+
+type StartGamePayload struct {
+	Word string `json:"word"`
+	Mode string `json:"mode"`
+}
+
+type ScoreEntry struct {
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+	Team   string `json:"team,omitempty"`
+}
+
+// TeamScore is a team's score with the scores of its players.
+type TeamScore struct {
+	Name    string       `json:"name"`
+	Points  int          `json:"points"`
+	Players []ScoreEntry `json:"players"`
+}
+
+// GuessResultPayload answers a guess. Winner is set when the guess won the
+// game, and WinningTeam too when it won a team game.
+type GuessResultPayload struct {
+	Correct     bool          `json:"correct"`
+	Message     string        `json:"message"`
+	Player      PlayerSummary `json:"player"`
+	NewWord     string        `json:"new_word,omitempty"`
+	Scores      []ScoreEntry  `json:"scores"`
+	Teams       []TeamScore   `json:"teams"`
+	Winner      string        `json:"winner,omitempty"`
+	WinningTeam string        `json:"winning_team,omitempty"`
+}
+
+type SkippedPayload struct {
+	NewWord string `json:"new_word"`
+}
+
+type LeftPayload struct {
+	Message string `json:"message"`
+}
+
+type SpectatingPayload struct {
+	Message string `json:"message"`
+	// Room is the room whose game is watched, empty for the lobby game.
+	Room string `json:"room,omitempty"`
+}
+
+// QueueStatusPayload tells a queued player where they stand. Window is how
+// far from their rating the ratings of the players they may be matched with
+// can be by now.
+type QueueStatusPayload struct {
+	Position int    `json:"position"`
+	Queued   int    `json:"queued"`
+	Mode     string `json:"mode"`
+	Rating   int    `json:"rating"`
+	Window   int    `json:"window"`
+}
+
+// MatchFoundPayload tells a queued player the room they were put in and who
+// they will play with.
+type MatchFoundPayload struct {
+	Room    string        `json:"room"`
+	Mode    string        `json:"mode"`
+	Players []RatedPlayer `json:"players"`
+}
+
+type RatedPlayer struct {
+	Name   string `json:"name"`
+	Rating int    `json:"rating"`
+}
+
+// RoomPayload describes the room the player is in. Only the host of a
+// private room is sent its join code and when the code expires; both are
+// empty once the code is revoked.
+type RoomPayload struct {
+	Room          string     `json:"room"`
+	Name          string     `json:"name"`
+	Host          string     `json:"host"`
+	Mode          string     `json:"mode"`
+	Private       bool       `json:"private"`
+	Capacity      int        `json:"capacity"`
+	Players       []string   `json:"players"`
+	Code          string     `json:"code,omitempty"`
+	CodeExpiresAt *time.Time `json:"code_expires_at,omitempty"`
+}
+
+// RoomListPayload lists the public rooms that have space.
+type RoomListPayload struct {
+	Rooms []RoomSummary `json:"rooms"`
+}
+
+type RoomSummary struct {
+	Room     string `json:"room"`
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Mode     string `json:"mode"`
+	Players  int    `json:"players"`
+	Capacity int    `json:"capacity"`
+}
+
+// InviteCreatedPayload is an invite to a room. Link is empty when the
+// server makes no invite links.
+type InviteCreatedPayload struct {
+	Room      string    `json:"room"`
+	Token     string    `json:"token"`
+	Link      string    `json:"link,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ChatHistoryPayload struct {
+	Messages []ChatPayload `json:"messages"`
+}
+
+// ModerationPayload announces that By muted or kicked Player until Until.
+type ModerationPayload struct {
+	Action string    `json:"action"`
+	Player string    `json:"player"`
+	By     string    `json:"by"`
+	Until  time.Time `json:"until"`
+}
+
+// WordSolvedPayload announces a solved word without giving the word away.
+// In a team game it also carries the player's team and its new score.
+type WordSolvedPayload struct {
+	Player    string `json:"player"`
+	Score     int    `json:"score"`
+	Team      string `json:"team,omitempty"`
+	TeamScore int    `json:"team_score,omitempty"`
+}
+
+// RoundResultPayload ends a race round. Word is the word that was solved and
+// NextWord, scrambled, is everyone's word for the next round; it is empty
+// when the round won the game. Players lists how everyone racing did in the
+// round, and Times every round of the game so far.
+type RoundResultPayload struct {
+	Round    int          `json:"round"`
+	Word     string       `json:"word"`
+	Winner   string       `json:"winner"`
+	SolveMS  int64        `json:"solve_ms"`
+	NextWord string       `json:"next_word,omitempty"`
+	Players  []PlayerTime `json:"players"`
+	Times    []RoundTime  `json:"times"`
+}
+
+// RoundTime is how long the winner of a race round took to solve it, and
+// how everyone racing did.
+type RoundTime struct {
+	Round   int          `json:"round"`
+	Player  string       `json:"player"`
+	SolveMS int64        `json:"solve_ms"`
+	Players []PlayerTime `json:"players"`
+}
+
+// PlayerTime is whether a player solved the word of a race round, and if so
+// how long they took. Only the round's winner solves it.
+type PlayerTime struct {
+	Player  string `json:"player"`
+	Solved  bool   `json:"solved"`
+	SolveMS int64  `json:"solve_ms,omitempty"`
+}
+
+// GameOverPayload announces the winner. Team is the winning team of a team
+// game, and Winner then the player who scored the last point.
+type GameOverPayload struct {
+	Winner  string `json:"winner"`
+	Team    string `json:"team,omitempty"`
+	Message string `json:"message"`
+}
+
+type ServerDrainingPayload struct {
+	Message   string `json:"message"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ErrorPayload) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewMessage wraps a payload in a message stamped with the protocol version.
+func NewMessage(msgType string, payload interface{}) Message {
+	return Message{Version: ProtocolVersion, Type: msgType, Payload: payload}
+}
+
+// NewReply is a message answering the client request with the given id.
+func NewReply(id, msgType string, payload interface{}) Message {
+	msg := NewMessage(msgType, payload)
+	msg.ID = id
+	return msg
+}
+
+func NewError(id string, e *ErrorPayload) Message {
+	return NewReply(id, TypeError, *e)
+}
+
+// payloadFor returns an empty payload value for an incoming message type, or
+// nil if the type is not one clients may send.
+func payloadFor(msgType string) interface{ Validate() error } {
+	switch msgType {
+	case TypeRegister:
+		return &RegisterPayload{}
+	case TypeStartGame:
+		return &StartGameRequest{}
+	case TypeSubmitGuess:
+		return &SubmitGuessPayload{}
+	case TypeSkip:
+		return &SkipPayload{}
+	case TypeLeave:
+		return &LeavePayload{}
+	case TypeSpectate:
+		return &SpectatePayload{}
+	case TypeChat:
+		return &ChatPayload{}
+	case TypeMute:
+		return &MutePayload{}
+	case TypeKick:
+		return &KickPayload{}
+	case TypeTeam:
+		return &TeamPayload{}
+	case TypeQueue:
+		return &QueuePayload{}
+	case TypeLeaveQueue:
+		return &LeaveQueuePayload{}
+	case TypeCreateRoom:
+		return &CreateRoomPayload{}
+	case TypeListRooms:
+		return &ListRoomsPayload{}
+	case TypeJoinRoom:
+		return &JoinRoomPayload{}
+	case TypeInvite:
+		return &InvitePayload{}
+	case TypeRevokeCode:
+		return &RevokeCodePayload{}
+	case TypeLeaveRoom:
+		return &LeaveRoomPayload{}
+	}
+	return nil
+}
+
+// DecodeMessage parses and validates an incoming message. On success it
+// returns the request with a pointer to its typed payload. Otherwise the
+// returned ErrorPayload describes what was wrong and should be sent back; the
+// request is still returned when enough of it could be read to know its id.
+func DecodeMessage(data []byte) (*Request, *ErrorPayload) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, &ErrorPayload{Code: ErrCodeBadMessage, Message: "message is not valid JSON"}
+	}
+	req := &Request{ID: env.ID, Type: env.Type}
+	if len(env.ID) > maxRequestIDLen {
+		req.ID = ""
+		return req, &ErrorPayload{
+			Code:    ErrCodeBadMessage,
+			Message: fmt.Sprintf("id must be at most %d characters", maxRequestIDLen),
+		}
+	}
+	if env.Version == 0 {
+		env.Version = 1
+	}
+	if env.Version != ProtocolVersion {
+		return req, &ErrorPayload{
+			Code:    ErrCodeUnsupportedVersion,
+			Message: fmt.Sprintf("protocol version %d is not supported, use %d", env.Version, ProtocolVersion),
+		}
+	}
+
+	payload := payloadFor(env.Type)
+	if payload == nil {
+		return req, &ErrorPayload{
+			Code:    ErrCodeUnknownType,
+			Message: fmt.Sprintf("unknown message type %q", env.Type),
+		}
+	}
+	if len(env.Payload) == 0 || string(env.Payload) == "null" {
+		env.Payload = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(env.Payload, payload); err != nil {
+		return req, &ErrorPayload{Code: ErrCodeInvalidPayload, Message: "payload does not match message type"}
+	}
+	if err := payload.Validate(); err != nil {
+		return req, &ErrorPayload{Code: ErrCodeInvalidPayload, Message: err.Error()}
+	}
+	req.Payload = payload
+	return req, nil
+}
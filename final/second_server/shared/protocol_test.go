@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeMessage_Register(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"v":1,"type":"register","payload":{"username":" kal "}}`))
+	assert.Nil(t, msgErr)
+	assert.Equal(t, TypeRegister, req.Type)
+	assert.Equal(t, "kal", req.Payload.(*RegisterPayload).Username)
+}
+
+func TestDecodeMessage_DefaultsToVersionOne(t *testing.T) {
+	_, msgErr := DecodeMessage([]byte(`{"type":"register","payload":{"username":"kal"}}`))
+	assert.Nil(t, msgErr)
+}
+
+func TestDecodeMessage_SubmitGuessKeepsRequestID(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"id":"42","type":"submit_guess","payload":{"guess":"apple"}}`))
+	assert.Nil(t, msgErr)
+	assert.Equal(t, "42", req.ID)
+	assert.Equal(t, "apple", req.Payload.(*SubmitGuessPayload).Guess)
+}
+
+func TestDecodeMessage_PayloadOptionalForStartGame(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"id":"1","type":"start_game"}`))
+	assert.Nil(t, msgErr)
+	assert.Equal(t, TypeStartGame, req.Type)
+	assert.Empty(t, req.Payload.(*StartGameRequest).Mode)
+}
+
+func TestDecodeMessage_StartGameMode(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"type":"start_game","payload":{"mode":"race"}}`))
+	assert.Nil(t, msgErr)
+	assert.Equal(t, ModeRace, req.Payload.(*StartGameRequest).Mode)
+}
+
+func TestDecodeMessage_Spectate(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"id":"3","type":"spectate"}`))
+	assert.Nil(t, msgErr)
+	assert.Equal(t, TypeSpectate, req.Type)
+}
+
+func TestDecodeMessage_JoinRoomNormalisesTheCode(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"type":"join_room","payload":{"code":" abc234 "}}`))
+	assert.Nil(t, msgErr)
+	assert.Equal(t, "ABC234", req.Payload.(*JoinRoomPayload).Code)
+}
+
+func TestDecodeMessage_ErrorKeepsRequestID(t *testing.T) {
+	req, msgErr := DecodeMessage([]byte(`{"id":"7","type":"submit_guess","payload":{"guess":""}}`))
+	if assert.NotNil(t, msgErr) {
+		assert.Equal(t, ErrCodeInvalidPayload, msgErr.Code)
+	}
+	assert.Equal(t, "7", req.ID)
+}
+
+func TestDecodeMessage_Rejects(t *testing.T) {
+	cases := map[string]struct {
+		data string
+		code string
+	}{
+		"malformed json":      {`{"type":`, ErrCodeBadMessage},
+		"future version":      {`{"v":2,"type":"register","payload":{"username":"kal"}}`, ErrCodeUnsupportedVersion},
+		"unknown type":        {`{"type":"dance","payload":{"moves":3}}`, ErrCodeUnknownType},
+		"server-only type":    {`{"type":"game_over","payload":{"winner":"kal"}}`, ErrCodeUnknownType},
+		"long request id":     {`{"id":"` + strings.Repeat("x", 65) + `","type":"skip"}`, ErrCodeBadMessage},
+		"missing username":    {`{"type":"register"}`, ErrCodeInvalidPayload},
+		"wrong payload shape": {`{"type":"register","payload":"kal"}`, ErrCodeInvalidPayload},
+		"wrong field type":    {`{"type":"register","payload":{"username":42}}`, ErrCodeInvalidPayload},
+		"empty username":      {`{"type":"register","payload":{"username":"  "}}`, ErrCodeInvalidPayload},
+		"empty chat":          {`{"type":"chat","payload":{"text":" "}}`, ErrCodeInvalidPayload},
+		"long chat":           {`{"type":"chat","payload":{"text":"` + strings.Repeat("é", 201) + `"}}`, ErrCodeInvalidPayload},
+		"mute without player": {`{"type":"mute","payload":{"minutes":5}}`, ErrCodeInvalidPayload},
+		"mute for too long":   {`{"type":"mute","payload":{"player":"kal","minutes":100000}}`, ErrCodeInvalidPayload},
+		"unknown mode":        {`{"type":"start_game","payload":{"mode":"blitz"}}`, ErrCodeInvalidPayload},
+		"unknown team":        {`{"type":"team","payload":{"team":"green"}}`, ErrCodeInvalidPayload},
+		"register on no team": {`{"type":"register","payload":{"username":"kal","team":"green"}}`, ErrCodeInvalidPayload},
+		"queue for no mode":   {`{"type":"queue","payload":{"mode":"blitz"}}`, ErrCodeInvalidPayload},
+		"room for no mode":    {`{"type":"create_room","payload":{"mode":"blitz"}}`, ErrCodeInvalidPayload},
+		"negative capacity":   {`{"type":"create_room","payload":{"capacity":-1}}`, ErrCodeInvalidPayload},
+		"join no room":        {`{"type":"join_room","payload":{}}`, ErrCodeInvalidPayload},
+		"join two ways":       {`{"type":"join_room","payload":{"code":"ABC234","invite":"x"}}`, ErrCodeInvalidPayload},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, msgErr := DecodeMessage([]byte(tc.data))
+			if assert.NotNil(t, msgErr) {
+				assert.Equal(t, tc.code, msgErr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"second_server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryUserStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryUserStore()
+
+	kal := &models.User{Username: "kal", Email: "kal@example.com"}
+	require.NoError(t, s.Create(ctx, kal))
+	assert.ErrorIs(t, s.Create(ctx, &models.User{Username: "other", Email: "kal@example.com"}), ErrEmailTaken)
+	require.NoError(t, s.Create(ctx, &models.User{Username: "sara", Email: "sara@example.com"}))
+
+	_, err := s.FindByID(ctx, "not-an-id")
+	assert.ErrorIs(t, err, ErrInvalidID)
+	_, err = s.FindByUsername(ctx, "nobody")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.SetWordAndScore(ctx, kal.ID.Hex(), "apple", 1))
+	score, err := s.SolveWord(ctx, kal.ID.Hex(), "apple", "grape")
+	require.NoError(t, err)
+	assert.Equal(t, 2, score)
+	_, err = s.SolveWord(ctx, kal.ID.Hex(), "apple", "cherry")
+	assert.ErrorIs(t, err, ErrWordChanged, "a word scores once")
+	found, err := s.FindByEmail(ctx, "kal@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "grape", found.Word)
+	assert.Equal(t, 2, found.Score)
+
+	match := &models.Match{WinnerID: kal.ID.Hex(), Winner: "kal"}
+	require.NoError(t, s.RecordWin(ctx, match))
+	require.NoError(t, s.RecordWin(ctx, match), "recording the same match again is a no-op")
+	assert.ErrorIs(t, s.RecordWin(ctx, &models.Match{WinnerID: primitive.NewObjectID().Hex()}), ErrNotFound)
+
+	leaders, err := s.Leaderboard(ctx)
+	require.NoError(t, err)
+	require.Len(t, leaders, 2)
+	assert.Equal(t, "kal", leaders[0].Username)
+	assert.Equal(t, 1, leaders[0].Wins)
+}
+
+func TestRecordWinRatesPlayersAndKeepsRecentHistory(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryUserStore()
+	kal := &models.User{Username: "kal", Email: "kal@example.com"}
+	sara := &models.User{Username: "sara", Email: "sara@example.com"}
+	require.NoError(t, s.Create(ctx, kal))
+	require.NoError(t, s.Create(ctx, sara))
+
+	for i := range ratingHistoryLimit + 1 {
+		require.NoError(t, s.RecordWin(ctx, &models.Match{
+			WinnerID: kal.ID.Hex(),
+			Players: []models.MatchPlayer{
+				{ID: kal.ID.Hex(), Score: 3, Rating: 1001 + i, RatingChange: 1},
+				{ID: sara.ID.Hex(), Rating: 999 - i, RatingChange: -1},
+			},
+		}))
+	}
+
+	found, err := s.FindByID(ctx, kal.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 1001+ratingHistoryLimit, found.Rating)
+	require.Len(t, found.RatingHistory, ratingHistoryLimit, "only the most recent changes are kept")
+	assert.Equal(t, 1002, found.RatingHistory[0].Rating)
+	assert.Equal(t, 1, found.RatingHistory[0].Change)
+	assert.NotEmpty(t, found.RatingHistory[0].MatchID)
+
+	found, err = s.FindByID(ctx, sara.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 999-ratingHistoryLimit, found.Rating)
+}
+
+func TestMemoryGameStateStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryGameStateStore()
+
+	_, err := s.Load(ctx)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	gameState := &models.GameState{Word: "apple", Players: []models.Player{{ID: "1", Name: "ignored"}}}
+	require.NoError(t, s.Save(ctx, gameState))
+	assert.Equal(t, int64(1), gameState.Revision)
+
+	require.NoError(t, s.SavePlayer(ctx, models.Player{ID: "2", Name: "sara"}))
+	require.NoError(t, s.SavePlayer(ctx, models.Player{ID: "1", Name: "kal", Score: 1}))
+	require.NoError(t, s.RemovePlayer(ctx, models.Player{ID: "2"}))
+
+	loaded, err := s.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "apple", loaded.Word)
+	assert.Equal(t, []models.Player{{ID: "1", Name: "kal", Score: 1}}, loaded.Players)
+}
+
+func TestMemoryEventBusDeliversToSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := NewMemoryEventBus()
+	received := make(chan string, 1)
+	go bus.Subscribe(ctx, func(event []byte) { received <- string(event) })
+
+	require.Eventually(t, func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		return len(bus.subscribers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, bus.Publish(ctx, []byte("hello")))
+	assert.Equal(t, "hello", <-received)
+}
@@ -0,0 +1,479 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"second_server/models"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrQueueFull is returned for a write that could not be buffered because
+// too many writes are already waiting for their store to come back.
+var ErrQueueFull = errors.New("write-behind queue is full")
+
+const (
+	// DefaultMaxPendingWrites bounds the memory used while a store is down.
+	DefaultMaxPendingWrites = 10000
+
+	retryMinBackoff = 500 * time.Millisecond
+	retryMaxBackoff = 30 * time.Second
+	flushTimeout    = 5 * time.Second
+)
+
+// pendingWrite is a write waiting for its store to come back. Writes with
+// the same key overwrite the same data, so a newer one replaces an older one
+// still in the queue. Writes without a key, such as increments, are never
+// replaced.
+type pendingWrite struct {
+	key   string
+	write func(ctx context.Context) error
+}
+
+// WriteBehind keeps a game server playable while Redis or MongoDB is down.
+// Writes that fail because the store cannot be reached are queued in memory,
+// in order, and Run retries them with backoff until the store takes them
+// again. Once something is queued, later writes join the queue instead of
+// overtaking it.
+type WriteBehind struct {
+	mu      sync.Mutex
+	pending []*pendingWrite
+	max     int
+	wake    chan struct{}
+}
+
+func NewWriteBehind(maxPending int) *WriteBehind {
+	return &WriteBehind{max: maxPending, wake: make(chan struct{}, 1)}
+}
+
+// Wrap returns stores whose game state, user and event log writes go
+// through the queue. Reads and the event bus are left alone.
+func (q *WriteBehind) Wrap(stores Stores) Stores {
+	stores.GameState = &writeBehindGameState{GameStateStore: stores.GameState, q: q}
+	stores.Users = &writeBehindUsers{UserStore: stores.Users, q: q, known: make(map[string]models.User)}
+	stores.EventLog = &writeBehindEventLog{EventLog: stores.EventLog, q: q}
+	stores.Queue = q
+	return stores
+}
+
+// Pending returns how many writes are waiting for their store.
+func (q *WriteBehind) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Full reports whether new writes are being turned away.
+func (q *WriteBehind) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) >= q.max
+}
+
+// retryable tells a store that cannot be reached, which may take the write
+// once it is back, from a write the store refused, which would fail again no
+// matter how often it is retried. Only network failures and timeouts are
+// retried; everything else goes back to the caller.
+func retryable(err error) bool {
+	var netErr net.Error
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) ||
+		errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, redis.ErrClosed) || isRedisPoolError(err)
+}
+
+// isRedisPoolError reports whether go-redis had no connection to give out,
+// which it does not export an error for.
+func isRedisPoolError(err error) bool {
+	return strings.HasPrefix(err.Error(), "redis: connection pool")
+}
+
+// do runs write straight away unless writes are already queued, and queues
+// retry if the store cannot be reached. retry is what gets replayed later;
+// it differs from write when the write would otherwise refer to memory the
+// caller keeps changing.
+func (q *WriteBehind) do(ctx context.Context, key string, write, retry func(context.Context) error) error {
+	if q.Pending() == 0 {
+		err := write(ctx)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		slog.Warn("Store unavailable, buffering write", "error", err)
+	}
+	return q.enqueue(&pendingWrite{key: key, write: retry})
+}
+
+func (q *WriteBehind) enqueue(w *pendingWrite) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if w.key != "" {
+		for i, queued := range q.pending {
+			if queued.key == w.key {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(q.pending) >= q.max {
+		return fmt.Errorf("%w: %d writes pending", ErrQueueFull, len(q.pending))
+	}
+	q.pending = append(q.pending, w)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// remove drops w from the queue unless a newer write already replaced it.
+func (q *WriteBehind) remove(w *pendingWrite) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.pending {
+		if queued == w {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *WriteBehind) head() *pendingWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil
+	}
+	return q.pending[0]
+}
+
+// Flush replays the queued writes in order until the queue is empty or one
+// of them fails because its store is still unreachable. Writes the store
+// refuses are logged and dropped.
+func (q *WriteBehind) Flush(ctx context.Context) error {
+	flushed := 0
+	for w := q.head(); w != nil; w = q.head() {
+		writeCtx, cancel := context.WithTimeout(ctx, flushTimeout)
+		err := w.write(writeCtx)
+		cancel()
+		if err != nil && retryable(err) {
+			return err
+		}
+		if err != nil {
+			slog.Error("Dropping buffered write refused by the store", "error", err)
+		}
+		q.remove(w)
+		flushed++
+	}
+	if flushed > 0 {
+		slog.Info("Store recovered, buffered writes flushed", "writes", flushed)
+	}
+	return nil
+}
+
+// Run flushes the queue whenever writes are buffered, backing off between
+// attempts while the store stays down, until ctx is done.
+func (q *WriteBehind) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+
+		backoff := retryMinBackoff
+		for {
+			err := q.Flush(ctx)
+			if err == nil {
+				break
+			}
+			slog.Warn("Store still unavailable, retrying buffered writes",
+				"pending", q.Pending(), "retry_in", backoff, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, retryMaxBackoff)
+		}
+	}
+}
+
+// writeBehindGameState buffers the game state writes.
+type writeBehindGameState struct {
+	GameStateStore
+	q *WriteBehind
+
+	mu sync.Mutex
+	// teamScores holds the last scores the store returned for the teams of
+	// the match that started at teamMatch, to count on from while it is down.
+	teamMatch  time.Time
+	teamScores map[string]int
+	// wonMatch is the start of the last team match claimed from memory.
+	wonMatch time.Time
+}
+
+func (s *writeBehindGameState) Save(ctx context.Context, gameState *models.GameState) error {
+	// The caller keeps changing gameState, so a buffered save replays a copy
+	// of it as it is now.
+	snapshot := cloneGameState(gameState)
+	return s.q.do(ctx, "game_state",
+		func(ctx context.Context) error { return s.GameStateStore.Save(ctx, gameState) },
+		func(ctx context.Context) error { return s.GameStateStore.Save(ctx, snapshot) },
+	)
+}
+
+func (s *writeBehindGameState) SavePlayer(ctx context.Context, player models.Player) error {
+	write := func(ctx context.Context) error { return s.GameStateStore.SavePlayer(ctx, player) }
+	return s.q.do(ctx, playerKey(player), write, write)
+}
+
+func (s *writeBehindGameState) RemovePlayer(ctx context.Context, player models.Player) error {
+	write := func(ctx context.Context) error { return s.GameStateStore.RemovePlayer(ctx, player) }
+	return s.q.do(ctx, playerKey(player), write, write)
+}
+
+// ClaimRound cannot be buffered, since the answer is needed straight away.
+// While the store is down the round goes to whoever claims it first on this
+// server, so two servers may both award it until the store returns.
+func (s *writeBehindGameState) ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error) {
+	claimed, err := s.GameStateStore.ClaimRound(ctx, matchStart, round, playerID)
+	if err != nil && retryable(err) {
+		slog.Warn("Store unavailable, claiming round from memory", "error", err)
+		return true, nil
+	}
+	return claimed, err
+}
+
+// AddTeamPoint cannot be buffered either. While the store is down points
+// are counted on from the last score it returned, so points scored on other
+// servers meanwhile are missed until it returns.
+func (s *writeBehindGameState) AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error) {
+	score, err := s.GameStateStore.AddTeamPoint(ctx, matchStart, team)
+	if err != nil && !retryable(err) {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.teamMatch.Equal(matchStart) {
+		s.teamMatch = matchStart
+		s.teamScores = make(map[string]int)
+	}
+	if err != nil {
+		slog.Warn("Store unavailable, counting team point in memory", "error", err)
+		score = s.teamScores[team] + 1
+	}
+	s.teamScores[team] = score
+	return score, nil
+}
+
+// ClaimTeamWin cannot be buffered either. While the store is down the win
+// goes to the first team to claim it on this server, so two servers may
+// both end the match until the store returns.
+func (s *writeBehindGameState) ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error) {
+	claimed, err := s.GameStateStore.ClaimTeamWin(ctx, matchStart, team)
+	if err == nil || !retryable(err) {
+		return claimed, err
+	}
+
+	slog.Warn("Store unavailable, claiming team win from memory", "error", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wonMatch.Equal(matchStart) {
+		return false, nil
+	}
+	s.wonMatch = matchStart
+	return true, nil
+}
+
+func playerKey(player models.Player) string {
+	if player.ID != "" {
+		return "player:" + player.ID
+	}
+	return "player:name:" + player.Name
+}
+
+func cloneGameState(gameState *models.GameState) *models.GameState {
+	clone := *gameState
+	clone.Players = append([]models.Player(nil), gameState.Players...)
+	clone.Solved = append([]models.SolvedWord(nil), gameState.Solved...)
+	clone.Rounds = append([]models.RoundResult(nil), gameState.Rounds...)
+	clone.TeamScores = maps.Clone(gameState.TeamScores)
+	if gameState.Winner != nil {
+		winner := *gameState.Winner
+		clone.Winner = &winner
+	}
+	return &clone
+}
+
+// writeBehindUsers buffers the per-game user writes. It also remembers every
+// user it has seen with the buffered writes applied, so that while writes
+// are pending players are served what they last wrote rather than what the
+// store still holds, or nothing at all when it is down.
+type writeBehindUsers struct {
+	UserStore
+	q *WriteBehind
+
+	mu    sync.Mutex
+	known map[string]models.User
+}
+
+func (s *writeBehindUsers) FindByID(ctx context.Context, id string) (*models.User, error) {
+	if s.q.Pending() > 0 {
+		s.mu.Lock()
+		user, ok := s.known[id]
+		s.mu.Unlock()
+		if ok {
+			return &user, nil
+		}
+	}
+
+	user, err := s.UserStore.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.known[id] = *user
+	s.mu.Unlock()
+	return user, nil
+}
+
+// update writes a user and, once the write is stored or buffered, applies
+// change to the remembered user.
+func (s *writeBehindUsers) update(ctx context.Context, key, id string, change func(*models.User), write func(context.Context) error) error {
+	if key != "" {
+		key += ":" + id
+	}
+	if err := s.q.do(ctx, key, write, write); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if user, ok := s.known[id]; ok {
+		change(&user)
+		s.known[id] = user
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *writeBehindUsers) SetWord(ctx context.Context, id, word string) error {
+	return s.update(ctx, "word", id,
+		func(u *models.User) { u.Word = word },
+		func(ctx context.Context) error { return s.UserStore.SetWord(ctx, id, word) },
+	)
+}
+
+func (s *writeBehindUsers) SetScore(ctx context.Context, id string, score int) error {
+	return s.update(ctx, "score", id,
+		func(u *models.User) { u.Score = score },
+		func(ctx context.Context) error { return s.UserStore.SetScore(ctx, id, score) },
+	)
+}
+
+func (s *writeBehindUsers) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	return s.update(ctx, "word_score", id,
+		func(u *models.User) { u.Word, u.Score = word, score },
+		func(ctx context.Context) error { return s.UserStore.SetWordAndScore(ctx, id, word, score) },
+	)
+}
+
+// SolveWord cannot be buffered as it is, since it must read the word it
+// checks. While the store is down the remembered user is checked instead and
+// the result written as plain values, so points are no longer protected
+// against two servers solving the same word until the store returns.
+func (s *writeBehindUsers) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	if s.q.Pending() == 0 {
+		score, err := s.UserStore.SolveWord(ctx, id, word, newWord)
+		if err == nil {
+			s.mu.Lock()
+			if user, ok := s.known[id]; ok {
+				user.Word, user.Score = newWord, score
+				s.known[id] = user
+			}
+			s.mu.Unlock()
+		}
+		if err == nil || !retryable(err) {
+			return score, err
+		}
+		slog.Warn("Store unavailable, solving word from memory", "error", err)
+	}
+
+	s.mu.Lock()
+	user, ok := s.known[id]
+	s.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("user %s is not in memory while the store is down", id)
+	}
+	if user.Word != word {
+		return 0, ErrWordChanged
+	}
+	score := user.Score + 1
+	return score, s.SetWordAndScore(ctx, id, newWord, score)
+}
+
+func (s *writeBehindUsers) RecordWin(ctx context.Context, match *models.Match) error {
+	// The match is replayed as it is now, ID included, so that a retry of a
+	// win that was stored after all is recognised. The ID is assigned up
+	// front for the rating history to refer to.
+	if match.ID.IsZero() {
+		match.ID = primitive.NewObjectID()
+	}
+	snapshot := *match
+	snapshot.Players = append([]models.MatchPlayer(nil), match.Players...)
+	snapshot.Words = append([]models.SolvedWord(nil), match.Words...)
+	snapshot.Teams = append([]models.MatchTeam(nil), match.Teams...)
+
+	write := func(ctx context.Context) error { return s.UserStore.RecordWin(ctx, &snapshot) }
+	if err := s.q.do(ctx, "", write, write); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, id := range match.WinnerIDs() {
+		if user, ok := s.known[id]; ok {
+			user.Wins++
+			s.known[id] = user
+		}
+	}
+	for id, change := range match.RatingChanges() {
+		if user, ok := s.known[id]; ok {
+			user.Rating = change.Rating
+			user.RatingHistory = appendRatingChange(user.RatingHistory, change)
+			s.known[id] = user
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// writeBehindEventLog buffers appends to the event log.
+type writeBehindEventLog struct {
+	EventLog
+	q *WriteBehind
+}
+
+func (l *writeBehindEventLog) Append(ctx context.Context, event *models.GameEvent) error {
+	// The ID is assigned up front so that a retried append of an event that
+	// was logged after all is recognised.
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	snapshot := *event
+	write := func(ctx context.Context) error { return l.EventLog.Append(ctx, &snapshot) }
+	return l.q.do(ctx, "", write, write)
+}
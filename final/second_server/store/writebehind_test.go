@@ -0,0 +1,262 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"second_server/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+// outage makes the wrapped stores fail every call while it is down, with
+// cause if it is set and otherwise as if the store could not be reached.
+type outage struct {
+	mu    sync.Mutex
+	down  bool
+	cause error
+}
+
+func (o *outage) set(down bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.down = down
+}
+
+func (o *outage) err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.down && o.cause != nil {
+		return o.cause
+	}
+	if o.down {
+		return errDown
+	}
+	return nil
+}
+
+type flakyGameState struct {
+	GameStateStore
+	outage *outage
+}
+
+func (s flakyGameState) Save(ctx context.Context, gameState *models.GameState) error {
+	if err := s.outage.err(); err != nil {
+		return err
+	}
+	return s.GameStateStore.Save(ctx, gameState)
+}
+
+func (s flakyGameState) SavePlayer(ctx context.Context, player models.Player) error {
+	if err := s.outage.err(); err != nil {
+		return err
+	}
+	return s.GameStateStore.SavePlayer(ctx, player)
+}
+
+func (s flakyGameState) AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error) {
+	if err := s.outage.err(); err != nil {
+		return 0, err
+	}
+	return s.GameStateStore.AddTeamPoint(ctx, matchStart, team)
+}
+
+type flakyUsers struct {
+	*MemoryUserStore
+	outage *outage
+}
+
+func (s flakyUsers) FindByID(ctx context.Context, id string) (*models.User, error) {
+	if err := s.outage.err(); err != nil {
+		return nil, err
+	}
+	return s.MemoryUserStore.FindByID(ctx, id)
+}
+
+func (s flakyUsers) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	if err := s.outage.err(); err != nil {
+		return err
+	}
+	return s.MemoryUserStore.SetWordAndScore(ctx, id, word, score)
+}
+
+func (s flakyUsers) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	if err := s.outage.err(); err != nil {
+		return 0, err
+	}
+	return s.MemoryUserStore.SolveWord(ctx, id, word, newWord)
+}
+
+func (s flakyUsers) RecordWin(ctx context.Context, match *models.Match) error {
+	if err := s.outage.err(); err != nil {
+		return err
+	}
+	return s.MemoryUserStore.RecordWin(ctx, match)
+}
+
+func TestWriteBehindBuffersWritesUntilTheStoreReturns(t *testing.T) {
+	ctx := context.Background()
+	down := &outage{}
+	gameStates := NewMemoryGameStateStore()
+	userStore := NewMemoryUserStore()
+	kal := &models.User{Username: "kal", Email: "kal@example.com"}
+	require.NoError(t, userStore.Create(ctx, kal))
+	id := kal.ID.Hex()
+
+	queue := NewWriteBehind(10)
+	stores := queue.Wrap(Stores{
+		GameState: flakyGameState{GameStateStore: gameStates, outage: down},
+		Users:     flakyUsers{MemoryUserStore: userStore, outage: down},
+	})
+
+	// While the store is up writes go straight through.
+	_, err := stores.Users.FindByID(ctx, id)
+	require.NoError(t, err)
+	score, err := stores.Users.SolveWord(ctx, id, "", "apple")
+	require.NoError(t, err)
+	assert.Equal(t, 1, score)
+	assert.Zero(t, queue.Pending())
+
+	down.set(true)
+	gameState := &models.GameState{Word: "apple", Started: true}
+	require.NoError(t, stores.GameState.Save(ctx, gameState))
+	gameState.Word = "grape"
+	require.NoError(t, stores.GameState.Save(ctx, gameState), "a newer save replaces the queued one")
+	require.NoError(t, stores.GameState.SavePlayer(ctx, models.Player{ID: id, Name: "kal", Score: 2}))
+	score, err = stores.Users.SolveWord(ctx, id, "apple", "grape")
+	require.NoError(t, err)
+	assert.Equal(t, 2, score, "solved against the remembered word")
+	_, err = stores.Users.SolveWord(ctx, id, "apple", "cherry")
+	assert.ErrorIs(t, err, ErrWordChanged)
+	require.NoError(t, stores.Users.RecordWin(ctx, &models.Match{WinnerID: id}))
+	require.NoError(t, stores.Users.RecordWin(ctx, &models.Match{WinnerID: id}), "wins are never merged")
+	assert.Equal(t, 5, queue.Pending())
+
+	// Players keep seeing what they last wrote.
+	user, err := stores.Users.FindByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "grape", user.Word)
+	assert.Equal(t, 2, user.Score)
+	assert.Equal(t, 2, user.Wins)
+
+	assert.ErrorIs(t, queue.Flush(ctx), errDown)
+	assert.Equal(t, 5, queue.Pending())
+
+	down.set(false)
+	require.NoError(t, queue.Flush(ctx))
+	assert.Zero(t, queue.Pending())
+
+	saved, err := gameStates.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "grape", saved.Word)
+	assert.Equal(t, []models.Player{{ID: id, Name: "kal", Score: 2}}, saved.Players)
+
+	user, err = userStore.FindByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "grape", user.Word)
+	assert.Equal(t, 2, user.Score)
+	assert.Equal(t, 2, user.Wins)
+}
+
+func TestWriteBehindCountsTeamPointsWhileTheStoreIsDown(t *testing.T) {
+	ctx := context.Background()
+	down := &outage{}
+	stores := NewWriteBehind(10).Wrap(Stores{
+		GameState: flakyGameState{GameStateStore: NewMemoryGameStateStore(), outage: down},
+	})
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	score, err := stores.GameState.AddTeamPoint(ctx, start, "red")
+	require.NoError(t, err)
+	assert.Equal(t, 1, score)
+
+	down.set(true)
+	score, err = stores.GameState.AddTeamPoint(ctx, start, "red")
+	require.NoError(t, err)
+	assert.Equal(t, 2, score, "counted on from the last stored score")
+	score, err = stores.GameState.AddTeamPoint(ctx, start.Add(time.Hour), "red")
+	require.NoError(t, err)
+	assert.Equal(t, 1, score, "the next match starts from zero")
+}
+
+func TestWriteBehindRunRetriesInTheBackground(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	down := &outage{down: true}
+	gameStates := NewMemoryGameStateStore()
+	queue := NewWriteBehind(10)
+	stores := queue.Wrap(Stores{
+		GameState: flakyGameState{GameStateStore: gameStates, outage: down},
+		Users:     NewMemoryUserStore(),
+	})
+	go queue.Run(ctx)
+
+	require.NoError(t, stores.GameState.Save(ctx, &models.GameState{Word: "apple"}))
+	down.set(false)
+
+	require.Eventually(t, func() bool { return queue.Pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	saved, err := gameStates.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "apple", saved.Word)
+}
+
+func TestWriteBehindRefusesWritesWhenFull(t *testing.T) {
+	ctx := context.Background()
+	queue := NewWriteBehind(1)
+	stores := queue.Wrap(Stores{
+		GameState: flakyGameState{GameStateStore: NewMemoryGameStateStore(), outage: &outage{down: true}},
+		Users:     NewMemoryUserStore(),
+	})
+
+	require.NoError(t, stores.GameState.SavePlayer(ctx, models.Player{ID: "1"}))
+	require.NoError(t, stores.GameState.SavePlayer(ctx, models.Player{ID: "1", Score: 1}), "replacing a queued write takes no room")
+	assert.ErrorIs(t, stores.GameState.SavePlayer(ctx, models.Player{ID: "2"}), ErrQueueFull)
+	assert.True(t, queue.Full())
+}
+
+func TestWriteBehindDoesNotBufferRefusedWrites(t *testing.T) {
+	queue := NewWriteBehind(10)
+	stores := queue.Wrap(Stores{GameState: NewMemoryGameStateStore(), Users: NewMemoryUserStore()})
+
+	err := stores.Users.SetWord(context.Background(), "not-an-id", "apple")
+	assert.ErrorIs(t, err, ErrInvalidID)
+	assert.Zero(t, queue.Pending())
+}
+
+func TestWriteBehindReturnsStoreErrorsToTheCaller(t *testing.T) {
+	ctx := context.Background()
+	userStore := NewMemoryUserStore()
+	kal := &models.User{Username: "kal", Email: "kal@example.com"}
+	require.NoError(t, userStore.Create(ctx, kal))
+	id := kal.ID.Hex()
+
+	refused := errors.New("document failed validation")
+	queue := NewWriteBehind(10)
+	stores := queue.Wrap(Stores{
+		GameState: NewMemoryGameStateStore(),
+		Users:     flakyUsers{MemoryUserStore: userStore, outage: &outage{down: true, cause: refused}},
+	})
+	remembered := stores.Users.(*writeBehindUsers).known
+	remembered[id] = *kal
+
+	err := stores.Users.RecordWin(ctx, &models.Match{
+		WinnerID: id,
+		Players:  []models.MatchPlayer{{ID: id, Name: "kal", Rating: 1016, RatingChange: 16}},
+	})
+	assert.ErrorIs(t, err, refused)
+	assert.ErrorIs(t, stores.Users.SetWordAndScore(ctx, id, "apple", 3), refused)
+	assert.Zero(t, queue.Pending(), "only an unreachable store is waited for")
+
+	assert.Zero(t, remembered[id].Wins, "the refused win is not remembered")
+	assert.Zero(t, remembered[id].Rating)
+	assert.Empty(t, remembered[id].Word)
+}
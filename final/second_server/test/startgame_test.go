@@ -2,19 +2,33 @@ package test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"second_server/controllers"
+	"second_server/models"
+	"second_server/store"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// useMemoryStores points the controllers at fresh in-memory storage.
+func useMemoryStores(t *testing.T) store.Stores {
+	t.Helper()
+	stores := store.NewMemory()
+	controllers.Configure(stores)
+	controllers.LoadGameState()
+	return stores
+}
+
 func TestCheckMenu(t *testing.T) {
 	gin.SetMode(gin.TestMode)
+	useMemoryStores(t)
 
 	router := gin.Default()
 
@@ -27,7 +41,7 @@ func TestCheckMenu(t *testing.T) {
 	}
 	jsonValue, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("POST", "/check_menu", bytes.NewBuffer(jsonValue))
+	req, _ := http.NewRequest("POST", "/menu", bytes.NewBuffer(jsonValue))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 
@@ -42,16 +56,20 @@ func TestCheckMenu(t *testing.T) {
 func TestStartGame(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
+	stores := useMemoryStores(t)
+
 	router := gin.Default()
 	router.POST("/start", controllers.StartGame)
 
-	playerID := primitive.NewObjectID().Hex()
+	user := &models.User{Username: "kal", Email: "kal@example.com"}
+	require.NoError(t, stores.Users.Create(context.Background(), user))
+	playerID := user.ID.Hex()
 	payload := map[string]string{
 		"player_id": playerID,
 	}
 	jsonValue, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("POST", "/start_game", bytes.NewBuffer(jsonValue))
+	req, _ := http.NewRequest("POST", "/start", bytes.NewBuffer(jsonValue))
 	req.Header.Set("Content-Type", "application/json")
 	resp := httptest.NewRecorder()
 
@@ -0,0 +1,112 @@
+// Package tracing sets up OpenTelemetry tracing. Trace context travels
+// between the gateway and the game servers in W3C traceparent headers, so a
+// slow request can be followed from the gateway through the game server to
+// MongoDB and Redis.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"second_server/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExporterFactory builds a span exporter from the tracing config.
+type ExporterFactory func(cfg config.TracingConfig) (sdktrace.SpanExporter, error)
+
+var exporters = map[string]ExporterFactory{
+	"stdout": func(config.TracingConfig) (sdktrace.SpanExporter, error) {
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	},
+	"file": newFileExporter,
+}
+
+// RegisterExporter makes an exporter, e.g. an OTLP one, available as the
+// tracing.exporter setting. It must be called before Setup.
+func RegisterExporter(name string, factory ExporterFactory) {
+	exporters[name] = factory
+}
+
+// tracer creates every span, from whichever provider is installed at the
+// time.
+func tracer() trace.Tracer {
+	return otel.Tracer("scrambled_words")
+}
+
+// Setup installs the W3C trace context propagator and a tracer provider
+// sending spans to the configured exporter, naming this process service.
+// With the none exporter nothing is recorded, but incoming trace context is
+// still passed on. The returned function flushes any buffered spans.
+func Setup(cfg config.TracingConfig, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if cfg.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+	factory, ok := exporters[cfg.Exporter]
+	if !ok {
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	exporter, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create %s exporter: %w", cfg.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// fileExporter writes spans as JSON lines and closes the file on shutdown.
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func newFileExporter(cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileExporter{SpanExporter: exporter, file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Start starts a span as a child of the one in ctx, if any.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, opts...)
+}
+
+// End marks the span as failed if err is not nil and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
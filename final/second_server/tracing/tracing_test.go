@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"second_server/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddlewareContinuesIncomingTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(defaultProvider) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.POST("/submit", func(c *gin.Context) {
+		_, span := Start(c.Request.Context(), "mongo.FindOne")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/submit", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	}
+	assert.Equal(t, "mongo.FindOne", spans[0].Name())
+	assert.Equal(t, "POST /submit", spans[1].Name())
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].Parent().SpanID().String())
+}
+
+func TestFileExporter(t *testing.T) {
+	defaultProvider := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(defaultProvider) })
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(config.TracingConfig{Exporter: "file", File: path}, "second_server")
+	require.NoError(t, err)
+
+	_, span := Start(context.Background(), "redis.get")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"redis.get"`)
+	assert.Contains(t, string(data), "second_server")
+}
+
+func TestUnknownExporter(t *testing.T) {
+	_, err := Setup(config.TracingConfig{Exporter: "zipkin"}, "second_server")
+	assert.ErrorContains(t, err, "zipkin")
+}
@@ -0,0 +1,445 @@
+// Package config loads the settings shared by the gateway and the game
+// servers. Every process reads the same file, YAML or TOML, and individual
+// settings can be overridden with SCRAMBLED_* environment variables.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// PathEnv names the config file when no -config flag is given.
+const PathEnv = "SCRAMBLED_CONFIG"
+
+type Config struct {
+	Mongo       MongoConfig                 `yaml:"mongo" toml:"mongo"`
+	Redis       RedisConfig                 `yaml:"redis" toml:"redis"`
+	Gateway     GatewayConfig               `yaml:"gateway" toml:"gateway"`
+	GameServers map[string]GameServerConfig `yaml:"game_servers" toml:"game_servers"`
+	Chat        ChatConfig                  `yaml:"chat" toml:"chat"`
+	Matchmaking MatchmakingConfig           `yaml:"matchmaking" toml:"matchmaking"`
+	Rooms       RoomsConfig                 `yaml:"rooms" toml:"rooms"`
+	Teams       TeamsConfig                 `yaml:"teams" toml:"teams"`
+	Log         LogConfig                   `yaml:"log" toml:"log"`
+	Tracing     TracingConfig               `yaml:"tracing" toml:"tracing"`
+}
+
+type MongoConfig struct {
+	URI string `yaml:"uri" toml:"uri"`
+}
+
+type RedisConfig struct {
+	// Addr is the single Redis node used by the gateway.
+	Addr string `yaml:"addr" toml:"addr"`
+	// ClusterNodes seed the cluster client used by the game servers.
+	ClusterNodes []string `yaml:"cluster_nodes" toml:"cluster_nodes"`
+}
+
+type GatewayConfig struct {
+	Listen string `yaml:"listen" toml:"listen"`
+	// Backends are the game server URLs, in order of preference.
+	Backends    []string `yaml:"backends" toml:"backends"`
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins"`
+}
+
+type GameServerConfig struct {
+	Listen      string   `yaml:"listen" toml:"listen"`
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins"`
+	// MaxClients is how many players the server takes before it reports
+	// itself full. Zero means DefaultMaxClients.
+	MaxClients int `yaml:"max_clients" toml:"max_clients"`
+}
+
+const DefaultMaxClients = 500
+
+type ChatConfig struct {
+	// BannedWords are masked out of chat messages, ignoring case.
+	BannedWords []string `yaml:"banned_words" toml:"banned_words"`
+	// Admins are the usernames that may mute and kick any player.
+	Admins []string `yaml:"admins" toml:"admins"`
+}
+
+type MatchmakingConfig struct {
+	// RoomSize is how many players are put in each room.
+	RoomSize int `yaml:"room_size" toml:"room_size"`
+	// RatingWindow is how far apart the ratings of players put in the same
+	// room may be when they have just queued. It widens by WindowGrowth for
+	// every WidenSeconds they wait, up to MaxRatingWindow.
+	RatingWindow    int `yaml:"rating_window" toml:"rating_window"`
+	WindowGrowth    int `yaml:"window_growth" toml:"window_growth"`
+	WidenSeconds    int `yaml:"widen_seconds" toml:"widen_seconds"`
+	MaxRatingWindow int `yaml:"max_rating_window" toml:"max_rating_window"`
+}
+
+type RoomsConfig struct {
+	// DefaultCapacity is how many players a room takes when its host sets
+	// no capacity, and MaxCapacity the most a host may set.
+	DefaultCapacity int `yaml:"default_capacity" toml:"default_capacity"`
+	MaxCapacity     int `yaml:"max_capacity" toml:"max_capacity"`
+	// CodeTTLSeconds is how long the join code of a private room lasts, and
+	// InviteTTLSeconds how long an invite does.
+	CodeTTLSeconds   int `yaml:"code_ttl_seconds" toml:"code_ttl_seconds"`
+	InviteTTLSeconds int `yaml:"invite_ttl_seconds" toml:"invite_ttl_seconds"`
+	// InviteURL is put in front of invite tokens to make invite links. No
+	// links are made when it is empty.
+	InviteURL string `yaml:"invite_url" toml:"invite_url"`
+}
+
+type TeamsConfig struct {
+	// Target is how many points a team must score to win a team match.
+	Target int `yaml:"target" toml:"target"`
+}
+
+type LogConfig struct {
+	// Level is the lowest level written: debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+}
+
+type TracingConfig struct {
+	// Exporter is where finished spans go: none, stdout, file, or any
+	// exporter registered with tracing.RegisterExporter.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// File is the file the file exporter appends spans to.
+	File string `yaml:"file" toml:"file"`
+}
+
+// Default is the configuration used for anything the file and environment
+// leave out. It matches a local setup with everything on localhost.
+func Default() *Config {
+	return &Config{
+		Mongo: MongoConfig{URI: "mongodb://localhost:27017"},
+		Redis: RedisConfig{
+			Addr:         "localhost:6379",
+			ClusterNodes: []string{"localhost:7001", "localhost:7002", "localhost:7003"},
+		},
+		Gateway: GatewayConfig{
+			Listen:      ":8080",
+			Backends:    []string{"http://localhost:8081", "http://localhost:8082"},
+			CORSOrigins: []string{"http://localhost:5500"},
+		},
+		GameServers: map[string]GameServerConfig{
+			"second_server": {Listen: ":8081", CORSOrigins: []string{"http://127.0.0.1:5501"}, MaxClients: DefaultMaxClients},
+			"third_server":  {Listen: ":8082", CORSOrigins: []string{"http://127.0.0.1:5501"}, MaxClients: DefaultMaxClients},
+		},
+		Matchmaking: MatchmakingConfig{
+			RoomSize:        2,
+			RatingWindow:    100,
+			WindowGrowth:    50,
+			WidenSeconds:    10,
+			MaxRatingWindow: 400,
+		},
+		Rooms: RoomsConfig{
+			DefaultCapacity:  8,
+			MaxCapacity:      16,
+			CodeTTLSeconds:   60 * 60,
+			InviteTTLSeconds: 24 * 60 * 60,
+			InviteURL:        "http://localhost:5500/?invite=",
+		},
+		Teams:   TeamsConfig{Target: 5},
+		Log:     LogConfig{Level: "info"},
+		Tracing: TracingConfig{Exporter: "none"},
+	}
+}
+
+// Load reads the config file at path, or the one named by PathEnv if path is
+// empty, on top of the defaults, applies environment overrides and
+// validates the result. With neither a path nor PathEnv only the defaults
+// and the environment are used.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv(PathEnv)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		if err := cfg.decode(filepath.Ext(path), data); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	cfg.applyEnv(os.LookupEnv)
+	for name, server := range cfg.GameServers {
+		if server.MaxClients == 0 {
+			server.MaxClients = DefaultMaxClients
+			cfg.GameServers[name] = server
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) decode(ext string, data []byte) error {
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(c)
+	default:
+		return fmt.Errorf("unsupported config format %q, use .yaml, .yml or .toml", ext)
+	}
+}
+
+// applyEnv overrides settings from the environment. Lists are comma
+// separated. Game servers are overridden per name, e.g.
+// SCRAMBLED_SECOND_SERVER_LISTEN.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) {
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = strings.TrimSpace(v)
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v, ok := lookup(name); ok {
+			*dst = splitList(v)
+		}
+	}
+
+	str("SCRAMBLED_MONGO_URI", &c.Mongo.URI)
+	str("SCRAMBLED_REDIS_ADDR", &c.Redis.Addr)
+	list("SCRAMBLED_REDIS_CLUSTER_NODES", &c.Redis.ClusterNodes)
+	str("SCRAMBLED_GATEWAY_LISTEN", &c.Gateway.Listen)
+	list("SCRAMBLED_GATEWAY_BACKENDS", &c.Gateway.Backends)
+	list("SCRAMBLED_GATEWAY_CORS_ORIGINS", &c.Gateway.CORSOrigins)
+	list("SCRAMBLED_CHAT_BANNED_WORDS", &c.Chat.BannedWords)
+	list("SCRAMBLED_CHAT_ADMINS", &c.Chat.Admins)
+	str("SCRAMBLED_LOG_LEVEL", &c.Log.Level)
+	str("SCRAMBLED_TRACING_EXPORTER", &c.Tracing.Exporter)
+	str("SCRAMBLED_TRACING_FILE", &c.Tracing.File)
+
+	for name, server := range c.GameServers {
+		prefix := "SCRAMBLED_" + strings.ToUpper(name) + "_"
+		str(prefix+"LISTEN", &server.Listen)
+		list(prefix+"CORS_ORIGINS", &server.CORSOrigins)
+		c.GameServers[name] = server
+	}
+}
+
+func splitList(v string) []string {
+	items := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(err error, setting string) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", setting, err))
+		}
+	}
+
+	check(validateMongoURI(c.Mongo.URI), "mongo.uri")
+	check(validateHostPort(c.Redis.Addr), "redis.addr")
+	if len(c.Redis.ClusterNodes) == 0 {
+		check(errors.New("at least one node is required"), "redis.cluster_nodes")
+	}
+	for i, node := range c.Redis.ClusterNodes {
+		check(validateHostPort(node), fmt.Sprintf("redis.cluster_nodes[%d]", i))
+	}
+
+	check(validateListen(c.Gateway.Listen), "gateway.listen")
+	if len(c.Gateway.Backends) == 0 {
+		check(errors.New("at least one backend is required"), "gateway.backends")
+	}
+	for i, backend := range c.Gateway.Backends {
+		check(validateHTTPURL(backend), fmt.Sprintf("gateway.backends[%d]", i))
+	}
+	for i, origin := range c.Gateway.CORSOrigins {
+		check(validateOrigin(origin), fmt.Sprintf("gateway.cors_origins[%d]", i))
+	}
+
+	for name, server := range c.GameServers {
+		check(validateListen(server.Listen), "game_servers."+name+".listen")
+		if server.MaxClients < 0 {
+			check(fmt.Errorf("must not be negative, got %d", server.MaxClients), "game_servers."+name+".max_clients")
+		}
+		for i, origin := range server.CORSOrigins {
+			check(validateOrigin(origin), fmt.Sprintf("game_servers.%s.cors_origins[%d]", name, i))
+		}
+	}
+
+	for i, word := range c.Chat.BannedWords {
+		if strings.TrimSpace(word) == "" {
+			check(errors.New("must not be blank"), fmt.Sprintf("chat.banned_words[%d]", i))
+		}
+	}
+
+	mm := c.Matchmaking
+	if mm.RoomSize < 2 {
+		check(fmt.Errorf("must be at least 2, got %d", mm.RoomSize), "matchmaking.room_size")
+	}
+	if mm.RatingWindow < 0 {
+		check(fmt.Errorf("must not be negative, got %d", mm.RatingWindow), "matchmaking.rating_window")
+	}
+	if mm.WindowGrowth < 0 {
+		check(fmt.Errorf("must not be negative, got %d", mm.WindowGrowth), "matchmaking.window_growth")
+	}
+	if mm.WindowGrowth > 0 && mm.WidenSeconds <= 0 {
+		check(fmt.Errorf("must be positive when the window grows, got %d", mm.WidenSeconds), "matchmaking.widen_seconds")
+	}
+	if mm.MaxRatingWindow < mm.RatingWindow {
+		check(fmt.Errorf("must be at least rating_window, got %d", mm.MaxRatingWindow), "matchmaking.max_rating_window")
+	}
+
+	rooms := c.Rooms
+	if rooms.MaxCapacity < 2 {
+		check(fmt.Errorf("must be at least 2, got %d", rooms.MaxCapacity), "rooms.max_capacity")
+	}
+	if rooms.DefaultCapacity < 2 || rooms.DefaultCapacity > rooms.MaxCapacity {
+		check(fmt.Errorf("must be between 2 and max_capacity, got %d", rooms.DefaultCapacity), "rooms.default_capacity")
+	}
+	if rooms.CodeTTLSeconds <= 0 {
+		check(fmt.Errorf("must be positive, got %d", rooms.CodeTTLSeconds), "rooms.code_ttl_seconds")
+	}
+	if rooms.InviteTTLSeconds <= 0 {
+		check(fmt.Errorf("must be positive, got %d", rooms.InviteTTLSeconds), "rooms.invite_ttl_seconds")
+	}
+	if rooms.InviteURL != "" {
+		check(validateHTTPURL(rooms.InviteURL), "rooms.invite_url")
+	}
+
+	if c.Teams.Target < 1 {
+		check(fmt.Errorf("must be positive, got %d", c.Teams.Target), "teams.target")
+	}
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)), "log.level")
+	if c.Tracing.Exporter == "" {
+		check(errors.New("must be set, use none to disable tracing"), "tracing.exporter")
+	}
+	if c.Tracing.Exporter == "file" && c.Tracing.File == "" {
+		check(errors.New("required by the file exporter"), "tracing.file")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// GameServer returns the settings of the named game server.
+func (c *Config) GameServer(name string) (GameServerConfig, error) {
+	server, ok := c.GameServers[name]
+	if !ok {
+		return GameServerConfig{}, fmt.Errorf("no game_servers.%s section in config", name)
+	}
+	return server, nil
+}
+
+func validateMongoURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		return fmt.Errorf("scheme must be mongodb or mongodb+srv, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
+
+func validateHostPort(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" || port == "" {
+		return fmt.Errorf("%q must be host:port", addr)
+	}
+	return nil
+}
+
+// validateListen accepts listen addresses such as ":8080".
+func validateListen(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if port == "" {
+		return fmt.Errorf("%q is missing a port", addr)
+	}
+	return nil
+}
+
+func validateHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http or https URL", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q is missing a host", raw)
+	}
+	return nil
+}
+
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	if err := validateHTTPURL(origin); err != nil {
+		return err
+	}
+	if u, _ := url.Parse(origin); u.Path != "" {
+		return fmt.Errorf("%q must not have a path", origin)
+	}
+	return nil
+}
+
+// Print writes the effective configuration as YAML, with any password in the
+// Mongo URI masked.
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	printed.Mongo.URI = redactURI(c.Mongo.URI)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(printed); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func redactURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"third_server/logging"
+	"third_server/shared"
+)
+
+const (
+	// A player may send chatRateLimit messages every chatRateWindow.
+	chatRateLimit  = 5
+	chatRateWindow = 10 * time.Second
+	// chatHistorySize is how many recent messages a joining client is sent.
+	chatHistorySize = 50
+
+	defaultMuteDuration = 10 * time.Minute
+	// kickDuration is how long a kicked player is kept from joining again.
+	kickDuration = 10 * time.Minute
+
+	moderationMute = "mute"
+	moderationKick = "kick"
+)
+
+var (
+	chatMu     sync.Mutex
+	chatFilter *regexp.Regexp
+	chatAdmins = make(map[string]bool)
+	// chatHistory holds the recent messages of each game by room, "" being
+	// the lobby game.
+	chatHistory = make(map[string][]shared.ChatPayload)
+	// chatSent holds when each player sent their recent messages, for the
+	// rate limit.
+	chatSent = make(map[string][]time.Time)
+	// mutedUntil and kickedUntil hold until when players are muted in or
+	// kicked from a game.
+	mutedUntil  = make(map[moderated]time.Time)
+	kickedUntil = make(map[moderated]time.Time)
+)
+
+// moderated is a player in a game, by player ID and room, "" being the
+// lobby game. Players are moderated in one game only.
+type moderated struct {
+	room     string
+	playerID string
+}
+
+// ConfigureChat sets the words masked out of chat messages and the users who
+// may moderate any game, not just the one they host.
+func ConfigureChat(bannedWords, admins []string) {
+	chatMu.Lock()
+	defer chatMu.Unlock()
+
+	chatFilter = nil
+	if len(bannedWords) > 0 {
+		quoted := make([]string, len(bannedWords))
+		for i, word := range bannedWords {
+			quoted[i] = regexp.QuoteMeta(strings.TrimSpace(word))
+		}
+		chatFilter = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+
+	chatAdmins = make(map[string]bool, len(admins))
+	for _, admin := range admins {
+		chatAdmins[admin] = true
+	}
+}
+
+// filterChat masks every banned word in text with one asterisk per letter.
+func filterChat(text string) string {
+	chatMu.Lock()
+	filter := chatFilter
+	chatMu.Unlock()
+
+	if filter == nil {
+		return text
+	}
+	return filter.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
+
+// allowChat reports whether the player may send another message now, and
+// counts it if so.
+func allowChat(playerID string, now time.Time) bool {
+	chatMu.Lock()
+	defer chatMu.Unlock()
+
+	recent := chatSent[playerID][:0]
+	for _, sent := range chatSent[playerID] {
+		if now.Sub(sent) < chatRateWindow {
+			recent = append(recent, sent)
+		}
+	}
+	if len(recent) >= chatRateLimit {
+		chatSent[playerID] = recent
+		return false
+	}
+	chatSent[playerID] = append(recent, now)
+	return true
+}
+
+// sendChat filters a player's message and sends it to everyone in their
+// game.
+func sendChat(ctx context.Context, player shared.Player, text string) *shared.ErrorPayload {
+	now := time.Now().UTC()
+	if until, muted := muteExpiry(player.Room, player.ID.Hex(), now); muted {
+		return &shared.ErrorPayload{
+			Code:    shared.ErrCodeMuted,
+			Message: "you are muted until " + until.Format(time.RFC3339),
+		}
+	}
+	if !allowChat(player.ID.Hex(), now) {
+		return &shared.ErrorPayload{Code: shared.ErrCodeRateLimited, Message: "too many chat messages, slow down"}
+	}
+
+	chat := shared.ChatPayload{Player: player.Name, Text: filterChat(text), SentAt: now}
+	event := gameEvent{Kind: eventChat, Origin: serverID, SentAt: now, Game: player.Room, Chat: &chat}
+	if err := publish(event); err != nil {
+		logging.FromContext(ctx).Warn("Failed to publish chat, delivering locally only", "error", err)
+		handleChat(event)
+	}
+	return nil
+}
+
+// handleChat keeps a chat message in the history of its game and delivers
+// it to the clients of the game connected here.
+func handleChat(event gameEvent) {
+	chatMu.Lock()
+	history := append(chatHistory[event.Game], *event.Chat)
+	if len(history) > chatHistorySize {
+		history = history[len(history)-chatHistorySize:]
+	}
+	chatHistory[event.Game] = history
+	chatMu.Unlock()
+
+	deliverLocally(event.Game, shared.NewMessage(shared.TypeChat, *event.Chat))
+}
+
+// sendChatHistory sends a client who just joined the recent chat messages
+// of its game.
+func sendChatHistory(client *shared.Client) {
+	shared.Mu.Lock()
+	room := shared.GameRoom(client)
+	shared.Mu.Unlock()
+
+	chatMu.Lock()
+	messages := append([]shared.ChatPayload{}, chatHistory[room]...)
+	chatMu.Unlock()
+
+	client.Send(shared.NewMessage(shared.TypeChatHistory, shared.ChatHistoryPayload{Messages: messages}))
+}
+
+func muteExpiry(room, playerID string, now time.Time) (time.Time, bool) {
+	chatMu.Lock()
+	defer chatMu.Unlock()
+	until, ok := mutedUntil[moderated{room, playerID}]
+	return until, ok && now.Before(until)
+}
+
+// isKicked reports whether the player was kicked from the game of room
+// recently enough to be kept out of it.
+func isKicked(room, playerID string) bool {
+	chatMu.Lock()
+	defer chatMu.Unlock()
+	until, ok := kickedUntil[moderated{room, playerID}]
+	return ok && time.Now().Before(until)
+}
+
+// canModerate reports whether the player hosts the game of room, the room
+// itself or the lobby game, or is an admin. Callers must not hold mu.
+func canModerate(player shared.Player, room string) bool {
+	chatMu.Lock()
+	admin := chatAdmins[player.Name]
+	chatMu.Unlock()
+	if admin {
+		return true
+	}
+
+	if room != "" {
+		roomsMu.Lock()
+		defer roomsMu.Unlock()
+		r := rooms[room]
+		return r != nil && r.HostID == player.ID.Hex()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return gameState.HostID == player.ID.Hex()
+}
+
+// moderate mutes or kicks target in the game of player on every game
+// server on behalf of player.
+func moderate(ctx context.Context, player shared.Player, action, target string, duration time.Duration) *shared.ErrorPayload {
+	room := playerRoom(player.ID.Hex())
+	if !canModerate(player, room) {
+		return &shared.ErrorPayload{Code: shared.ErrCodeForbidden, Message: "only the host or an admin can " + action + " players"}
+	}
+	if target == player.Name {
+		return &shared.ErrorPayload{Code: shared.ErrCodeInvalidPayload, Message: "you cannot " + action + " yourself"}
+	}
+	targetID, ok := findInGame(room, target)
+	if !ok {
+		return &shared.ErrorPayload{Code: shared.ErrCodeNotFound, Message: "player " + target + " is not in the game"}
+	}
+
+	now := time.Now().UTC()
+	moderation := shared.ModerationPayload{Action: action, Player: target, By: player.Name, Until: now.Add(duration)}
+	event := gameEvent{Kind: eventModeration, Origin: serverID, SentAt: now, Game: room, Target: targetID, Moderation: &moderation}
+	if err := publish(event); err != nil {
+		logging.FromContext(ctx).Warn("Failed to publish moderation, applying locally only", "error", err)
+		handleModeration(event)
+	}
+	logging.FromContext(ctx).Info("Player moderated", "action", action, "target", targetID)
+	return nil
+}
+
+// findInGame returns the ID of the player of that name in the game of room
+// on any game server.
+func findInGame(room, name string) (string, bool) {
+	rostersMu.Lock()
+	defer rostersMu.Unlock()
+	for _, p := range allPlayers() {
+		if p.Room == room && p.Name == name {
+			return p.ID, true
+		}
+	}
+	return "", false
+}
+
+// handleModeration records a mute or kick, tells the clients of the game
+// connected here and, for a kick, takes the player out of the game if they
+// play here: out of the room for the game of a room, or off the server for
+// the lobby game.
+func handleModeration(event gameEvent) {
+	moderation := *event.Moderation
+	key := moderated{event.Game, event.Target}
+
+	chatMu.Lock()
+	switch moderation.Action {
+	case moderationMute:
+		mutedUntil[key] = moderation.Until
+	case moderationKick:
+		kickedUntil[key] = moderation.Until
+	}
+	chatMu.Unlock()
+
+	msg := shared.NewMessage(shared.TypeModeration, moderation)
+	if moderation.Action != moderationKick {
+		deliverLocally(event.Game, msg)
+		return
+	}
+
+	shared.Mu.Lock()
+	kicked := false
+	for client, player := range shared.Players {
+		if player.ID.Hex() != event.Target || player.Room != event.Game {
+			continue
+		}
+		kicked = true
+		if event.Game == "" {
+			delete(shared.Players, client)
+		} else {
+			// Out of the room, the player no longer hears from it.
+			client.Send(msg)
+		}
+	}
+	shared.Mu.Unlock()
+
+	if kicked {
+		ctx := logging.WithPlayer(context.Background(), event.Target)
+		if event.Game == "" {
+			leaveGame(ctx, event.Target)
+		} else {
+			leaveRoom(ctx, event.Target)
+		}
+		logging.FromContext(ctx).Info("Kicked player removed from the game")
+	}
+	deliverLocally(event.Game, msg)
+	if kicked {
+		broadcastPlayerList()
+	}
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"third_server/metrics"
+	"third_server/shared"
+)
+
+const (
+	eventBroadcast  = "broadcast"
+	eventRoster     = "roster"
+	eventChat       = "chat"
+	eventModeration = "moderation"
+	eventRound      = "round"
+	eventTeams      = "teams"
+	eventRoom       = "room"
+	eventQueue      = "queue"
+
+	// Every server republishes its roster every rosterRefresh so that the
+	// players of a server that died without saying goodbye disappear from
+	// the list once rosterTTL has passed.
+	rosterRefresh = 30 * time.Second
+	rosterTTL     = 3 * rosterRefresh
+)
+
+// serverID tells the game servers apart in published events.
+var serverID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// startedAt is when this server started, before which it cannot have heard
+// from the others.
+var startedAt = time.Now()
+
+// gameEvent is what game servers publish to each other over Redis. A
+// broadcast carries a message for the players of one game; a roster carries
+// the players connected to the origin server, with the words they are
+// working on and the rooms they play in, and how many spectators watch each
+// game.
+// Chat messages and moderation decisions travel as events of their own,
+// since every server keeps the chat history and who is muted or kicked. A
+// round starts the next round of a race, teams carries the scores of a team
+// match, room a room as it now is, and queue players the origin server took
+// out of the matchmaking queue and put in a room. Game is the room whose
+// game a broadcast, chat, moderation, round or teams event is about, empty
+// for the lobby game, and Target the ID of the player a moderation is
+// about.
+type gameEvent struct {
+	Kind       string                    `json:"kind"`
+	Origin     string                    `json:"origin"`
+	SentAt     time.Time                 `json:"sent_at"`
+	Game       string                    `json:"game,omitempty"`
+	Target     string                    `json:"target,omitempty"`
+	Message    *shared.Message           `json:"message,omitempty"`
+	Roster     []rosterPlayer            `json:"roster,omitempty"`
+	Spectators map[string]int            `json:"spectators,omitempty"`
+	Chat       *shared.ChatPayload       `json:"chat,omitempty"`
+	Moderation *shared.ModerationPayload `json:"moderation,omitempty"`
+	Round      *raceRound                `json:"round,omitempty"`
+	Teams      *teamScores               `json:"teams,omitempty"`
+	Room       *room                     `json:"room,omitempty"`
+	Queue      *queueUpdate              `json:"queue,omitempty"`
+}
+
+// rosterPlayer is a player in a roster, with their ID and the room whose
+// game they play.
+type rosterPlayer struct {
+	shared.PlayerSummary
+	ID   string `json:"id"`
+	Room string `json:"room,omitempty"`
+}
+
+type roster struct {
+	players    []rosterPlayer
+	spectators map[string]int
+	updatedAt  time.Time
+}
+
+var (
+	rosters   = make(map[string]roster)
+	rostersMu sync.Mutex
+)
+
+// StartEventListener subscribes this server to the events published by all
+// game servers and keeps its own roster fresh on the others until ctx is done.
+func StartEventListener(ctx context.Context) {
+	go eventBus.Subscribe(ctx, handleEvent)
+
+	go func() {
+		ticker := time.NewTicker(rosterRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				broadcastPlayerList()
+			}
+		}
+	}()
+}
+
+// publishGameEvent sends a message to the players of the game of room on
+// every game server. If Redis cannot be reached the message still goes to
+// this server's players.
+func publishGameEvent(room string, msg shared.Message) {
+	event := gameEvent{Kind: eventBroadcast, Origin: serverID, SentAt: time.Now(), Game: room, Message: &msg}
+	if err := publish(event); err != nil {
+		slog.Warn("Failed to publish event, delivering locally only", "type", msg.Type, "error", err)
+		deliverLocally(room, msg)
+	}
+}
+
+// publishRoster shares this server's players and how many spectators watch
+// each game with the other game servers.
+func publishRoster(players []rosterPlayer, spectators map[string]int) {
+	event := gameEvent{Kind: eventRoster, Origin: serverID, SentAt: time.Now(), Roster: players, Spectators: spectators}
+	if err := publish(event); err != nil {
+		slog.Warn("Failed to publish roster, updating local players only", "error", err)
+		handleRoster(event)
+	}
+}
+
+func publish(event gameEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return eventBus.Publish(ctx, data)
+}
+
+func handleEvent(data []byte) {
+	var event gameEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		slog.Warn("Ignoring malformed game event", "error", err)
+		return
+	}
+
+	switch event.Kind {
+	case eventBroadcast:
+		if event.Message != nil {
+			deliverLocally(event.Game, *event.Message)
+		}
+	case eventRoster:
+		handleRoster(event)
+	case eventChat:
+		if event.Chat != nil {
+			handleChat(event)
+		}
+	case eventModeration:
+		if event.Moderation != nil {
+			handleModeration(event)
+		}
+	case eventRound:
+		if event.Round != nil {
+			handleRound(event)
+		}
+	case eventTeams:
+		if event.Teams != nil {
+			handleTeamScores(event)
+		}
+	case eventRoom:
+		if event.Room != nil {
+			handleRoom(event)
+		}
+	case eventQueue:
+		if event.Queue != nil {
+			handleQueue(event)
+		}
+	}
+}
+
+// deliverLocally queues a message for the clients of the game of room,
+// dropping it if the broadcaster has fallen too far behind.
+func deliverLocally(room string, msg shared.Message) {
+	select {
+	case shared.Broadcast <- shared.Delivery{Room: room, Message: msg}:
+	default:
+		slog.Error("Broadcast channel is full, dropping message", "type", msg.Type)
+		metrics.DroppedBroadcasts.Inc()
+	}
+}
+
+// handleRoster records the players of the origin server and sends the
+// combined player list of all servers to the players and spectators
+// connected here.
+func handleRoster(event gameEvent) {
+	rostersMu.Lock()
+	if len(event.Roster) == 0 && len(event.Spectators) == 0 {
+		delete(rosters, event.Origin)
+	} else {
+		rosters[event.Origin] = roster{players: event.Roster, spectators: event.Spectators, updatedAt: time.Now()}
+	}
+	rostersMu.Unlock()
+
+	sendPlayerList()
+}
+
+// sendPlayerList sends each player and spectator connected here the
+// players of their game on all servers, also grouped by team. Spectators
+// are the only ones shown the scrambled words.
+func sendPlayerList() {
+	rostersMu.Lock()
+	players := allPlayers()
+	spectators := spectatorCounts()
+	rostersMu.Unlock()
+
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+
+	rooms := map[string]bool{"": true}
+	for _, p := range shared.Players {
+		rooms[p.Room] = true
+	}
+	for _, room := range shared.Spectators {
+		rooms[room] = true
+	}
+	for room := range rooms {
+		mu.Lock()
+		teamPoints := maps.Clone(gameOf(room).TeamScores)
+		mu.Unlock()
+
+		inGame := gamePlayers(players, room)
+		withoutWords := make([]shared.PlayerSummary, len(inGame))
+		for i, p := range inGame {
+			withoutWords[i] = shared.PlayerSummary{Name: p.Name, Score: p.Score, Team: p.Team}
+		}
+		shared.SendToPlayers(room, shared.NewMessage(shared.TypePlayerList, shared.PlayerListPayload{
+			Players:    withoutWords,
+			Teams:      groupPlayers(withoutWords, teamPoints),
+			Spectators: spectators[room],
+		}))
+		shared.SendToSpectators(room, shared.NewMessage(shared.TypePlayerList, shared.PlayerListPayload{
+			Players:    inGame,
+			Teams:      groupPlayers(inGame, teamPoints),
+			Spectators: spectators[room],
+		}))
+	}
+}
+
+// gamePlayers picks the players of the game of room out of players.
+func gamePlayers(players []rosterPlayer, room string) []shared.PlayerSummary {
+	inGame := []shared.PlayerSummary{}
+	for _, p := range players {
+		if p.Room == room {
+			inGame = append(inGame, p.PlayerSummary)
+		}
+	}
+	return inGame
+}
+
+// allPlayers merges the rosters of every live server. Callers must hold
+// rostersMu.
+func allPlayers() []rosterPlayer {
+	players := []rosterPlayer{}
+	origins := make([]string, 0, len(rosters))
+	for origin, r := range rosters {
+		if time.Since(r.updatedAt) > rosterTTL {
+			delete(rosters, origin)
+			continue
+		}
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+
+	for _, origin := range origins {
+		players = append(players, rosters[origin].players...)
+	}
+	return players
+}
+
+// spectatorCounts adds up the spectators of each game on every live server.
+// Callers must hold rostersMu.
+func spectatorCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, r := range rosters {
+		if time.Since(r.updatedAt) <= rosterTTL {
+			for room, count := range r.spectators {
+				counts[room] += count
+			}
+		}
+	}
+	return counts
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"math"
+
+	"third_server/logging"
+	"third_server/models"
+)
+
+// ratingK is the most a player's rating can move in one match.
+const ratingK = 32
+
+// standing is where a player finished a match, with their rating going in.
+type standing struct {
+	rating int
+	score  int
+	team   string
+}
+
+// ratingChanges rates a multiplayer match by Elo, as if every player had
+// played every other: a player beats those who finished below them and
+// draws with those level with them. In a team match players are ranked by
+// their team's score and not against their own teammates. Each player's
+// change is averaged over their opponents, so it stays within ratingK
+// however many played.
+func ratingChanges(standings []standing, teamScores map[string]int) []int {
+	points := func(s standing) int {
+		if teamScores != nil {
+			return teamScores[s.team]
+		}
+		return s.score
+	}
+
+	changes := make([]int, len(standings))
+	for i, player := range standings {
+		var sum float64
+		opponents := 0
+		for j, other := range standings {
+			if j == i || (teamScores != nil && other.team == player.team) {
+				continue
+			}
+			actual := 0.5
+			switch {
+			case points(player) > points(other):
+				actual = 1
+			case points(player) < points(other):
+				actual = 0
+			}
+			expected := 1 / (1 + math.Pow(10, float64(other.rating-player.rating)/400))
+			sum += actual - expected
+			opponents++
+		}
+		if opponents > 0 {
+			changes[i] = int(math.Round(ratingK * sum / float64(opponents)))
+		}
+	}
+	return changes
+}
+
+// rateMatch sets the rating every player of a multiplayer match ends it
+// with. A match played alone is not rated, nor is one whose players' ratings
+// cannot be looked up; that is logged and the match still counts.
+func rateMatch(ctx context.Context, match *models.Match) {
+	if len(match.Players) < 2 {
+		return
+	}
+
+	standings := make([]standing, len(match.Players))
+	for i, p := range match.Players {
+		user, err := users.FindByID(ctx, p.ID)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Not rating match, failed to look up player", "player_id", p.ID, "error", err)
+			return
+		}
+		standings[i] = standing{rating: user.CurrentRating(), score: p.Score, team: p.Team}
+	}
+	var teamScores map[string]int
+	if match.WinningTeam != "" {
+		teamScores = make(map[string]int, len(match.Teams))
+		for _, team := range match.Teams {
+			teamScores[team.Name] = team.Score
+		}
+	}
+
+	for i, change := range ratingChanges(standings, teamScores) {
+		match.Players[i].Rating = standings[i].rating + change
+		match.Players[i].RatingChange = change
+	}
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"third_server/logging"
+	"third_server/models"
+	"third_server/store"
+)
+
+// The storage used by the handlers, set with Configure.
+var (
+	users        store.UserStore
+	gameStore    store.GameStateStore
+	eventLog     store.EventLog
+	eventBus     store.EventBus
+	queueStore   store.QueueStore
+	roomStore    store.RoomStore
+	leaderboard  store.LeaderboardStore
+	healthChecks []store.HealthCheck
+	writeQueue   *store.WriteBehind
+)
+
+const storeTimeout = 5 * time.Second
+
+// Configure sets the storage the handlers use. It must be called before
+// LoadGameState and LoadRooms and before any route is served.
+func Configure(stores store.Stores) {
+	users = stores.Users
+	gameStore = stores.GameState
+	eventLog = stores.EventLog
+	eventBus = stores.Events
+	queueStore = stores.Matchmaking
+	roomStore = stores.Rooms
+	leaderboard = stores.Leaderboard
+	healthChecks = stores.Health
+	writeQueue = stores.Queue
+}
+
+// StartWriteBehind retries the writes buffered while a store is down, until
+// ctx is done.
+func StartWriteBehind(ctx context.Context) {
+	if writeQueue != nil {
+		go writeQueue.Run(ctx)
+	}
+}
+
+// flushWriteBehind makes a last attempt at the buffered writes before the
+// server exits.
+func flushWriteBehind(ctx context.Context) {
+	if writeQueue == nil {
+		return
+	}
+	if err := writeQueue.Flush(ctx); err != nil {
+		slog.Error("Buffered writes lost on shutdown", "writes", writeQueue.Pending(), "error", err)
+	}
+}
+
+// storeContext bounds a store call by storeTimeout. It keeps the values of
+// ctx, such as the trace, but not its cancellation, so a write is not cut
+// short because the player hung up.
+func storeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), storeTimeout)
+}
+
+// saveGameState persists game if it is the lobby game; the game of a room
+// lasts only as long as the room. Callers must hold mu.
+func saveGameState(ctx context.Context, game *models.GameState) {
+	if game.Room != "" {
+		return
+	}
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if err := gameStore.Save(ctx, game); err != nil {
+		slog.Error("Failed to save game state", "error", err)
+	}
+}
+
+func savePlayer(ctx context.Context, game *models.GameState, player models.Player) {
+	if game.Room != "" {
+		return
+	}
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if err := gameStore.SavePlayer(ctx, player); err != nil {
+		slog.Error("Failed to save player", logging.KeyPlayerID, player.ID, "error", err)
+	}
+}
+
+func removePlayer(ctx context.Context, game *models.GameState, player models.Player) {
+	if game.Room != "" {
+		return
+	}
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if err := gameStore.RemovePlayer(ctx, player); err != nil {
+		slog.Error("Failed to remove player", logging.KeyPlayerID, player.ID, "error", err)
+	}
+}
+
+// recordEvent appends an event of game to the game event log, stamping it
+// with the room of game, the match under way unless it names one and the
+// time unless it already has one. A failure is logged but does not fail the
+// game action that caused the event. Callers must not hold mu.
+func recordEvent(ctx context.Context, game *models.GameState, event models.GameEvent) {
+	mu.Lock()
+	event.Room = game.Room
+	if event.MatchID == "" {
+		event.MatchID = game.MatchID
+	}
+	mu.Unlock()
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+	event.Server = serverID
+
+	ctx, cancel := storeContext(ctx)
+	defer cancel()
+
+	if err := eventLog.Append(ctx, &event); err != nil {
+		logging.FromContext(ctx).Error("Failed to record game event", "event", event.Type, "error", err)
+	}
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sort"
+	"time"
+
+	"third_server/config"
+	"third_server/logging"
+	"third_server/models"
+	"third_server/shared"
+)
+
+// teamCfg holds the points a team must score to win a team match, set with
+// ConfigureTeams. Guarded by mu.
+var teamCfg = config.Default().Teams
+
+// ConfigureTeams sets how many points a team must score to win a team match.
+func ConfigureTeams(cfg config.TeamsConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	teamCfg = cfg
+}
+
+// teamScores is what a game server tells the others when a team match
+// starts, a team scores or a team wins.
+type teamScores struct {
+	MatchStartedAt time.Time      `json:"match_started_at"`
+	MatchID        string         `json:"match_id"`
+	Scores         map[string]int `json:"scores"`
+	// Winner is the team that won; the match is over once it is set.
+	Winner string `json:"winner,omitempty"`
+}
+
+// pickTeam returns the team a registering player joins: the one they asked
+// for, else the one they were on, else the team with the fewest players
+// across all servers. Callers must hold shared.Mu and not rostersMu.
+func pickTeam(requested, previous string) string {
+	if requested != "" {
+		return requested
+	}
+	if previous != "" {
+		return previous
+	}
+
+	sizes := make(map[string]int)
+	rostersMu.Lock()
+	for origin, r := range rosters {
+		if origin == serverID || time.Since(r.updatedAt) > rosterTTL {
+			continue
+		}
+		for _, p := range r.players {
+			sizes[p.Team]++
+		}
+	}
+	rostersMu.Unlock()
+	for _, p := range shared.Players {
+		sizes[p.Team]++
+	}
+	return smallestTeam(sizes)
+}
+
+// smallestTeam returns the team with the fewest players, the first one in
+// shared.Teams on a tie.
+func smallestTeam(sizes map[string]int) string {
+	smallest := shared.Teams[0]
+	for _, team := range shared.Teams[1:] {
+		if sizes[team] < sizes[smallest] {
+			smallest = team
+		}
+	}
+	return smallest
+}
+
+// switchTeam moves a player to another team. Teams are fixed while a team
+// match is under way.
+func switchTeam(ctx context.Context, client *shared.Client, team string) *shared.ErrorPayload {
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+
+	player := shared.Players[client]
+	mu.Lock()
+	defer mu.Unlock()
+	game := gameOf(player.Room)
+	if game.Started && game.Mode == shared.ModeTeam {
+		return &shared.ErrorPayload{Code: shared.ErrCodeForbidden, Message: "teams cannot change during a team game"}
+	}
+
+	player.Team = team
+	shared.Players[client] = player
+	if p := getPlayerByID(game, player.ID.Hex()); p != nil {
+		p.Team = team
+		savePlayer(ctx, game, *p)
+	}
+	logging.FromContext(ctx).Info("Player switched team", "team", team)
+	return nil
+}
+
+// startTeamMatch gives every team in game a score of zero. Callers must
+// hold mu.
+func startTeamMatch(game *models.GameState) {
+	game.TeamScores = make(map[string]int, len(shared.Teams))
+	for _, team := range shared.Teams {
+		game.TeamScores[team] = 0
+	}
+}
+
+// currentTeamScores describes the team match in game. Callers must hold mu.
+func currentTeamScores(game *models.GameState) teamScores {
+	return teamScores{MatchStartedAt: game.StartedAt, MatchID: game.MatchID, Scores: maps.Clone(game.TeamScores)}
+}
+
+// publishTeamScores tells every server the team scores of the game of
+// room.
+func publishTeamScores(room string, scores teamScores) {
+	event := gameEvent{Kind: eventTeams, Origin: serverID, SentAt: time.Now(), Game: room, Teams: &scores}
+	if err := publish(event); err != nil {
+		slog.Warn("Failed to publish team scores, updating local players only", "error", err)
+		// The caller may hold shared.Mu, which handleTeamScores takes.
+		go handleTeamScores(event)
+	}
+}
+
+// handleTeamScores brings this server up to date with a team match on
+// another server and shows the players here the new team scores.
+func handleTeamScores(event gameEvent) {
+	scores := *event.Teams
+
+	if event.Origin != serverID {
+		mu.Lock()
+		if game := eventGame(event.Game); game != nil {
+			sameMatch := game.Started && game.StartedAt.Equal(scores.MatchStartedAt)
+			switch {
+			case scores.Winner != "":
+				if sameMatch {
+					endMatch(game)
+				}
+			case !sameMatch:
+				game.Started = true
+				game.StartedAt = scores.MatchStartedAt
+				game.MatchID = scores.MatchID
+				game.Solved = nil
+				game.Mode = shared.ModeTeam
+				game.TeamScores = maps.Clone(scores.Scores)
+			default:
+				if game.TeamScores == nil {
+					game.TeamScores = make(map[string]int)
+				}
+				for team, score := range scores.Scores {
+					game.TeamScores[team] = max(game.TeamScores[team], score)
+				}
+			}
+		}
+		mu.Unlock()
+	}
+
+	sendPlayerList()
+}
+
+// teamIndex orders teams as shared.Teams lists them.
+func teamIndex(team string) int {
+	for i, t := range shared.Teams {
+		if t == team {
+			return i
+		}
+	}
+	return len(shared.Teams)
+}
+
+// groupPlayers puts players in their teams, in the order of shared.Teams,
+// with each team's score.
+func groupPlayers(players []shared.PlayerSummary, scores map[string]int) []shared.TeamSummary {
+	teams := make([]shared.TeamSummary, len(shared.Teams))
+	for i, team := range shared.Teams {
+		teams[i] = shared.TeamSummary{Name: team, Score: scores[team], Players: []shared.PlayerSummary{}}
+	}
+	for _, p := range players {
+		if i := teamIndex(p.Team); i < len(teams) {
+			teams[i].Players = append(teams[i].Players, p)
+		}
+	}
+	return teams
+}
+
+// groupScores sorts scores by team and puts them in their teams, in the
+// order of shared.Teams, with each team's score.
+func groupScores(scores []shared.ScoreEntry, teamPoints map[string]int) []shared.TeamScore {
+	sort.SliceStable(scores, func(i, j int) bool { return teamIndex(scores[i].Team) < teamIndex(scores[j].Team) })
+
+	teams := make([]shared.TeamScore, len(shared.Teams))
+	for i, team := range shared.Teams {
+		teams[i] = shared.TeamScore{Name: team, Points: teamPoints[team], Players: []shared.ScoreEntry{}}
+	}
+	for _, s := range scores {
+		if i := teamIndex(s.Team); i < len(teams) {
+			teams[i].Players = append(teams[i].Players, s)
+		}
+	}
+	return teams
+}
@@ -2,15 +2,15 @@ package controllers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
-	"third_server/db"
+	"third_server/logging"
 	"third_server/models"
 	"third_server/shared"
+	"third_server/tracing"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,97 +20,367 @@ var upgrader = websocket.Upgrader{
 }
 
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if IsDraining() {
+		http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+		return
+	}
+	if clientCount() >= MaxClients {
+		http.Error(w, "Server is full", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("WebSocket upgrade error:", err)
+		logger.Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
-	defer conn.Close()
 
-	shared.Mu.Lock()
-	shared.Clients[conn] = true
-	shared.Mu.Unlock()
+	client := shared.NewClient(conn)
+	client.SetLogger(logger)
+	shared.Register(client)
 
-	log.Printf("New WebSocket connection. Total clients: %d\n", len(shared.Clients))
+	logger.Info("WebSocket connected", "clients", clientCount())
 
 	for {
-		var msg shared.Message
-		err := conn.ReadJSON(&msg)
+		_, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("WebSocket read error:", err)
+			client.Logger().Info("WebSocket read ended", "error", err)
 			break
 		}
 
-		if msg.Type == "register" {
-			shared.Mu.Lock()
-			username := msg.Payload.(map[string]interface{})["username"].(string)
-			var user models.User
-			collection := db.GetCollection("scrambled_words", "users")
-
-			err = collection.FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
-			if err != nil {
-				log.Println("User not found:", err)
-				shared.Mu.Unlock()
-				return
+		req, msgErr := shared.DecodeMessage(data)
+		if msgErr != nil {
+			id := ""
+			if req != nil {
+				id = req.ID
 			}
+			client.Logger().Info("Rejected WebSocket message", "code", msgErr.Code, "error", msgErr.Message)
+			client.Send(shared.NewError(id, msgErr))
+			continue
+		}
 
-			shared.Players[conn] = shared.Player{ID: user.ID, Name: username, Score: user.Score}
-			player := models.Player{
-				Name:  shared.Players[conn].Name,
-				Score: shared.Players[conn].Score,
-			}
+		handleMessage(ctx, client, req)
+	}
+
+	shared.Mu.Lock()
+	player, registered := shared.Players[client]
+	shared.Mu.Unlock()
+	shared.Unregister(client)
+	if registered {
+		dequeue(player.ID.Hex())
+		leaveRoom(ctx, player.ID.Hex())
+		leaveGame(logging.WithPlayer(ctx, player.ID.Hex()), player.ID.Hex())
+	}
+	client.Logger().Info("WebSocket disconnected", "clients", clientCount())
+
+	broadcastPlayerList()
+}
+
+// registerPlayer binds the connection to the user's account and puts the
+// player on a team. From then on the client's log lines carry the player's
+// ID.
+func registerPlayer(ctx context.Context, client *shared.Client, req *shared.RegisterPayload) *shared.ErrorPayload {
+	shared.Mu.Lock()
+	defer shared.Mu.Unlock()
+
+	storeCtx, cancel := storeContext(ctx)
+	defer cancel()
+
+	user, err := users.FindByUsername(storeCtx, req.Username)
+	if err != nil {
+		logging.FromContext(ctx).Info("Registration for unknown user", "error", err)
+		return &shared.ErrorPayload{Code: shared.ErrCodeNotFound, Message: "user not found"}
+	}
+	if isKicked("", user.ID.Hex()) {
+		return &shared.ErrorPayload{Code: shared.ErrCodeForbidden, Message: "you were kicked from the game"}
+	}
+
+	// A player who registers again stays in their room, as does one still
+	// in a room from a connection to a server that went down.
+	room := shared.Players[client].Room
+	if room == "" {
+		room = playerRoom(user.ID.Hex())
+	}
+	mu.Lock()
+	var previous string
+	if existing := getPlayerByID(gameOf(room), user.ID.Hex()); existing != nil {
+		previous = existing.Team
+	}
+	mu.Unlock()
+	team := pickTeam(req.Team, previous)
+
+	client.SetLogger(logging.FromContext(logging.WithPlayer(ctx, user.ID.Hex())))
+	delete(shared.Spectators, client)
+	shared.Players[client] = shared.Player{ID: user.ID, Name: req.Username, Score: user.Score, Team: team, Room: room}
+	player := models.Player{
+		ID:    user.ID.Hex(),
+		Name:  shared.Players[client].Name,
+		Score: shared.Players[client].Score,
+		Team:  team,
+	}
+
+	mu.Lock()
+	game := gameOf(room)
+	addPlayer(ctx, game, player)
+	mu.Unlock()
+
+	recordEvent(ctx, game, models.GameEvent{Type: models.EventJoin, PlayerID: player.ID, Player: player.Name, Score: player.Score})
+	return nil
+}
 
-			gameState.Players = append(gameState.Players, player)
-			shared.Mu.Unlock()
+// handleMessage runs a validated client request and replies on the same
+// connection, echoing the request id. ctx carries the connection's logger
+// and trace; each message gets a span of its own.
+func handleMessage(ctx context.Context, client *shared.Client, req *shared.Request) {
+	ctx, span := tracing.Start(ctx, "ws "+req.Type)
+	defer span.End()
 
-			broadcastPlayerList()
+	switch req.Type {
+	case shared.TypeRegister:
+		if msgErr := registerPlayer(ctx, client, req.Payload.(*shared.RegisterPayload)); msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
 		}
+		sendChatHistory(client)
+		broadcastPlayerList()
+		return
 
-		shared.Broadcast <- msg
+	case shared.TypeSpectate:
+		room, msgErr := spectate(ctx, client, req.Payload.(*shared.SpectatePayload))
+		if msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeSpectating, shared.SpectatingPayload{Message: "Watching the game", Room: room}))
+		sendChatHistory(client)
+		broadcastPlayerList()
+		return
 	}
 
 	shared.Mu.Lock()
-	delete(shared.Clients, conn)
-	delete(shared.Players, conn)
+	player, registered := shared.Players[client]
+	_, spectating := shared.Spectators[client]
 	shared.Mu.Unlock()
-	log.Printf("WebSocket disconnected. Total clients: %d\n", len(shared.Clients))
+	if spectating {
+		if req.Type != shared.TypeLeave {
+			client.Send(shared.NewError(req.ID, &shared.ErrorPayload{
+				Code:    shared.ErrCodeSpectator,
+				Message: "spectators cannot send " + req.Type,
+			}))
+			return
+		}
+		shared.Mu.Lock()
+		delete(shared.Spectators, client)
+		shared.Mu.Unlock()
+		client.Send(shared.NewReply(req.ID, shared.TypeLeft, shared.LeftPayload{Message: "Stopped watching the game"}))
+		broadcastPlayerList()
+		return
+	}
+	if !registered {
+		client.Send(shared.NewError(req.ID, &shared.ErrorPayload{
+			Code:    shared.ErrCodeNotRegistered,
+			Message: "register before sending " + req.Type,
+		}))
+		return
+	}
+	playerID := player.ID.Hex()
+	ctx = logging.WithPlayer(ctx, playerID)
 
-	broadcastPlayerList()
+	switch req.Type {
+	case shared.TypeStartGame:
+		started, gameErr := startGame(ctx, playerID, req.Payload.(*shared.StartGameRequest).Mode)
+		if gameErr != nil {
+			client.Send(shared.NewError(req.ID, gameErr.payload()))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeStartGame, started))
+
+	case shared.TypeSubmitGuess:
+		guess := req.Payload.(*shared.SubmitGuessPayload).Guess
+		outcome, gameErr := submitGuess(ctx, playerID, guess)
+		if gameErr != nil {
+			client.Send(shared.NewError(req.ID, gameErr.payload()))
+			return
+		}
+		result := shared.GuessResultPayload{
+			Correct: outcome.Correct,
+			Message: outcome.message(),
+			Player:  shared.PlayerSummary{Name: outcome.Player.Name, Score: outcome.Player.Score, Team: outcome.Player.Team},
+			NewWord: outcome.NewWord,
+			Scores:  outcome.Scores,
+			Teams:   outcome.Teams,
+		}
+		if outcome.Won {
+			result.Winner = outcome.Player.Name
+			result.WinningTeam = outcome.Team
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeGuessResult, result))
+
+	case shared.TypeSkip:
+		word, gameErr := skipWord(ctx, playerID)
+		if gameErr != nil {
+			client.Send(shared.NewError(req.ID, gameErr.payload()))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeSkipped, shared.SkippedPayload{NewWord: word}))
+
+	case shared.TypeTeam:
+		team := req.Payload.(*shared.TeamPayload).Team
+		if msgErr := switchTeam(ctx, client, team); msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeTeam, shared.TeamPayload{Team: team}))
+		broadcastPlayerList()
+
+	case shared.TypeQueue:
+		status, gameErr := enqueue(ctx, player, req.Payload.(*shared.QueuePayload).Mode)
+		if gameErr != nil {
+			client.Send(shared.NewError(req.ID, gameErr.payload()))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeQueueStatus, status))
+		matchQueue()
+
+	case shared.TypeLeaveQueue:
+		if !dequeue(playerID) {
+			client.Send(shared.NewError(req.ID, &shared.ErrorPayload{Code: shared.ErrCodeNotFound, Message: "not in the queue"}))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeLeft, shared.LeftPayload{Message: "Left the queue"}))
+
+	case shared.TypeCreateRoom:
+		created, msgErr := createRoom(ctx, player, req.Payload.(*shared.CreateRoomPayload))
+		if msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeRoom, created))
+
+	case shared.TypeListRooms:
+		client.Send(shared.NewReply(req.ID, shared.TypeRoomList, listRooms()))
+
+	case shared.TypeJoinRoom:
+		joined, msgErr := joinRoom(ctx, player, req.Payload.(*shared.JoinRoomPayload))
+		if msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeRoom, joined))
+
+	case shared.TypeInvite:
+		invite, msgErr := createInvite(ctx, playerID)
+		if msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeInviteCreated, invite))
+
+	case shared.TypeRevokeCode:
+		updated, msgErr := revokeCode(ctx, playerID, req.Payload.(*shared.RevokeCodePayload).Renew)
+		if msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeRoom, updated))
+
+	case shared.TypeLeaveRoom:
+		if !leaveRoom(ctx, playerID) {
+			client.Send(shared.NewError(req.ID, errNotInRoom))
+			return
+		}
+		client.Send(shared.NewReply(req.ID, shared.TypeLeft, shared.LeftPayload{Message: "Left the room"}))
+
+	case shared.TypeChat:
+		if msgErr := sendChat(ctx, player, req.Payload.(*shared.ChatPayload).Text); msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+		}
+
+	case shared.TypeMute:
+		mute := req.Payload.(*shared.MutePayload)
+		duration := defaultMuteDuration
+		if mute.Minutes > 0 {
+			duration = time.Duration(mute.Minutes) * time.Minute
+		}
+		if msgErr := moderate(ctx, player, moderationMute, mute.Player, duration); msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+		}
+
+	case shared.TypeKick:
+		kick := req.Payload.(*shared.KickPayload)
+		if msgErr := moderate(ctx, player, moderationKick, kick.Player, kickDuration); msgErr != nil {
+			client.Send(shared.NewError(req.ID, msgErr))
+		}
+
+	case shared.TypeLeave:
+		dequeue(playerID)
+		leaveRoom(ctx, playerID)
+		leaveGame(ctx, playerID)
+		shared.Mu.Lock()
+		delete(shared.Players, client)
+		shared.Mu.Unlock()
+		client.Send(shared.NewReply(req.ID, shared.TypeLeft, shared.LeftPayload{Message: "Player left the game"}))
+		broadcastPlayerList()
+	}
 }
 
-func broadcastPlayerList() {
-	log.Println("Acquiring lock in broadcastPlayerList()")
+// spectate makes the client a spectator of the game of the room the request
+// names, as lookupRoom finds it, or of the lobby game, taking it out of the
+// game first if it was playing. It returns the room watched.
+func spectate(ctx context.Context, client *shared.Client, req *shared.SpectatePayload) (string, *shared.ErrorPayload) {
+	var room string
+	if req.Room != "" || req.Code != "" || req.Invite != "" {
+		if room = lookupRoom(ctx, "", req.Room, req.Code, req.Invite); room == "" {
+			return "", errNoSuchRoom
+		}
+	}
+
 	shared.Mu.Lock()
-	defer func() {
-		log.Println("Releasing lock in broadcastPlayerList()")
-		shared.Mu.Unlock()
-	}()
+	player, registered := shared.Players[client]
+	delete(shared.Players, client)
+	shared.Spectators[client] = room
+	shared.Mu.Unlock()
+
+	if registered {
+		dequeue(player.ID.Hex())
+		leaveRoom(ctx, player.ID.Hex())
+		leaveGame(logging.WithPlayer(ctx, player.ID.Hex()), player.ID.Hex())
+	}
+	client.SetLogger(logging.FromContext(ctx))
+	client.Logger().Info("Client is spectating", "room", room)
+	return room, nil
+}
 
+func broadcastPlayerList() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("Recovered from panic in broadcastPlayerList:", r)
+			slog.Error("Recovered from panic in broadcastPlayerList", "panic", r)
 		}
 	}()
 
-	playerList := []gin.H{}
+	shared.Mu.Lock()
+	playerList := []rosterPlayer{}
 	for _, player := range shared.Players {
-		playerList = append(playerList, gin.H{
-			"name":  player.Name,
-			"score": player.Score,
+		playerList = append(playerList, rosterPlayer{
+			PlayerSummary: shared.PlayerSummary{
+				Name:      player.Name,
+				Score:     player.Score,
+				Team:      player.Team,
+				Scrambled: player.Scrambled,
+			},
+			ID:   player.ID.Hex(),
+			Room: player.Room,
 		})
 	}
-
-	message := shared.Message{
-		Type:    "player_list",
-		Payload: gin.H{"players": playerList},
+	spectators := make(map[string]int)
+	for _, room := range shared.Spectators {
+		spectators[room]++
 	}
+	shared.Mu.Unlock()
 
-	for conn := range shared.Players {
-		err := conn.WriteJSON(message)
-		if err != nil {
-			log.Println("Error broadcasting to client:", err)
-			conn.Close()
-			delete(shared.Players, conn)
-		}
-	}
+	// Publishing may fall back to handleRoster, which takes shared.Mu.
+	publishRoster(playerList, spectators)
 }
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EventsChannel is the Redis pub/sub channel game servers use to share game
+// events with each other.
+const EventsChannel = "scrambled_words:events"
+
+// PublishEvent sends an encoded event to every subscribed game server.
+func PublishEvent(ctx context.Context, event []byte) error {
+	return publishEvent(ctx, redisClusterClient, event)
+}
+
+// SubscribeEvents calls handle for every event published on EventsChannel,
+// including the ones this server published itself, until ctx is done.
+func SubscribeEvents(ctx context.Context, handle func([]byte)) {
+	subscribeEvents(ctx, redisClusterClient, handle)
+}
+
+// publishEvent and subscribeEvents work with both single-node and cluster
+// clients; in a cluster a PUBLISH reaches subscribers on every node.
+func publishEvent(ctx context.Context, client redis.UniversalClient, event []byte) error {
+	return client.Publish(ctx, EventsChannel, event).Err()
+}
+
+func subscribeEvents(ctx context.Context, client redis.UniversalClient, handle func([]byte)) {
+	pubsub := client.Subscribe(ctx, EventsChannel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		slog.Error("Failed to subscribe to game events", "error", err)
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			handle([]byte(msg.Payload))
+		}
+	}
+}
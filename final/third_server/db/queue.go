@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"third_server/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// matchmakingQueueKey is a hash of player ID to JSON encoded queue entry,
+// holding every player waiting for a room on any game server.
+const matchmakingQueueKey = "matchmaking_queue"
+
+// enqueue puts entry in the queue using WATCH/MULTI on the queue key. A
+// player already queued keeps the time they first queued, and the entry as
+// stored is returned.
+func enqueue(ctx context.Context, client redis.UniversalClient, entry models.QueueEntry) (*models.QueueEntry, error) {
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		queued := entry
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.HGet(ctx, matchmakingQueueKey, entry.PlayerID).Bytes()
+			switch {
+			case err == nil:
+				var earlier models.QueueEntry
+				if err := json.Unmarshal(data, &earlier); err != nil {
+					return fmt.Errorf("decode queue entry %s: %w", entry.PlayerID, err)
+				}
+				queued.QueuedAt = earlier.QueuedAt
+			case !errors.Is(err, redis.Nil):
+				return err
+			}
+
+			data, err = json.Marshal(queued)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, matchmakingQueueKey, queued.PlayerID, data)
+				return nil
+			})
+			return err
+		}, matchmakingQueueKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &queued, nil
+	}
+	return nil, errTooManyConflicts
+}
+
+func dequeue(ctx context.Context, client redis.UniversalClient, playerID string) (bool, error) {
+	removed, err := client.HDel(ctx, matchmakingQueueKey, playerID).Result()
+	return removed > 0, err
+}
+
+// loadQueue returns the queued players, longest waiting first.
+func loadQueue(ctx context.Context, client redis.UniversalClient) ([]models.QueueEntry, error) {
+	entries, err := client.HGetAll(ctx, matchmakingQueueKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	queue := make([]models.QueueEntry, 0, len(entries))
+	for id, data := range entries {
+		var entry models.QueueEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("decode queue entry %s: %w", id, err)
+		}
+		queue = append(queue, entry)
+	}
+	sortQueue(queue)
+	return queue, nil
+}
+
+func sortQueue(queue []models.QueueEntry) {
+	sort.SliceStable(queue, func(i, j int) bool {
+		if !queue[i].QueuedAt.Equal(queue[j].QueuedAt) {
+			return queue[i].QueuedAt.Before(queue[j].QueuedAt)
+		}
+		return queue[i].PlayerID < queue[j].PlayerID
+	})
+}
+
+// takeQueued takes the players out of the queue with WATCH/MULTI if every
+// one of them is still in it, and reports whether it did. Of the servers
+// matching the same players at once only the first succeeds.
+func takeQueued(ctx context.Context, client redis.UniversalClient, playerIDs []string) (bool, error) {
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		taken := false
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			queued, err := tx.HMGet(ctx, matchmakingQueueKey, playerIDs...).Result()
+			if err != nil {
+				return err
+			}
+			for _, entry := range queued {
+				if entry == nil {
+					return nil
+				}
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HDel(ctx, matchmakingQueueKey, playerIDs...)
+				return nil
+			})
+			taken = err == nil
+			return err
+		}, matchmakingQueueKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return taken, err
+	}
+	return false, errTooManyConflicts
+}
@@ -2,9 +2,8 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"third_server/models"
@@ -14,54 +13,94 @@ import (
 
 var redisClusterClient *redis.ClusterClient
 
-func InitRedisCluster() {
+// InitRedisCluster sets up the Redis Cluster client. It fails with
+// ErrUnavailable if the cluster does not answer.
+func InitRedisCluster(nodes []string) error {
 	redisClusterClient = redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs: []string{
-			"localhost:7001",
-			"localhost:7002",
-			"localhost:7003",
-		},
+		Addrs:    nodes,
 		Password: "",
 	})
+	redisClusterClient.AddHook(tracingHook{})
 
 	_, err := redisClusterClient.Ping(context.Background()).Result()
 	if err != nil {
-		log.Fatal("Failed to connect to Redis Cluster:", err)
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
 	}
 
-	fmt.Println("Connected to Redis Cluster!")
+	slog.Info("Connected to Redis Cluster")
+	return nil
 }
 
-func SaveGameState(gameState *models.GameState) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// PingRedis checks that the Redis Cluster can be reached.
+func PingRedis(ctx context.Context) error {
+	return redisClusterClient.Ping(ctx).Err()
+}
 
-	data, err := json.Marshal(gameState)
-	if err != nil {
-		log.Println("Failed to serialize game state:", err)
-		return
-	}
+// SaveGameState persists the game state, merging in concurrent changes made
+// by other servers. See saveGameState.
+func SaveGameState(ctx context.Context, gameState *models.GameState) error {
+	return saveGameState(ctx, redisClusterClient, gameState)
+}
 
-	err = redisClusterClient.Set(ctx, "game_state", data, 0).Err()
-	if err != nil {
-		log.Println("Failed to save game state in Redis Cluster:", err)
-	}
+// LoadGameState returns redis.Nil if no game state has been saved yet.
+func LoadGameState(ctx context.Context) (*models.GameState, error) {
+	return loadGameState(ctx, redisClusterClient)
+}
+
+// SavePlayer stores a single player of the game state without touching the
+// others.
+func SavePlayer(ctx context.Context, player models.Player) error {
+	return savePlayers(ctx, redisClusterClient, player)
 }
 
-func LoadGameState() (*models.GameState, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func RemovePlayer(ctx context.Context, player models.Player) error {
+	return removePlayer(ctx, redisClusterClient, player)
+}
 
-	data, err := redisClusterClient.Get(ctx, "game_state").Bytes()
-	if err != nil {
-		return nil, err
-	}
+// ClaimRound records the winner of a race round if it has none yet. See
+// claimRound.
+func ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error) {
+	return claimRound(ctx, redisClusterClient, matchStart, round, playerID)
+}
 
-	var gameState models.GameState
-	err = json.Unmarshal(data, &gameState)
-	if err != nil {
-		return nil, err
-	}
+// AddTeamPoint gives a team a point in a team match. See addTeamPoint.
+func AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error) {
+	return addTeamPoint(ctx, redisClusterClient, matchStart, team)
+}
+
+// ClaimTeamWin records the winner of a team match if it has none yet. See
+// claimTeamWin.
+func ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error) {
+	return claimTeamWin(ctx, redisClusterClient, matchStart, team)
+}
+
+// Enqueue puts a player in the matchmaking queue. See enqueue.
+func Enqueue(ctx context.Context, entry models.QueueEntry) (*models.QueueEntry, error) {
+	return enqueue(ctx, redisClusterClient, entry)
+}
+
+// Dequeue takes a player out of the matchmaking queue and reports whether
+// they were in it.
+func Dequeue(ctx context.Context, playerID string) (bool, error) {
+	return dequeue(ctx, redisClusterClient, playerID)
+}
+
+// LoadQueue returns the matchmaking queue, longest waiting first.
+func LoadQueue(ctx context.Context) ([]models.QueueEntry, error) {
+	return loadQueue(ctx, redisClusterClient)
+}
+
+// TakeQueued takes matched players out of the queue. See takeQueued.
+func TakeQueued(ctx context.Context, playerIDs []string) (bool, error) {
+	return takeQueued(ctx, redisClusterClient, playerIDs)
+}
+
+// UpdateRoom changes a room for every game server. See updateRoom.
+func UpdateRoom(ctx context.Context, id string, update func(*models.Room) error) (*models.Room, error) {
+	return updateRoom(ctx, redisClusterClient, id, update)
+}
 
-	return &gameState, nil
+// LoadRooms returns every open room.
+func LoadRooms(ctx context.Context) ([]models.Room, error) {
+	return loadRooms(ctx, redisClusterClient)
 }
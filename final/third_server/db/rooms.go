@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"third_server/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// roomsKey is a hash of room ID to JSON encoded room, holding every open
+// room of every game server.
+const roomsKey = "rooms"
+
+// updateRoom applies update to the room with the given ID, or to an empty
+// room if there is none, and writes the result with WATCH/MULTI on the rooms
+// key, so that of two servers changing rooms at once the second sees the
+// change of the first. update may run more than once. A room left without
+// members is deleted. An error from update is returned as is and nothing is
+// written.
+func updateRoom(ctx context.Context, client redis.UniversalClient, id string, update func(*models.Room) error) (*models.Room, error) {
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		var updated models.Room
+		err := client.Watch(ctx, func(tx *redis.Tx) error {
+			updated = models.Room{}
+			data, err := tx.HGet(ctx, roomsKey, id).Bytes()
+			switch {
+			case err == nil:
+				if err := json.Unmarshal(data, &updated); err != nil {
+					return fmt.Errorf("decode room %s: %w", id, err)
+				}
+			case !errors.Is(err, redis.Nil):
+				return err
+			}
+
+			if err := update(&updated); err != nil {
+				return err
+			}
+			updated.ID = id
+			updated.Revision++
+
+			data, err = json.Marshal(updated)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if len(updated.Members) == 0 {
+					pipe.HDel(ctx, roomsKey, id)
+				} else {
+					pipe.HSet(ctx, roomsKey, id, data)
+				}
+				return nil
+			})
+			return err
+		}, roomsKey)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &updated, nil
+	}
+	return nil, errTooManyConflicts
+}
+
+// loadRooms returns every open room.
+func loadRooms(ctx context.Context, client redis.UniversalClient) ([]models.Room, error) {
+	entries, err := client.HGetAll(ctx, roomsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]models.Room, 0, len(entries))
+	for id, data := range entries {
+		var room models.Room
+		if err := json.Unmarshal([]byte(data), &room); err != nil {
+			return nil, fmt.Errorf("decode room %s: %w", id, err)
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of GameEvent.
+const (
+	EventJoin         = "join"
+	EventWordAssigned = "word_assigned"
+	EventGuess        = "guess"
+	EventCorrect      = "correct"
+	// EventHint is reserved for hints, which the game does not offer yet.
+	EventHint     = "hint"
+	EventSkip     = "skip"
+	EventGameOver = "game_over"
+)
+
+// GameEvent is one entry of the append-only game event log, kept in the
+// game_events collection so that matches can be replayed. Unlike the logs,
+// it holds the words and guesses.
+type GameEvent struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id"`
+	Type     string             `json:"type" bson:"type"`
+	PlayerID string             `json:"player_id" bson:"player_id"`
+	Player   string             `json:"player" bson:"player"`
+	// Word is the word assigned or solved, Scrambled what the player was
+	// shown of it.
+	Word      string `json:"word,omitempty" bson:"word,omitempty"`
+	Scrambled string `json:"scrambled,omitempty" bson:"scrambled,omitempty"`
+	Guess     string `json:"guess,omitempty" bson:"guess,omitempty"`
+	Score     int    `json:"score" bson:"score"`
+	// Room is the room whose game the event happened in, empty for the
+	// lobby game. MatchID is the match under way, or on game_over the match
+	// that ended; events between matches, such as players joining, have none.
+	Room    string    `json:"room,omitempty" bson:"room,omitempty"`
+	MatchID string    `json:"match_id,omitempty" bson:"match_id,omitempty"`
+	Server  string    `json:"server" bson:"server"`
+	At      time.Time `json:"at" bson:"at"`
+}
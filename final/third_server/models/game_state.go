@@ -1,11 +1,33 @@
 package models
 
+import "time"
+
 type Player struct {
 	ID   string `json:"id"`
 	Name string `json:"name" bson:"username"`
 
 	Word  string `bson:"word"`
 	Score int    `json:"score"`
+	Team  string `json:"team,omitempty"`
+}
+
+// RoundResult is a race round and how long its winner took to solve it.
+// Players lists how everyone racing when it was won did.
+type RoundResult struct {
+	Round    int            `json:"round"`
+	PlayerID string         `json:"player_id"`
+	Player   string         `json:"player"`
+	SolveMS  int64          `json:"solve_ms"`
+	Players  []PlayerResult `json:"players"`
+}
+
+// PlayerResult is how a player did in a race round: whether they solved its
+// word, and if so how long they took. Only the round's winner solves it.
+type PlayerResult struct {
+	PlayerID string `json:"player_id"`
+	Player   string `json:"player"`
+	Solved   bool   `json:"solved"`
+	SolveMS  int64  `json:"solve_ms,omitempty"`
 }
 
 type GameState struct {
@@ -14,4 +36,34 @@ type GameState struct {
 	Players  []Player `json:"players"`
 	Started  bool     `json:"started"`
 	Winner   *Player  `json:"winner"`
+	// HostID is the player who may mute and kick others. It passes to
+	// another player when the host leaves.
+	HostID string `json:"host_id"`
+	// StartedAt is when the first word of the current match was handed
+	// out, and tells one match from the next.
+	StartedAt time.Time `json:"started_at"`
+	// MatchID is the ID the current match is stored under once it is won,
+	// given to it when it starts.
+	MatchID string `json:"match_id"`
+	// Solved lists the words solved in the current match, in order.
+	Solved []SolvedWord `json:"solved"`
+	// Mode is the mode of the current match, classic or race. In a race Word
+	// and Shuffled are everyone's word for the current Round, which started
+	// at RoundStartedAt, and Rounds lists the rounds won so far, which are
+	// the racers' points.
+	Mode           string        `json:"mode"`
+	Round          int           `json:"round"`
+	RoundStartedAt time.Time     `json:"round_started_at"`
+	Rounds         []RoundResult `json:"rounds"`
+	// TeamScores holds the score of each team in a team match.
+	TeamScores map[string]int `json:"team_scores"`
+	// Unrecorded is the finished match of a win that could not be stored.
+	// It is stored again, under the same match ID, when a winner next
+	// scores. It is kept in memory only.
+	Unrecorded *Match `json:"-"`
+	// Room is the room whose game this is. It is empty for the lobby game,
+	// played by everyone not in a room, which is the only game stored.
+	Room string `json:"room,omitempty"`
+	// Revision is bumped on every save and used to detect concurrent writes.
+	Revision int64 `json:"revision"`
 }
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Match is a finished game, kept in the matches collection.
+type Match struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id"`
+	WinnerID string             `json:"winner_id" bson:"winner_id"`
+	Winner   string             `json:"winner" bson:"winner"`
+	// Players holds everyone in the game with their final score.
+	Players []MatchPlayer `json:"players" bson:"players"`
+	// Words are the words solved during the game, in order.
+	Words []SolvedWord `json:"words" bson:"words"`
+	// Teams holds the final team scores of a team game, whose winner is
+	// WinningTeam. Which player was on which team is kept with the players.
+	Teams       []MatchTeam `json:"teams,omitempty" bson:"teams,omitempty"`
+	WinningTeam string      `json:"winning_team,omitempty" bson:"winning_team,omitempty"`
+	// Room is the room whose game it was, empty for the lobby game.
+	Room string `json:"room,omitempty" bson:"room,omitempty"`
+
+	StartedAt  time.Time `json:"started_at" bson:"started_at"`
+	EndedAt    time.Time `json:"ended_at" bson:"ended_at"`
+	DurationMS int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+// WinnerIDs are the players credited with the win: the whole winning team
+// of a team game, or else the winner alone.
+func (m *Match) WinnerIDs() []string {
+	if m.WinningTeam == "" {
+		return []string{m.WinnerID}
+	}
+	var ids []string
+	for _, p := range m.Players {
+		if p.Team == m.WinningTeam {
+			ids = append(ids, p.ID)
+		}
+	}
+	return ids
+}
+
+// RatingChanges lists how the match moved the rating of each player it
+// rated, keyed by player ID.
+func (m *Match) RatingChanges() map[string]RatingChange {
+	changes := make(map[string]RatingChange)
+	for _, p := range m.Players {
+		if p.Rating != 0 {
+			changes[p.ID] = RatingChange{MatchID: m.ID.Hex(), Rating: p.Rating, Change: p.RatingChange, At: m.EndedAt}
+		}
+	}
+	return changes
+}
+
+type MatchPlayer struct {
+	ID    string `json:"id" bson:"id"`
+	Name  string `json:"name" bson:"name"`
+	Score int    `json:"score" bson:"score"`
+	Team  string `json:"team,omitempty" bson:"team,omitempty"`
+	// Rating is the player's skill rating after the match, which moved it by
+	// RatingChange. It is zero for a match that was not rated.
+	Rating       int `json:"rating,omitempty" bson:"rating,omitempty"`
+	RatingChange int `json:"rating_change,omitempty" bson:"rating_change,omitempty"`
+}
+
+type MatchTeam struct {
+	Name  string `json:"name" bson:"name"`
+	Score int    `json:"score" bson:"score"`
+}
+
+// SolvedWord is a word a player solved, and when.
+type SolvedWord struct {
+	PlayerID string    `json:"player_id" bson:"player_id"`
+	Player   string    `json:"player" bson:"player"`
+	Word     string    `json:"word" bson:"word"`
+	SolvedAt time.Time `json:"solved_at" bson:"solved_at"`
+}
@@ -1,14 +1,46 @@
 package models
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// InitialRating is the skill rating of a player who has not yet finished a
+// multiplayer match.
+const InitialRating = 1000
+
 type User struct {
 	ID       primitive.ObjectID `json:"id" bson:"_id"`
 	Username string             `json:"username" bson:"username"`
 	Email    string             `json:"email" bson:"email"`
 	Password string             `json:"password" bson:"password"`
 	Wins     int                `json:"wins" bson:"wins"`
-	Score    int                `json:"score" bson:"score"`
+	// Rating is the player's skill rating, updated after every multiplayer
+	// match from the final standings. It is zero until the first one.
+	Rating int `json:"rating" bson:"rating"`
+	// RatingHistory holds the player's most recent rating changes, oldest
+	// first.
+	RatingHistory []RatingChange `json:"rating_history" bson:"rating_history"`
+	Score         int            `json:"score" bson:"score"`
+	// Word is the player's current unscrambled word, so it is never sent to
+	// clients.
+	Word string `json:"-" bson:"word"`
+}
+
+// CurrentRating is the user's skill rating, InitialRating if they are not
+// rated yet.
+func (u *User) CurrentRating() int {
+	if u.Rating == 0 {
+		return InitialRating
+	}
+	return u.Rating
+}
+
+// RatingChange is how a match moved a player's rating.
+type RatingChange struct {
+	MatchID string    `json:"match_id" bson:"match_id"`
+	Rating  int       `json:"rating" bson:"rating"`
+	Change  int       `json:"change" bson:"change"`
+	At      time.Time `json:"at" bson:"at"`
 }
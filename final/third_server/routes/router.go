@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"third_server/controllers"
+	"third_server/logging"
+	"third_server/metrics"
+	"third_server/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter sets up the middleware, the health checks and the game routes.
+// The handlers use whatever storage was passed to controllers.Configure.
+func NewRouter(corsOrigins []string) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery(), logging.Middleware(), tracing.Middleware())
+
+	r.Use(CORS(corsOrigins))
+
+	r.GET("/livez", controllers.Livez)
+	r.GET("/readyz", controllers.Readyz)
+
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	RegisterRoutes(r)
+	return r
+}
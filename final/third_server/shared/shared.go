@@ -3,11 +3,12 @@ package shared
 import (
 	"sync"
 
-	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Message struct {
+	Version int         `json:"v"`
+	ID      string      `json:"id,omitempty"`
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 }
@@ -16,11 +17,31 @@ type Player struct {
 	Name  string             `json:"name"`
 	Score int                `json:"score"`
 	Word  string             `bson:"word"`
+	Team  string             `json:"team"`
+	// Scrambled is the word as spectators are shown it.
+	Scrambled string `json:"-"`
+	// Room is the room whose game the player plays, empty for the lobby
+	// game.
+	Room string `json:"-"`
 }
 
+// Delivery is a message for the clients of one game: the players in Room,
+// or with Room empty everyone who is not playing in a room.
+type Delivery struct {
+	Room    string
+	Message Message
+}
+
+// BroadcastBufferSize is how many game messages may be waiting for the
+// broadcaster before new ones are dropped.
+const BroadcastBufferSize = 64
+
 var (
-	Clients   = make(map[*websocket.Conn]bool)
-	Players   = make(map[*websocket.Conn]Player)
-	Mu        sync.Mutex
-	Broadcast = make(chan Message)
+	Clients = make(map[*Client]bool)
+	Players = make(map[*Client]Player)
+	// Spectators are the clients watching a game without playing, with the
+	// room of the game each watches, "" for the lobby game.
+	Spectators = make(map[*Client]string)
+	Mu         sync.Mutex
+	Broadcast  = make(chan Delivery, BroadcastBufferSize)
 )
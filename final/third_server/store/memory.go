@@ -0,0 +1,504 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"third_server/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserStore keeps users and their matches in memory. It also serves
+// the leaderboard.
+type MemoryUserStore struct {
+	mu      sync.Mutex
+	users   map[primitive.ObjectID]models.User
+	matches []models.Match
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[primitive.ObjectID]models.User)}
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+
+	user.ID = primitive.NewObjectID()
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) find(match func(models.User) bool) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if match(u) {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryUserStore) FindByID(ctx context.Context, id string) (*models.User, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	return s.find(func(u models.User) bool { return u.ID == objID })
+}
+
+func (s *MemoryUserStore) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.find(func(u models.User) bool { return u.Email == email })
+}
+
+func (s *MemoryUserStore) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.find(func(u models.User) bool { return u.Username == username })
+}
+
+func (s *MemoryUserStore) update(id string, change func(*models.User)) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[objID]
+	if !ok {
+		return ErrNotFound
+	}
+	change(&user)
+	s.users[objID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) SetWord(ctx context.Context, id, word string) error {
+	return s.update(id, func(u *models.User) { u.Word = word })
+}
+
+func (s *MemoryUserStore) SetScore(ctx context.Context, id string, score int) error {
+	return s.update(id, func(u *models.User) { u.Score = score })
+}
+
+func (s *MemoryUserStore) SetWordAndScore(ctx context.Context, id, word string, score int) error {
+	return s.update(id, func(u *models.User) {
+		u.Word = word
+		u.Score = score
+	})
+}
+
+func (s *MemoryUserStore) SolveWord(ctx context.Context, id, word, newWord string) (int, error) {
+	var score int
+	solved := false
+	err := s.update(id, func(u *models.User) {
+		if u.Word != word {
+			return
+		}
+		u.Word = newWord
+		u.Score++
+		score, solved = u.Score, true
+	})
+	if err == nil && !solved {
+		err = ErrWordChanged
+	}
+	return score, err
+}
+
+func (s *MemoryUserStore) RecordWin(ctx context.Context, match *models.Match) error {
+	var winnerIDs []primitive.ObjectID
+	for _, id := range match.WinnerIDs() {
+		winnerID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return ErrInvalidID
+		}
+		winnerIDs = append(winnerIDs, winnerID)
+	}
+	if match.ID.IsZero() {
+		match.ID = primitive.NewObjectID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.matches {
+		if m.ID == match.ID {
+			return nil
+		}
+	}
+	found := false
+	for _, winnerID := range winnerIDs {
+		if winner, ok := s.users[winnerID]; ok {
+			winner.Wins++
+			s.users[winnerID] = winner
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	for id, change := range match.RatingChanges() {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		if user, ok := s.users[userID]; ok {
+			user.Rating = change.Rating
+			user.RatingHistory = appendRatingChange(user.RatingHistory, change)
+			s.users[userID] = user
+		}
+	}
+
+	stored := *match
+	stored.Players = append([]models.MatchPlayer(nil), match.Players...)
+	stored.Words = append([]models.SolvedWord(nil), match.Words...)
+	stored.Teams = append([]models.MatchTeam(nil), match.Teams...)
+	s.matches = append(s.matches, stored)
+	return nil
+}
+
+func (s *MemoryUserStore) Leaderboard(ctx context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if users[i].Wins != users[j].Wins {
+			return users[i].Wins > users[j].Wins
+		}
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+// MemoryGameStateStore keeps the game state in memory. States are copied in
+// and out so callers never share slices with the store.
+type MemoryGameStateStore struct {
+	mu      sync.Mutex
+	state   *models.GameState
+	players map[string]models.Player
+	rounds  map[string]string
+	teams   map[string]int
+	wins    map[int64]string
+}
+
+func NewMemoryGameStateStore() *MemoryGameStateStore {
+	return &MemoryGameStateStore{
+		players: make(map[string]models.Player),
+		rounds:  make(map[string]string),
+		teams:   make(map[string]int),
+		wins:    make(map[int64]string),
+	}
+}
+
+func (s *MemoryGameStateStore) Load(ctx context.Context) (*models.GameState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == nil {
+		return nil, ErrNotFound
+	}
+
+	gameState := *s.state
+	gameState.Solved = append([]models.SolvedWord(nil), s.state.Solved...)
+	gameState.Rounds = append([]models.RoundResult(nil), s.state.Rounds...)
+	gameState.TeamScores = maps.Clone(s.state.TeamScores)
+	gameState.Players = []models.Player{}
+	for _, player := range s.players {
+		gameState.Players = append(gameState.Players, player)
+	}
+	sort.Slice(gameState.Players, func(i, j int) bool {
+		return gameState.Players[i].Name < gameState.Players[j].Name
+	})
+	return &gameState, nil
+}
+
+func (s *MemoryGameStateStore) Save(ctx context.Context, gameState *models.GameState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gameState.Revision++
+	stored := *gameState
+	stored.Players = nil
+	stored.Solved = append([]models.SolvedWord(nil), gameState.Solved...)
+	stored.Rounds = append([]models.RoundResult(nil), gameState.Rounds...)
+	stored.TeamScores = maps.Clone(gameState.TeamScores)
+	s.state = &stored
+	return nil
+}
+
+func (s *MemoryGameStateStore) SavePlayer(ctx context.Context, player models.Player) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.players[memoryPlayerKey(player)] = player
+	return nil
+}
+
+func (s *MemoryGameStateStore) RemovePlayer(ctx context.Context, player models.Player) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.players, memoryPlayerKey(player))
+	return nil
+}
+
+func (s *MemoryGameStateStore) ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%d", matchStart.UnixNano(), round)
+	if _, taken := s.rounds[key]; taken {
+		return false, nil
+	}
+	s.rounds[key] = playerID
+	return true, nil
+}
+
+func (s *MemoryGameStateStore) AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%s", matchStart.UnixNano(), team)
+	s.teams[key]++
+	return s.teams[key], nil
+}
+
+func (s *MemoryGameStateStore) ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.wins[matchStart.UnixNano()]; taken {
+		return false, nil
+	}
+	s.wins[matchStart.UnixNano()] = team
+	return true, nil
+}
+
+func memoryPlayerKey(player models.Player) string {
+	if player.ID != "" {
+		return player.ID
+	}
+	return "name:" + player.Name
+}
+
+// MemoryQueueStore keeps the matchmaking queue in memory.
+type MemoryQueueStore struct {
+	mu      sync.Mutex
+	entries map[string]models.QueueEntry
+}
+
+func NewMemoryQueueStore() *MemoryQueueStore {
+	return &MemoryQueueStore{entries: make(map[string]models.QueueEntry)}
+}
+
+func (s *MemoryQueueStore) Enqueue(ctx context.Context, entry models.QueueEntry) (*models.QueueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if earlier, ok := s.entries[entry.PlayerID]; ok {
+		entry.QueuedAt = earlier.QueuedAt
+	}
+	s.entries[entry.PlayerID] = entry
+	return &entry, nil
+}
+
+func (s *MemoryQueueStore) Dequeue(ctx context.Context, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, queued := s.entries[playerID]
+	delete(s.entries, playerID)
+	return queued, nil
+}
+
+func (s *MemoryQueueStore) Queue(ctx context.Context) ([]models.QueueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := make([]models.QueueEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		queue = append(queue, entry)
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		if !queue[i].QueuedAt.Equal(queue[j].QueuedAt) {
+			return queue[i].QueuedAt.Before(queue[j].QueuedAt)
+		}
+		return queue[i].PlayerID < queue[j].PlayerID
+	})
+	return queue, nil
+}
+
+func (s *MemoryQueueStore) Take(ctx context.Context, playerIDs []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range playerIDs {
+		if _, queued := s.entries[id]; !queued {
+			return false, nil
+		}
+	}
+	for _, id := range playerIDs {
+		delete(s.entries, id)
+	}
+	return true, nil
+}
+
+// MemoryRoomStore keeps the open rooms in memory.
+type MemoryRoomStore struct {
+	mu    sync.Mutex
+	rooms map[string]models.Room
+}
+
+func NewMemoryRoomStore() *MemoryRoomStore {
+	return &MemoryRoomStore{rooms: make(map[string]models.Room)}
+}
+
+func (s *MemoryRoomStore) Rooms(ctx context.Context) ([]models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms := make([]models.Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, cloneRoom(room))
+	}
+	return rooms, nil
+}
+
+func (s *MemoryRoomStore) UpdateRoom(ctx context.Context, id string, update func(*models.Room) error) (*models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := cloneRoom(s.rooms[id])
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	updated.ID = id
+	updated.Revision++
+	if len(updated.Members) == 0 {
+		delete(s.rooms, id)
+	} else {
+		s.rooms[id] = cloneRoom(updated)
+	}
+	return &updated, nil
+}
+
+// cloneRoom copies a room so that changes to the copy leave it be.
+func cloneRoom(room models.Room) models.Room {
+	room.Members = append([]models.RoomMember(nil), room.Members...)
+	room.Invites = maps.Clone(room.Invites)
+	return room
+}
+
+// MemoryEventBus delivers events to subscribers in the same process. Events
+// are copied so subscribers may keep them.
+type MemoryEventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+func NewMemoryEventBus() *MemoryEventBus {
+	return &MemoryEventBus{subscribers: make(map[chan []byte]struct{})}
+}
+
+func (b *MemoryEventBus) Publish(ctx context.Context, event []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- append([]byte(nil), event...):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *MemoryEventBus) Subscribe(ctx context.Context, handle func([]byte)) {
+	ch := make(chan []byte, 64)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-ch:
+			handle(event)
+		}
+	}
+}
+
+// MemoryEventLog keeps the game event log in memory.
+type MemoryEventLog struct {
+	mu     sync.Mutex
+	events []models.GameEvent
+}
+
+func NewMemoryEventLog() *MemoryEventLog {
+	return &MemoryEventLog{}
+}
+
+func (l *MemoryEventLog) Append(ctx context.Context, event *models.GameEvent) error {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.events {
+		if e.ID == event.ID {
+			return nil
+		}
+	}
+	l.events = append(l.events, *event)
+	return nil
+}
+
+// Events returns the logged events in the order they were appended.
+func (l *MemoryEventLog) Events() []models.GameEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]models.GameEvent(nil), l.events...)
+}
+
+// NewMemory returns stores that keep everything in memory, for tests and for
+// running the server without MongoDB or Redis.
+func NewMemory() Stores {
+	users := NewMemoryUserStore()
+	return Stores{
+		Users:       users,
+		GameState:   NewMemoryGameStateStore(),
+		Leaderboard: users,
+		EventLog:    NewMemoryEventLog(),
+		Events:      NewMemoryEventBus(),
+		Matchmaking: NewMemoryQueueStore(),
+		Rooms:       NewMemoryRoomStore(),
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"third_server/db"
+	"third_server/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisGameStateStore keeps the game state in Redis. It needs
+// db.InitRedisCluster to have been called.
+type RedisGameStateStore struct{}
+
+func (RedisGameStateStore) Load(ctx context.Context) (*models.GameState, error) {
+	gameState, err := db.LoadGameState(ctx)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return gameState, err
+}
+
+func (RedisGameStateStore) Save(ctx context.Context, gameState *models.GameState) error {
+	return db.SaveGameState(ctx, gameState)
+}
+
+func (RedisGameStateStore) SavePlayer(ctx context.Context, player models.Player) error {
+	return db.SavePlayer(ctx, player)
+}
+
+func (RedisGameStateStore) RemovePlayer(ctx context.Context, player models.Player) error {
+	return db.RemovePlayer(ctx, player)
+}
+
+func (RedisGameStateStore) ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error) {
+	return db.ClaimRound(ctx, matchStart, round, playerID)
+}
+
+func (RedisGameStateStore) AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error) {
+	return db.AddTeamPoint(ctx, matchStart, team)
+}
+
+func (RedisGameStateStore) ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error) {
+	return db.ClaimTeamWin(ctx, matchStart, team)
+}
+
+// RedisQueueStore keeps the matchmaking queue in Redis.
+type RedisQueueStore struct{}
+
+func (RedisQueueStore) Enqueue(ctx context.Context, entry models.QueueEntry) (*models.QueueEntry, error) {
+	return db.Enqueue(ctx, entry)
+}
+
+func (RedisQueueStore) Dequeue(ctx context.Context, playerID string) (bool, error) {
+	return db.Dequeue(ctx, playerID)
+}
+
+func (RedisQueueStore) Queue(ctx context.Context) ([]models.QueueEntry, error) {
+	return db.LoadQueue(ctx)
+}
+
+func (RedisQueueStore) Take(ctx context.Context, playerIDs []string) (bool, error) {
+	return db.TakeQueued(ctx, playerIDs)
+}
+
+// RedisRoomStore keeps the open rooms in Redis.
+type RedisRoomStore struct{}
+
+func (RedisRoomStore) Rooms(ctx context.Context) ([]models.Room, error) {
+	return db.LoadRooms(ctx)
+}
+
+func (RedisRoomStore) UpdateRoom(ctx context.Context, id string, update func(*models.Room) error) (*models.Room, error) {
+	return db.UpdateRoom(ctx, id, update)
+}
+
+// RedisEventBus publishes game events on db.EventsChannel.
+type RedisEventBus struct{}
+
+func (RedisEventBus) Publish(ctx context.Context, event []byte) error {
+	return db.PublishEvent(ctx, event)
+}
+
+func (RedisEventBus) Subscribe(ctx context.Context, handle func([]byte)) {
+	db.SubscribeEvents(ctx, handle)
+}
+
+// NewProduction returns the MongoDB and Redis backed stores. db.Connect and
+// db.InitRedisCluster must have been called first.
+func NewProduction() Stores {
+	users := NewMongoUserStore()
+	return Stores{
+		Users:       users,
+		GameState:   RedisGameStateStore{},
+		Leaderboard: users,
+		EventLog:    NewMongoEventLog(),
+		Events:      RedisEventBus{},
+		Matchmaking: RedisQueueStore{},
+		Rooms:       RedisRoomStore{},
+		Health: []HealthCheck{
+			{Name: "mongo", Check: db.PingMongo},
+			{Name: "redis", Check: db.PingRedis},
+		},
+	}
+}
@@ -0,0 +1,151 @@
+// Package store defines the storage the game server depends on, with
+// MongoDB/Redis implementations for production and in-memory ones for tests
+// and running without any databases.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"third_server/models"
+)
+
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrInvalidID  = errors.New("invalid id")
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrWordChanged means the word was solved or skipped by another request
+	// before the guess was checked.
+	ErrWordChanged = errors.New("word changed")
+)
+
+// ratingHistoryLimit is how many rating changes are kept per user.
+const ratingHistoryLimit = 50
+
+// appendRatingChange adds a change to a rating history, dropping the oldest
+// beyond ratingHistoryLimit.
+func appendRatingChange(history []models.RatingChange, change models.RatingChange) []models.RatingChange {
+	history = append(history, change)
+	if len(history) > ratingHistoryLimit {
+		history = append([]models.RatingChange(nil), history[len(history)-ratingHistoryLimit:]...)
+	}
+	return history
+}
+
+// UserStore holds signed up users and their per-game progress.
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	SetWord(ctx context.Context, id, word string) error
+	SetScore(ctx context.Context, id string, score int) error
+	SetWordAndScore(ctx context.Context, id, word string, score int) error
+	// SolveWord replaces the user's word with newWord and adds a point, but
+	// only if the word is still word, in one atomic update, so that a word
+	// solved twice at once scores once. It returns the new score, or
+	// ErrWordChanged.
+	SolveWord(ctx context.Context, id, word, newWord string) (int, error)
+	// RecordWin adds a win to the match's winner, sets the new rating of
+	// every player the match rated and stores the match, all or nothing. It
+	// assigns the match an ID if it has none, and does nothing for a match
+	// that is already stored, so it is safe to retry.
+	RecordWin(ctx context.Context, match *models.Match) error
+}
+
+// GameStateStore persists the shared game state. Players are saved one at a
+// time so that servers updating different players don't overwrite each
+// other.
+type GameStateStore interface {
+	Load(ctx context.Context) (*models.GameState, error)
+	Save(ctx context.Context, gameState *models.GameState) error
+	SavePlayer(ctx context.Context, player models.Player) error
+	RemovePlayer(ctx context.Context, player models.Player) error
+	// ClaimRound makes playerID the winner of a race round unless someone
+	// else already won it, and reports whether it did. matchStart tells the
+	// rounds of one match from those of the next.
+	ClaimRound(ctx context.Context, matchStart time.Time, round int, playerID string) (bool, error)
+	// AddTeamPoint gives a team in the match that started at matchStart a
+	// point and returns its new score, counting points scored on every
+	// server.
+	AddTeamPoint(ctx context.Context, matchStart time.Time, team string) (int, error)
+	// ClaimTeamWin makes team the winner of the team match that started at
+	// matchStart unless a team already won it, and reports whether it did.
+	ClaimTeamWin(ctx context.Context, matchStart time.Time, team string) (bool, error)
+}
+
+// QueueStore keeps the matchmaking queue, shared by every game server.
+type QueueStore interface {
+	// Enqueue puts a player in the queue. A player already queued keeps
+	// their place, and the entry as stored is returned.
+	Enqueue(ctx context.Context, entry models.QueueEntry) (*models.QueueEntry, error)
+	// Dequeue takes a player out of the queue and reports whether they were
+	// in it.
+	Dequeue(ctx context.Context, playerID string) (bool, error)
+	// Queue lists the queued players, longest waiting first.
+	Queue(ctx context.Context) ([]models.QueueEntry, error)
+	// Take takes the players out of the queue if every one of them is still
+	// in it, and reports whether it did, so that of the servers matching
+	// the same players only one gets them.
+	Take(ctx context.Context, playerIDs []string) (bool, error)
+}
+
+// RoomStore keeps the open rooms, shared by every game server.
+type RoomStore interface {
+	// Rooms lists the open rooms.
+	Rooms(ctx context.Context) ([]models.Room, error)
+	// UpdateRoom applies update to the room with the given ID, or to an
+	// empty room if there is none, and stores the result as one change, so
+	// that changes made to a room at once on different servers are applied
+	// one after the other. update may run more than once. A room left
+	// without members is deleted. An error from update is returned as is
+	// and nothing is stored.
+	UpdateRoom(ctx context.Context, id string, update func(*models.Room) error) (*models.Room, error)
+}
+
+// LeaderboardStore ranks users by wins.
+type LeaderboardStore interface {
+	Leaderboard(ctx context.Context) ([]models.User, error)
+}
+
+// EventLog is the append-only log of everything that happens in a game,
+// kept so that matches can be replayed.
+type EventLog interface {
+	// Append adds an event to the log. It assigns the event an ID if it has
+	// none, and does nothing for an event that is already logged, so it is
+	// safe to retry.
+	Append(ctx context.Context, event *models.GameEvent) error
+}
+
+// EventBus carries encoded game events between game servers.
+type EventBus interface {
+	Publish(ctx context.Context, event []byte) error
+	// Subscribe calls handle for every published event, including the
+	// subscriber's own, until ctx is done.
+	Subscribe(ctx context.Context, handle func([]byte))
+}
+
+// HealthCheck pings a service the stores depend on. Readiness runs every
+// check of the configured stores.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Stores bundles everything the handlers need.
+type Stores struct {
+	Users       UserStore
+	GameState   GameStateStore
+	Leaderboard LeaderboardStore
+	EventLog    EventLog
+	Events      EventBus
+	Matchmaking QueueStore
+	Rooms       RoomStore
+	// Health is empty for stores that cannot fail, such as the in-memory
+	// ones.
+	Health []HealthCheck
+	// Queue buffers the writes made while a store is down. It is nil when
+	// writes are not buffered.
+	Queue *WriteBehind
+}